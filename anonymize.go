@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ----------------------------------------------------------------
+// Anonymized Exports
+//
+// Recorded games and datasets can be shared for others to study
+// without giving away who we played.  Exported with a key, every
+// opponent's ID and name, and the game's ID, which would otherwise
+// lead straight back to the game online, are replaced by pseudonyms
+// that stay the same across exports made with the same key, so a
+// snake can still be followed from game to game.  Opponents' shouts
+// are dropped, as they often name their authors.
+//
+//   ANONYMIZE_KEY=... spacey-snake games export -out shared -anonymize
+//   ANONYMIZE_KEY=... spacey-snake dataset -anonymize > shared.jsonl
+//
+// The key has to be kept secret: names are few enough that without
+// one, anyone could work back from the pseudonyms.
+// ----------------------------------------------------------------
+
+type Anonymizer struct {
+	key		[]byte
+	you		string		// our snake, which keeps its name
+}
+
+func NewAnonymizer (key string) (*Anonymizer, error) {
+	if len(key) == 0 { return nil, fmt.Errorf("anonymizing needs a key, from -key or ANONYMIZE_KEY") }
+	return &Anonymizer { key: []byte(key) }, nil
+}
+
+func (a *Anonymizer) pseudonym (kind, s string) string {
+	if len(s) == 0 { return s }
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + ":" + s))
+	return kind + "-" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+func (a *Anonymizer) Game (id string) string { return a.pseudonym("game", id) }
+
+func (a *Anonymizer) Snake (id string) string {
+	if id == a.you { return id }
+	return a.pseudonym("snake", id)
+}
+
+func (a *Anonymizer) Name (id, name string) string {
+	if id == a.you { return name }
+	return a.pseudonym("name", name)
+}
+
+// A copy of a record with the opponents and the game made anonymous
+func (a *Anonymizer) Record (r *GameRecord) *GameRecord {
+	a.you = r.You
+	c := *r
+	c.Game.ID = a.Game(r.Game.ID)
+	c.Audits = nil		// they hash and seed from the real IDs
+	c.Frames = make([]Frame, len(r.Frames))
+	for fx,frame := range r.Frames {
+		c.Frames[fx] = frame
+		c.Frames[fx].Snakes = make([]FrameSnake, len(frame.Snakes))
+		for sx,snake := range frame.Snakes {
+			if snake.ID != r.You {
+				snake.Name = a.Name(snake.ID, snake.Name)
+				snake.ID = a.Snake(snake.ID)
+				snake.Shout = ""
+			}
+			if snake.Death != nil {
+				death := *snake.Death
+				death.EliminatedBy = a.Snake(death.EliminatedBy)
+				snake.Death = &death
+			}
+			c.Frames[fx].Snakes[sx] = snake
+		}
+	}
+	return &c
+}
+
+// Anonymize an example from the dataset, learned from the snake it
+// names
+func (a *Anonymizer) Example (you string, example Example) Example {
+	a.you = you
+	example.Game = a.Game(example.Game)
+	example.Name = a.Name(example.Snake, example.Name)
+	example.Snake = a.Snake(example.Snake)
+	return example
+}
+
+func ExportCommand (dir string, args []string) int {
+	flags := flag.NewFlagSet("games export", flag.ExitOnError)
+	out := flags.String("out", "", "directory to export to")
+	anonymize := flags.Bool("anonymize", false, "replace the opponents and games by pseudonyms")
+	key := flags.String("key", os.Getenv("ANONYMIZE_KEY"), "the secret pseudonyms are made with, ANONYMIZE_KEY by default")
+	flags.Parse(args)
+	if len(*out) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake games export -out dir [-anonymize] [-key k] [id...]\n")
+		return 2
+	}
+
+	var a *Anonymizer
+	if *anonymize {
+		var err error
+		if a, err = NewAnonymizer(*key); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if flags.NArg() > 0 {
+		paths = nil
+		for _,id := range flags.Args() { paths = append(paths, filepath.Join(dir, filepath.Base(id) + ".json")) }
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _,path := range paths {
+		r, err := ReadGameRecord(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if a != nil { r = a.Record(r) }
+		if err := r.Write(filepath.Join(*out, filepath.Base(r.Game.ID) + ".json")); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	fmt.Printf("Exported %d games to %s\n", len(paths), *out)
+	return 0
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Outcomes
+//
+// The engine doesn't tell us how snakes died, so when a snake goes 
+// missing from the board we work out the most likely cause from
+// the previous frame.  For our own snake we know the move we made,
+// so we can tell exactly what we ran into.
+// ----------------------------------------------------------------
+
+func InferDeaths (prev Frame, t int, b Board, you string, move string) []Elimination {
+	alive := make(map[string]Snake)
+	for _,snake := range b.Snakes { alive[snake.ID] = snake }
+
+	var deaths []Elimination
+	for _,snake := range prev.Snakes {
+		if _, ok := alive[snake.ID]; ok || snake.Death != nil || len(snake.Body) == 0 { continue }
+
+		e := Elimination { ID: snake.ID, Turn: t, Cause: "collision" }
+		head := Coord{ snake.Body[0].X, snake.Body[0].Y }
+		switch {
+		case snake.Health <= 1:
+			e.Cause = "starvation"
+		case snake.ID == you && len(move) > 0:
+			next := Step(head, move)
+			if next.X < 0 || next.X >= b.Width || next.Y < 0 || next.Y >= b.Height {
+				e.Cause = "wall"
+				break
+			}
+			for _,segment := range snake.Body[:len(snake.Body)-1] {
+				if (Coord{ segment.X, segment.Y }) == next { e.Cause = "self" }
+			}
+			for _,other := range b.Snakes {
+				for i,segment := range other.Body {
+					if segment != next { continue }
+					e.By = other.ID
+					if i == 0 { e.Cause = "head-to-head" } else { e.Cause = "body" }
+				}
+			}
+		default:
+			for _,other := range b.Snakes {
+				if ManDist(other.Body[0], head) == 1 && len(other.Body) >= len(snake.Body) {
+					e.Cause = "head-to-head"
+					e.By = other.ID
+				}
+			}
+		}
+		deaths = append(deaths, e)
+	}
+	return deaths
+}
+
+// ----------------------------------------------------------------
+// Archive
+//
+// Every game recorded under RECORD_DIR, summarized for listing and
+// searching from the command line:
+//
+//   spacey-snake games list
+//   spacey-snake games show <id>
+//   spacey-snake games grep -died-by=head-to-head -result=loss
+//   spacey-snake games report -since 6h
+//   spacey-snake games compact
+//
+// RECORD_DIR has to be set, as it is for the server recording them.
+// Files there that aren't game records, such as a config kept beside
+// them, are skipped with a warning.
+// ----------------------------------------------------------------
+
+type GameSummary struct {
+	ID		string
+	Ruleset	string
+	Turns	int
+	Snakes	int
+	Result	string		// win, loss or draw
+	DiedBy	string		// how we died, if we did
+	By		string		// who we died to, if anyone
+	Died	int			// the turn we died on, if we did
+	Played	time.Time	// when the record was written
+	Reasons	map[string]int	// how many of our moves were made for each category of reason
+	Blunders	int		// how many turns another move clearly did better
+	Experiments	map[string]bool	`json:",omitempty"`	// whether we played in each experiment that could run
+}
+
+func Summarize (r *GameRecord) GameSummary {
+	summary := GameSummary { ID: r.Game.ID, Ruleset: r.Game.Ruleset["name"], Result: "draw" }
+	summary.Reasons = make(map[string]int)
+	summary.Blunders = len(r.Blunders)
+	summary.Experiments = r.Experiments
+	for _,reason := range r.Reasons { summary.Reasons[reason.Category()]++ }
+	if len(r.Frames) == 0 { return summary }
+
+	last := r.Frames[len(r.Frames)-1]
+	summary.Turns = last.Turn
+	summary.Snakes = len(last.Snakes)
+
+	nalive := 0
+	weAlive := false
+	for _,snake := range last.Snakes {
+		if snake.Death != nil {
+			if snake.ID == r.You {
+				summary.DiedBy = snake.Death.Cause
+				summary.By = snake.Death.EliminatedBy
+				summary.Died = snake.Death.Turn
+			}
+			continue
+		}
+		nalive++
+		if snake.ID == r.You { weAlive = true }
+	}
+
+	switch {
+	case weAlive && nalive == 1:	summary.Result = "win"
+	case !weAlive && nalive > 0:	summary.Result = "loss"
+	}
+	return summary
+}
+
+func ReadArchive (dir string) ([]GameSummary, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil { return nil, err }
+
+	var summaries []GameSummary
+	for _,path := range paths {
+		r, err := ReadArchivedGame(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: Skipping %v\n", err)
+			continue
+		}
+		summary := Summarize(r)
+		if info, err := os.Stat(path); err == nil { summary.Played = info.ModTime() }
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].ID < summaries[j].ID })
+	return summaries, nil
+}
+
+func (g GameSummary) String () string {
+	died := ""
+	if len(g.DiedBy) > 0 { died = " died-by=" + g.DiedBy }
+	if len(g.By) > 0 { died += " by=" + g.By }
+	if g.Blunders > 0 { died += fmt.Sprintf(" blunders=%d", g.Blunders) }
+	if len(g.Experiments) > 0 { died += " experiments=" + FormatArms(g.Experiments) }
+	return fmt.Sprintf("%s %s turns=%d snakes=%d %s%s", g.ID, g.Ruleset, g.Turns, g.Snakes, g.Result, died)
+}
+
+// A game from an archive, or why the file isn't one, such as a config
+// kept alongside the records
+func ReadArchivedGame (path string) (*GameRecord, error) {
+	r, err := ReadGameRecord(path)
+	if err != nil { return nil, err }
+	if len(r.Game.ID) == 0 || len(r.Frames) == 0 { return nil, fmt.Errorf("%s: not a game record", path) }
+	return r, nil
+}
+
+func GamesCommand (args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake games list|show|grep|report|analyze|disagreements|fixtures|compact|export|audit ...\n")
+		return 2
+	}
+	dir := RecordDir()
+	if len(dir) == 0 {
+		fmt.Fprintf(os.Stderr, "RECORD_DIR must be set to the directory games are recorded in\n")
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		summaries, err := ReadArchive(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _,summary := range summaries { fmt.Println(summary) }
+
+	case "show":
+		if len(args) != 2 {
+			fmt.Fprintf(os.Stderr, "Usage: spacey-snake games show id\n")
+			return 2
+		}
+		r, err := ReadGameRecord(RecordPath(args[1]))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Println(Summarize(r))
+		if n := len(r.Frames); n > 0 {
+			fmt.Print(RenderBoard(FrameBoard(r, r.Frames[n-1])))
+			for _,snake := range r.Frames[n-1].Snakes {
+				if snake.Death == nil { continue }
+				fmt.Printf("%s died on turn %d by %s %s\n", snake.ID, snake.Death.Turn,
+						   snake.Death.Cause, snake.Death.EliminatedBy)
+			}
+		}
+		for _,blunder := range r.Blunders { fmt.Printf("Blunder %v\n", blunder) }
+		if metrics, err := ioutil.ReadFile(strings.TrimSuffix(RecordPath(args[1]), ".json") + ".csv"); err == nil {
+			fmt.Print(string(metrics))
+		}
+
+	case "grep":
+		flags := flag.NewFlagSet("games grep", flag.ExitOnError)
+		diedBy := flags.String("died-by", "", "how we died")
+		result := flags.String("result", "", "win, loss or draw")
+		ruleset := flags.String("ruleset", "", "ruleset name")
+		by := flags.String("by", "", "the snake we died to")
+		experiment := flags.String("experiment", "", "an experiment the game was in, or name=off for out of it")
+		flags.Parse(args[1:])
+
+		summaries, err := ReadArchive(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _,summary := range summaries {
+			if len(*diedBy) > 0 && summary.DiedBy != *diedBy { continue }
+			if len(*result) > 0 && summary.Result != *result { continue }
+			if len(*ruleset) > 0 && summary.Ruleset != *ruleset { continue }
+			if len(*by) > 0 && summary.By != *by { continue }
+			if len(*experiment) > 0 && !summary.InExperiment(*experiment) { continue }
+			fmt.Println(summary)
+		}
+
+	case "analyze":
+		return AnalyzeCommand(args[1:])
+
+	case "fixtures":
+		return BlunderFixturesCommand(args[1:])
+
+	case "compact":
+		return CompactCommand(dir, args[1:])
+
+	case "export":
+		return ExportCommand(dir, args[1:])
+
+	case "audit":
+		return AuditCommand(args[1:])
+
+	case "disagreements":
+		list, err := ListDisagreements(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Print(list)
+
+	case "report":
+		flags := flag.NewFlagSet("games report", flag.ExitOnError)
+		since := flags.Duration("since", 0, "only games played within this long, e.g. 6h")
+		window := flags.Duration("window", 24 * time.Hour, "group games played within the same window")
+		flags.Parse(args[1:])
+
+		summaries, err := ReadArchive(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if *since > 0 {
+			var recent []GameSummary
+			for _,summary := range summaries {
+				if time.Since(summary.Played) <= *since { recent = append(recent, summary) }
+			}
+			summaries = recent
+		}
+		fmt.Print(Report(summaries, *window))
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown games command: %s\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// Rebuild the board of a recorded frame, leaving out dead snakes
+func FrameBoard (r *GameRecord, f Frame) Board {
+	b := Board { Width: r.Game.Width, Height: r.Game.Height }
+	for _,c := range f.Food { b.Food = append(b.Food, Coord{ c.X, c.Y }) }
+	for _,c := range f.Hazards { b.Hazards = append(b.Hazards, Coord{ c.X, c.Y }) }
+	for _,snake := range f.Snakes {
+		if snake.Death != nil { continue }
+		s := Snake { ID: snake.ID, Name: snake.Name, Health: snake.Health, Shout: snake.Shout }
+		for _,c := range snake.Body { s.Body = append(s.Body, Coord{ c.X, c.Y }) }
+		b.Snakes = append(b.Snakes, s)
+	}
+	return b
+}
+
+// ----------------------------------------------------------------
+// Reports
+//
+// Games grouped by ruleset and the time window they were played in,
+// e.g. the rounds of an event, with how our results and survival
+// went across each group, how games went in and out of each
+// experiment, and what we most often died of.
+// ----------------------------------------------------------------
+
+func Report (summaries []GameSummary, window time.Duration) string {
+	type group struct {
+		ruleset	string
+		start	time.Time
+		games	[]GameSummary
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for _,summary := range summaries {
+		start := summary.Played.Truncate(window)
+		key := summary.Ruleset + " " + start.Format(time.RFC3339)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &group { ruleset: summary.Ruleset, start: start }
+			keys = append(keys, key)
+		}
+		groups[key].games = append(groups[key].games, summary)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _,key := range keys {
+		g := groups[key]
+		results := make(map[string]int)
+		causes := make(map[string]int)
+		longest := 0
+		for _,game := range g.games {
+			results[game.Result]++
+			if len(game.DiedBy) > 0 { causes[game.DiedBy]++ }
+			if game.Turns > longest { longest = game.Turns }
+		}
+
+		fmt.Fprintf(&b, "%s from %s: %d games, %d wins, %d losses, %d draws\n",
+					g.ruleset, g.start.Format("2006-01-02 15:04"), len(g.games),
+					results["win"], results["loss"], results["draw"])
+
+		// The fraction of games we were still alive in at each tenth of the longest game
+		fmt.Fprintf(&b, "  survival:")
+		for i := 1; i <= 10; i++ {
+			turn := longest * i / 10
+			alive := 0
+			for _,game := range g.games {
+				if len(game.DiedBy) == 0 || game.Died > turn { alive++ }
+			}
+			fmt.Fprintf(&b, " t%d=%.0f%%", turn, 100 * float64(alive) / float64(len(g.games)))
+		}
+		fmt.Fprintf(&b, "\n")
+
+		// What our moves were made for, across the group
+		reasons := make(map[string]int)
+		moves := 0
+		for _,game := range g.games {
+			for category,n := range game.Reasons {
+				reasons[category] += n
+				moves += n
+			}
+		}
+		if moves > 0 {
+			var categories []string
+			for category := range reasons { categories = append(categories, category) }
+			sort.Strings(categories)
+			fmt.Fprintf(&b, "  moves:")
+			for _,category := range categories {
+				fmt.Fprintf(&b, " %s=%.0f%%", category, 100 * float64(reasons[category]) / float64(moves))
+			}
+			fmt.Fprintf(&b, "\n")
+		}
+
+		// How the games in each experiment went against those out of it
+		arms := make(map[string]map[bool][2]int)
+		for _,game := range g.games {
+			for name,in := range game.Experiments {
+				if arms[name] == nil { arms[name] = make(map[bool][2]int) }
+				counts := arms[name][in]
+				counts[0]++
+				if game.Result == "win" { counts[1]++ }
+				arms[name][in] = counts
+			}
+		}
+		var experiments []string
+		for name := range arms { experiments = append(experiments, name) }
+		sort.Strings(experiments)
+		for _,name := range experiments {
+			on, off := arms[name][true], arms[name][false]
+			fmt.Fprintf(&b, "  experiment %s: on %d games %d wins, off %d games %d wins\n",
+						name, on[0], on[1], off[0], off[1])
+		}
+
+		var names []string
+		for cause := range causes { names = append(names, cause) }
+		sort.Slice(names, func(i, j int) bool {
+			if causes[names[i]] != causes[names[j]] { return causes[names[i]] > causes[names[j]] }
+			return names[i] < names[j]
+		})
+		for _,cause := range names {
+			fmt.Fprintf(&b, "  died by %s: %d\n", cause, causes[cause])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// ----------------------------------------------------------------
+// Decision Audit
+//
+// Why did we make that move, and would we make it again?  With each
+// move we record a hash of everything the decision was made from:
+// the board as we were sent it, the weights, the commit of the code,
+// the tier we thought at and the seed of the rollouts.  The audit
+// command plays a recorded game's turns again through the engine, at
+// the tiers recorded, and reports each turn whose move can't be
+// reproduced, and whether its inputs had changed:
+//
+//   spacey-snake games audit 2f8e3c1a-...
+//
+// A turn cut short by its deadline is reported as such, since how far
+// the rollouts got depended on the clock.
+// ----------------------------------------------------------------
+
+type DecisionAudit struct {
+	Hash		string
+	Seed		int64
+	Tier		string
+	CutShort	bool	`json:",omitempty"`		// the deadline stopped our thinking
+}
+
+// The seed of the rollouts for a turn, so the same turn always gets
+// the same rollouts
+func RolloutSeed (g Game, t int) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(g.ID))
+	return int64(h.Sum64()) + int64(t)
+}
+
+// A hash of everything a decision is made from, in the order it was
+// given to us, since the order can break ties
+func DecisionHash (g Game, t int, b Board, you string, weights Weights, tier string, seed int64) string {
+	h := sha256.New()
+	data, _ := json.Marshal(weights)
+	fmt.Fprintf(h, "commit %s\nweights %s\n", Build().Commit, data)
+	fmt.Fprintf(h, "ruleset %s source %s turn %d you %s tier %s seed %d\n", g.Ruleset.Name, g.Source, t, you, tier, seed)
+	fmt.Fprintf(h, "board %d %d\nfood", b.Width, b.Height)
+	writeCoords(h, b.Food)
+	fmt.Fprintf(h, "\nhazards")
+	writeCoords(h, b.Hazards)
+	for _,snake := range b.Snakes {
+		fmt.Fprintf(h, "\nsnake %q %q %d", snake.ID, snake.Name, snake.Health)
+		writeCoords(h, snake.Body)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:12])
+}
+
+func writeCoords (w io.Writer, coords []Coord) {
+	for _,c := range coords { fmt.Fprintf(w, " %d,%d", c.X, c.Y) }
+}
+
+func (d MoveDecision) Audit (g Game, t int, b Board, you string) DecisionAudit {
+	seed := RolloutSeed(g, t)
+	return DecisionAudit {
+		Hash:		DecisionHash(g, t, b, you, d.state.weights, d.Tier, seed),
+		Seed:		seed,
+		Tier:		d.Tier,
+		CutShort:	d.CutShort,
+	}
+}
+
+func RecordAudit (id string, g Game, t int, b Board, d MoveDecision) {
+	if d.state == nil { return }
+	audit := d.Audit(g, t, b, id)
+
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok || context.record == nil { return }
+	if context.record.Audits == nil { context.record.Audits = make(map[int]DecisionAudit) }
+	context.record.Audits[t] = audit
+}
+
+type AuditResult struct {
+	Turn		int
+	Recorded	string
+	Replayed	string
+	Changed		bool	// the inputs hash differently now
+	CutShort	bool
+}
+
+func (a AuditResult) String () string {
+	switch {
+	case a.CutShort:
+		return fmt.Sprintf("Turn %d: cut short by the deadline, played %s, now %s", a.Turn, a.Recorded, a.Replayed)
+	case a.Changed:
+		return fmt.Sprintf("Turn %d: inputs changed, played %s, now %s", a.Turn, a.Recorded, a.Replayed)
+	}
+	return fmt.Sprintf("Turn %d: not reproduced, played %s, now %s", a.Turn, a.Recorded, a.Replayed)
+}
+
+// Play each audited turn of a record again, in order so that our
+// context builds up as it did in the game, returning the turns audited
+// and those whose move differs
+func AuditGame (r *GameRecord) (int, []AuditResult) {
+	g := Game { ID: r.Game.ID, Ruleset: Ruleset { Name: r.Game.Ruleset["name"] }, Source: r.Game.Source }
+	ForgetContext(r.You)
+	defer ForgetContext(r.You)
+
+	audited := 0
+	var results []AuditResult
+	for fx,frame := range r.Frames {
+		b := FrameBoard(r, frame)
+		if fx == 0 { NewContext(r.You, b) }
+		audit, ok := r.Audits[frame.Turn]
+		for _,you := range b.Snakes {
+			if you.ID != r.You || !ok { continue }
+			audited++
+			decision := ThinkAt(audit.Tier, g, frame.Turn, b, you, nil)
+			if decision.Move == r.Moves[frame.Turn] { continue }
+			hash := DecisionHash(g, frame.Turn, b, r.You, decision.state.weights, audit.Tier, audit.Seed)
+			results = append(results, AuditResult {
+				Turn:		frame.Turn,
+				Recorded:	r.Moves[frame.Turn],
+				Replayed:	decision.Move,
+				Changed:	hash != audit.Hash,
+				CutShort:	audit.CutShort,
+			})
+		}
+		UpdateContext(r.You, b.Snakes, b.Food)
+	}
+	return audited, results
+}
+
+func AuditCommand (args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake games audit id\n")
+		return 2
+	}
+	r, err := ReadGameRecord(RecordPath(args[0]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(r.Audits) == 0 {
+		fmt.Fprintf(os.Stderr, "%s has no decisions recorded for audit\n", args[0])
+		return 1
+	}
+
+	engineLogging = false
+	audited, results := AuditGame(r)
+	for _,result := range results { fmt.Println(result) }
+	fmt.Printf("%d of %d turns reproduced\n", audited - len(results), audited)
+	return 0
+}
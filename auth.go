@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Admin Authentication
+//
+// The endpoints for operating the server, rather than playing, can
+// show how we think and can cost CPU, so they answer only to those
+// with the token in ADMIN_TOKEN, given as a bearer token or a query
+// parameter:
+//
+//   curl -H "Authorization: Bearer $ADMIN_TOKEN" http://localhost:8080/metrics
+//   curl -X POST http://localhost:8080/selftest?token=$ADMIN_TOKEN
+//
+// Without a token set they answer to no one, unless the protection
+// trusts this machine, when they answer to loopback addresses.  A
+// proxy on this machine makes every request look local, so that is
+// only safe with "proxied" set or no such proxy:
+//
+//   "protection": { "trustLoopback": true }
+//
+// They are /analyze and everything under it, /metrics, /selftest
+// and the recorded games under /games/.  The endpoints the engine
+// calls and the health checks stay open.  The board viewer reads
+// recorded games without credentials, so a server that wants it to
+// can open them up in its protection:
+//
+//   "protection": { "publicGames": true }
+// ----------------------------------------------------------------
+
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// Does a request carry the admin token, or come from a machine we
+// trust if there is none?
+func IsAdmin (r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") { token = strings.TrimPrefix(auth, "Bearer ") }
+	return AdminAllowed(config.Protection.Address(r), token)
+}
+
+// Is a caller from this address with this token an admin?
+func AdminAllowed (address string, token string) bool {
+	if len(adminToken) == 0 { return config.Protection.Trusted(address) }
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// Only let admins through to a handler
+func AdminOnly (handler http.HandlerFunc) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if !IsAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			reject(w, "auth", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Only let admins through to recorded games, unless the config opens
+// them to anyone
+func ViewerOnly (handler http.HandlerFunc) http.HandlerFunc {
+	admin := AdminOnly(handler)
+	return func (w http.ResponseWriter, r *http.Request) {
+		if config.Protection.PublicGames {
+			handler(w, r)
+			return
+		}
+		admin(w, r)
+	}
+}
+
+// ----------------------------------------------------------------
+// Engine Verification
+//
+// Requests for /start, /move and /end that don't come from the engine
+// would fill our game context, records and stats with made up games.
+// With "engines" in the config's protection, listing the addresses
+// or networks the engine plays from, or a secret in ENGINE_SECRET,
+// which a custom engine sends as X-Engine-Secret, a game request has
+// to come from one of those addresses or carry the secret:
+//
+//   "protection": { "engines": [ "44.224.0.0/16", "52.12.34.56" ] }
+//
+// Our own tools send the secret themselves, and on this machine get
+// through without it if the protection trusts loopback addresses.
+// Anything else is answered 403 and counted as an "engine"
+// rejection.
+// ----------------------------------------------------------------
+
+var engineSecret = os.Getenv("ENGINE_SECRET")
+
+// Does a game request come from an engine we know?
+func FromEngine (r *http.Request) bool {
+	return EngineAllowed(config.Protection.Address(r), r.Header.Get("X-Engine-Secret"))
+}
+
+// Is a caller from this address with this secret an engine we know?
+func EngineAllowed (address string, secret string) bool {
+	p := config.Protection
+	if len(p.engines) == 0 && len(engineSecret) == 0 { return true }
+	if len(engineSecret) > 0 &&
+	   subtle.ConstantTimeCompare([]byte(secret), []byte(engineSecret)) == 1 {
+		return true
+	}
+	if p.Trusted(address) { return true }
+	if ip := net.ParseIP(address); ip != nil {
+		for _,n := range p.engines {
+			if n.Contains(ip) { return true }
+		}
+	}
+	return false
+}
+
+// Is a caller known to be the engine, rather than let through because
+// we have no way to tell?
+func EngineVerified (address string, secret string) bool {
+	if len(config.Protection.engines) == 0 && len(engineSecret) == 0 { return false }
+	return EngineAllowed(address, secret)
+}
+
+// Warn at startup of what the protection trusts without credentials
+func WarnTrust () {
+	p := config.Protection
+	if p.TrustLoopback {
+		fmt.Printf("WARN: Trusting loopback addresses; behind a proxy on this machine set proxied, or every request is trusted\n")
+	}
+	if len(adminToken) == 0 && !p.TrustLoopback {
+		fmt.Printf("WARN: No ADMIN_TOKEN set, so admin endpoints answer to no one\n")
+	}
+}
+
+// Only let a known engine through to a handler
+func EngineOnly (handler http.HandlerFunc) http.HandlerFunc {
+	return func (w http.ResponseWriter, r *http.Request) {
+		if !FromEngine(r) {
+			reject(w, "engine", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
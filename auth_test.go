@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAdminAllowed (t *testing.T) {
+	savedToken, savedProtection := adminToken, config.Protection
+	defer func () { adminToken, config.Protection = savedToken, savedProtection }()
+
+	tests := []struct {
+		name		string
+		token		string		// ADMIN_TOKEN
+		trust		bool		// the protection's trustLoopback
+		address		string
+		given		string		// the token the caller gives
+		allowed		bool
+	}{
+		{ "no token, closed", "", false, "127.0.0.1", "", false },
+		{ "no token, loopback trusted", "", true, "127.0.0.1", "", true },
+		{ "no token, remote", "", true, "192.0.2.1", "", false },
+		{ "no token, bad address", "", true, "nonsense", "", false },
+		{ "right token", "secret", false, "192.0.2.1", "secret", true },
+		{ "wrong token", "secret", false, "192.0.2.1", "guess", false },
+		{ "no token given", "secret", false, "192.0.2.1", "", false },
+		{ "loopback still needs it", "secret", true, "127.0.0.1", "", false },
+	}
+	for _,test := range tests {
+		adminToken = test.token
+		config.Protection = Protection { TrustLoopback: test.trust }
+		if allowed := AdminAllowed(test.address, test.given); allowed != test.allowed {
+			t.Errorf("%s: allowed %v, expected %v", test.name, allowed, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ----------------------------------------------------------------
+// Batch Analysis
+//
+// The tuning and blunder tools ask what we make of many positions at
+// once.  POST /analyze/batch takes an array of move requests and
+// answers with an array of analyses in the same order, each the move
+// we would make by the heuristics alone, why, the features and value
+// of every move, and the maps /analyze gives:
+//
+//   curl -d '[ {...}, {...} ]' http://localhost:8080/analyze/batch
+//
+// Positions are worked on in parallel, one per CPU, each in a quiet
+// context of its own so that positions from one game don't share
+// history and live games are left alone.  Analyses are cached across
+// batches by a hash of the request, so asking again for a position is
+// free.  A position that can't be analyzed has an "error" instead.
+// ----------------------------------------------------------------
+
+// Positions in one batch at most
+const maxBatchPositions = 1000
+
+// Analyses remembered across batches
+const batchCacheSize = 4096
+
+type AnalyzedMove struct {
+	Move		string		`json:"move"`
+	Value		float64		`json:"value"`
+	Discarded	bool		`json:"discarded"`
+	Features	[]float64	`json:"features"`
+}
+
+type PositionAnalysis struct {
+	Move			string			`json:"move,omitempty"`
+	Reason			Reason			`json:"reason,omitempty"`
+	Confidence		float64			`json:"confidence"`
+	Tier			string			`json:"tier,omitempty"`
+	FeatureNames	[]string		`json:"featureNames,omitempty"`
+	Moves			[]AnalyzedMove	`json:"moves,omitempty"`
+	Maps			*BoardMaps		`json:"maps,omitempty"`
+	Error			string			`json:"error,omitempty"`
+}
+
+// The move of a decision and what we made of each move
+func (d MoveDecision) Analysis () PositionAnalysis {
+	analysis := PositionAnalysis { Move: d.Move, Reason: d.Reason, Confidence: d.Confidence, Tier: d.Tier,
+								   FeatureNames: FeatureNames }
+	if d.state == nil || len(d.state.snakes) == 0 { return analysis }
+	for _,move := range d.Moves {
+		analysis.Moves = append(analysis.Moves, AnalyzedMove { move.dir, move.value, move.discarded, d.state.Features(move) })
+	}
+	maps := d.state.Maps()
+	analysis.Maps = &maps
+	return analysis
+}
+
+var batchCache = struct {
+	sync.Mutex
+	m				map[uint64]PositionAnalysis
+	hits, misses	int64
+} { m: make(map[uint64]PositionAnalysis) }
+
+// Batch contexts are numbered so that none is shared
+var batchContexts uint64
+
+func batchKey (request MoveRequest) uint64 {
+	h := fnv.New64a()
+	data, _ := json.Marshal(request)
+	h.Write(data)
+	return h.Sum64()
+}
+
+// Decide on a move by the heuristics alone, as a snake of our own in
+// a quiet context no game is using, returning the decision and the ID
+// we played under
+func DecideApart (request MoveRequest) (MoveDecision, string) {
+	id := fmt.Sprintf("batch-%d", atomic.AddUint64(&batchContexts, 1))
+	g, t, b, you := request.Game, request.Turn, CopyBoard(request.Board), request.You
+	for i := range b.Snakes {
+		if b.Snakes[i].ID == you.ID { b.Snakes[i].ID = id }
+	}
+	you.ID = id
+
+	gameContext.Lock()
+	gameContext.m[id] = &ContextType { color: "batch", quiet: true, spaces: make(map[string][]int) }
+	gameContext.Unlock()
+	defer ForgetContext(id)
+	UpdateContext(id, b.Snakes, b.Food)
+	return Decide(g, t, b, you), id
+}
+
+// Analyze one position of a batch
+func AnalyzePosition (request MoveRequest) PositionAnalysis {
+	if len(request.You.Body) == 0 { return PositionAnalysis { Error: "expected a move request" } }
+	if !OnBoard(request.Board, request.You) {
+		return PositionAnalysis { Error: fmt.Sprintf("%s isn't on the board", request.You.ID) }
+	}
+
+	key := batchKey(request)
+	batchCache.Lock()
+	analysis, ok := batchCache.m[key]
+	if ok { batchCache.hits++ } else { batchCache.misses++ }
+	batchCache.Unlock()
+	if ok { return analysis }
+
+	d, id := DecideApart(request)
+	analysis = d.Analysis()
+	if analysis.Maps != nil {
+		for i := range analysis.Maps.Snakes {
+			if analysis.Maps.Snakes[i] == id { analysis.Maps.Snakes[i] = request.You.ID }
+		}
+	}
+
+	batchCache.Lock()
+	if len(batchCache.m) >= batchCacheSize { batchCache.m = make(map[uint64]PositionAnalysis) }
+	batchCache.m[key] = analysis
+	batchCache.Unlock()
+	return analysis
+}
+
+// Analyze every position, in parallel
+func AnalyzeBatch (requests []MoveRequest) []PositionAnalysis {
+	analyses := make([]PositionAnalysis, len(requests))
+	next := int64(-1)
+	nworkers := runtime.GOMAXPROCS(0)
+	if nworkers > len(requests) { nworkers = len(requests) }
+
+	var wg sync.WaitGroup
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(requests) { return }
+				analyses[i] = AnalyzePosition(requests[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return analyses
+}
+
+func HandleAnalyzeBatch (w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Expected a POST of move requests", http.StatusMethodNotAllowed)
+		return
+	}
+	var requests []MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		http.Error(w, "Expected an array of move requests", http.StatusBadRequest)
+		return
+	}
+	if len(requests) > maxBatchPositions {
+		http.Error(w, fmt.Sprintf("At most %d positions to a batch", maxBatchPositions), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AnalyzeBatch(requests))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Benchmarks
+//
+// Time deciding on a move in the starting position of scenarios,
+// with the allocations it costs:
+//
+//   spacey-snake bench -n 2000 fixtures/*.json
+//
+// With -max-allocs, the command fails if any scenario allocates more
+// than that many times per move, so that memory regressions can be
+// caught in CI like any other.  The same positions can be benchmarked
+// with the Go tooling, with the neighbour iteration on its own:
+//
+//   go test -run XXX -bench . -benchmem
+// ----------------------------------------------------------------
+
+type BenchResult struct {
+	Name	string
+	N		int
+	PerMove	time.Duration
+	Allocs	float64		// allocations per move
+	Bytes	float64		// bytes allocated per move
+}
+
+func (r BenchResult) String () string {
+	return fmt.Sprintf("%-30s %8d %10d ns/move %8.1f allocs/move %10.0f B/move",
+					   r.Name, r.N, r.PerMove.Nanoseconds(), r.Allocs, r.Bytes)
+}
+
+func Bench (sc *Scenario, n int) BenchResult {
+	sim := sc.Start()
+	you, _ := sim.Snake(sc.You)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ { Decide(sim.Game, sim.Turn, sim.Board, you) }
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return BenchResult {
+		Name:		sc.Name,
+		N:			n,
+		PerMove:	elapsed / time.Duration(n),
+		Allocs:		float64(after.Mallocs - before.Mallocs) / float64(n),
+		Bytes:		float64(after.TotalAlloc - before.TotalAlloc) / float64(n),
+	}
+}
+
+func BenchCommand (args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := flags.Int("n", 1000, "moves to decide for each scenario")
+	maxAllocs := flags.Float64("max-allocs", 0, "fail if a move allocates more often than this, if set")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake bench [flags] scenario.json...\n")
+		return 2
+	}
+
+	engineLogging = false
+	status := 0
+	for _,path := range flags.Args() {
+		sc, err := LoadScenario(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		result := Bench(sc, *n)
+		fmt.Println(result)
+		if *maxAllocs > 0 && result.Allocs > *maxAllocs {
+			fmt.Fprintf(os.Stderr, "%s: %.1f allocs/move is over the budget of %g\n", sc.Name, result.Allocs, *maxAllocs)
+			status = 1
+		}
+	}
+	return status
+}
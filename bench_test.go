@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+// The starting position of a scenario, ready to decide our move in
+func benchPosition (tb testing.TB, path string) (*Simulation, Snake) {
+	engineLogging = false
+	ResetGameContexts()
+	sc, err := LoadScenario(path)
+	if err != nil { tb.Fatal(err) }
+	sim := sc.Start()
+	you, ok := sim.Snake(sc.You)
+	if !ok { tb.Fatalf("%s: no snake %s", path, sc.You) }
+	return sim, you
+}
+
+func BenchmarkDecide (b *testing.B) {
+	sim, you := benchPosition(b, "fixtures/edge-trap.json")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ { Decide(sim.Game, sim.Turn, sim.Board, you) }
+}
+
+// Every neighbour of every cell, through the offset table the hot
+// loops use and through a visitor as they used to
+func BenchmarkNeighbours (b *testing.B) {
+	sim, you := benchPosition(b, "fixtures/edge-trap.json")
+	s := NewGameState(sim.Game, sim.Turn, sim.Board, you)
+	b.ReportAllocs()
+	b.ResetTimer()
+	n := 0
+	for i := 0; i < b.N; i++ {
+		var buf [4]Coord
+		for y := 0; y < s.h; y++ {
+			for x := 0; x < s.w; x++ {
+				for _,c := range s.Neighbours(Coord{ x, y }, &buf) { n += c.X }
+			}
+		}
+	}
+}
+
+func BenchmarkVisitNeighbours (b *testing.B) {
+	sim, you := benchPosition(b, "fixtures/edge-trap.json")
+	s := NewGameState(sim.Game, sim.Turn, sim.Board, you)
+	b.ReportAllocs()
+	b.ResetTimer()
+	n := 0
+	for i := 0; i < b.N; i++ {
+		for y := 0; y < s.h; y++ {
+			for x := 0; x < s.w; x++ {
+				s.VisitNeighbours(Coord{ x, y }, func (c Coord, dir string) { n += c.X })
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+// ----------------------------------------------------------------
+// Bitsets
+//
+// A set of board cells, one bit per cell indexed y*w+x, for the
+// membership tests and dedupes we do every turn.  Much cheaper
+// than a map keyed by Coord.
+// ----------------------------------------------------------------
+
+type Bitset []uint64
+
+func NewBitset (n int) Bitset {
+	return make(Bitset, (n+63)/64)
+}
+
+func (b Bitset) Set (i int) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+func (b Bitset) Unset (i int) {
+	b[i/64] &^= 1 << uint(i%64)
+}
+
+func (b Bitset) Has (i int) bool {
+	return b[i/64] & (1 << uint(i%64)) != 0
+}
+
+func (b Bitset) Clear () {
+	for i := range b { b[i] = 0 }
+}
+
+// The index of a cell in a board sized bitset, or -1 if it is off
+// the board
+func (s *GameState) Index (c Coord) int {
+	if c.X < 0 || c.X >= s.w || c.Y < 0 || c.Y >= s.h { return -1 }
+	return c.Y*s.w + c.X
+}
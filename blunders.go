@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Blunders
+//
+// When we lose a game, the counterfactuals are worked out as soon as
+// it ends, and the turns where another move clearly survived longer
+// are reported as blunders, the worst first: the move we made, the
+// better one and how many more turns it bought on average.  They are
+// logged, kept with the game's record and counted in its summary.
+//
+// "blunders" in the config sets how many to report, 3 by default, or
+// none with 0.  If "webhook" is set to a URL, the summary of every
+// game, with the blunders for a loss, is posted there as JSON.
+// ----------------------------------------------------------------
+
+const (
+	blunderRollouts = 32
+	blunderDepth = 15
+	webhookTimeout = 5 * time.Second
+)
+
+type Blunder struct {
+	Turn		int
+	Move		string		// the move we made
+	Better		string		// the move that survived longer
+	Impact		float64		// by how many turns, on average
+}
+
+func (b Blunder) String () string {
+	return fmt.Sprintf("turn=%d moved=%s better=%s impact=%.1f", b.Turn, b.Move, b.Better, b.Impact)
+}
+
+// The worst top turns of a game where another move clearly did better
+func FindBlunders (r *GameRecord, n, depth, top int) []Blunder {
+	var blunders []Blunder
+	for _,c := range Counterfactuals(r, n, depth) {
+		if !c.Regret() { continue }
+		blunders = append(blunders, Blunder { c.Turn, c.Move, c.Alternative, c.AltSurvived - c.Survived })
+	}
+	sort.SliceStable(blunders, func(i, j int) bool { return blunders[i].Impact > blunders[j].Impact })
+	if len(blunders) > top { blunders = blunders[:top] }
+	return blunders
+}
+
+type GameReport struct {
+	Summary		GameSummary
+	Blunders	[]Blunder	`json:",omitempty"`
+}
+
+// Look for blunders in a game that has ended, keep them with its
+// record and send the report on, as the tenant that played it has
+// configured.  This can take a second or two, so is best run on its
+// own.
+func ReportGame (r *GameRecord, t *Tenant) {
+	c := t.Config
+	summary := Summarize(r)
+	summary.Played = time.Now()
+	if summary.Result == "loss" && c.Blunders > 0 {
+		r.Blunders = FindBlunders(r, blunderRollouts, blunderDepth, c.Blunders)
+		for _,blunder := range r.Blunders {
+			fmt.Printf("INFO: Blunder in game %s %v\n", r.Game.ID, blunder)
+		}
+		if len(r.Blunders) > 0 && len(t.RecordDir()) > 0 {
+			if err := r.Write(t.RecordPath(r.Game.ID)); err != nil {
+				fmt.Printf("ERROR: Unable to record game: %v\n", err)
+			}
+		}
+		if c.BlunderFixtures && len(t.FixturesDir()) > 0 {
+			if _, err := WriteBlunderFixtures(t.FixturesDir(), r); err != nil {
+				fmt.Printf("ERROR: Unable to write blunder fixtures: %v\n", err)
+			}
+		}
+		summary.Blunders = len(r.Blunders)
+	}
+
+	if len(c.Webhook) == 0 { return }
+	body, err := json.Marshal(GameReport { summary, r.Blunders })
+	if err != nil {
+		fmt.Printf("ERROR: Unable to report game: %v\n", err)
+		return
+	}
+	client := &http.Client { Timeout: webhookTimeout }
+	response, err := client.Post(c.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("ERROR: Unable to report game: %v\n", err)
+		return
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		fmt.Printf("ERROR: Unable to report game: webhook returned %s\n", response.Status)
+	}
+}
+
+// ----------------------------------------------------------------
+// Blunder fixtures
+//
+// Each blunder can become a regression scenario: the position before
+// it, played for one turn, expecting any move but the one we made.
+// With "blunderFixtures": true in the config, those of every lost
+// game are written to the fixtures directory as they are found, for
+// the self test to play from then on.  For games already recorded
+//
+//   spacey-snake games fixtures [-dir d] <id>...
+//
+// does the same, looking for blunders first if the record has none.
+// A fixture that is already there is left as it is, so one that was
+// edited by hand, or deleted and replaced, stays that way.
+// ----------------------------------------------------------------
+
+func BlunderScenario (r *GameRecord, blunder Blunder) (*Scenario, bool) {
+	for _,frame := range r.Frames {
+		if frame.Turn != blunder.Turn { continue }
+		b := FrameBoard(r, frame)
+		return &Scenario {
+			Name:		fmt.Sprintf("blunder on turn %d of game %s", blunder.Turn, r.Game.ID),
+			Ruleset:	r.Game.Ruleset["name"],
+			Width:		b.Width,
+			Height:		b.Height,
+			Turn:		frame.Turn,
+			You:		r.You,
+			Snakes:		b.Snakes,
+			Food:		b.Food,
+			Hazards:	b.Hazards,
+			Turns:		1,
+			Expect:		&ScenarioExpect { Avoid: []string{ blunder.Move } },
+		}, true
+	}
+	return nil, false
+}
+
+func BlunderFixturePath (dir string, r *GameRecord, blunder Blunder) string {
+	return filepath.Join(dir, fmt.Sprintf("blunder-%s-%d.json", r.Game.ID, blunder.Turn))
+}
+
+// Write a fixture for each of a game's blunders, returning how many
+// were new
+func WriteBlunderFixtures (dir string, r *GameRecord) (int, error) {
+	written := 0
+	for _,blunder := range r.Blunders {
+		path := BlunderFixturePath(dir, r, blunder)
+		if _,err := os.Stat(path); err == nil { continue }
+		sc, ok := BlunderScenario(r, blunder)
+		if !ok { continue }
+		data, err := json.MarshalIndent(sc, "", "\t")
+		if err != nil { return written, err }
+		if err := ioutil.WriteFile(path, data, 0644); err != nil { return written, err }
+		written++
+	}
+	return written, nil
+}
+
+func BlunderFixturesCommand (args []string) int {
+	flags := flag.NewFlagSet("games fixtures", flag.ExitOnError)
+	dir := flags.String("dir", FixturesDir(), "where to write the fixtures")
+	top := flags.Int("top", config.Blunders, "the most blunders to take from each game")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake games fixtures [-dir d] [-top n] id...\n")
+		return 2
+	}
+
+	for _,id := range flags.Args() {
+		r, err := ReadGameRecord(RecordPath(id))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if len(r.Blunders) == 0 { r.Blunders = FindBlunders(r, blunderRollouts, blunderDepth, *top) }
+		written, err := WriteBlunderFixtures(*dir, r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("%s: %d blunders, %d new fixtures\n", id, len(r.Blunders), written)
+	}
+	return 0
+}
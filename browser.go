@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ----------------------------------------------------------------
+// In-Browser Analysis
+//
+// The engine also builds for WebAssembly, so that an analysis board
+// in the browser can be backed by exactly the logic we play with:
+//
+//   GOOS=js GOARCH=wasm go build -o spacey-snake.wasm
+//
+// Loaded with Go's wasm_exec.js, it never starts the server or reads
+// a config, playing on the default weights and thinking within the
+// game's timeout as it would on the server.  Instead it sets a global
+// spaceySnake object with two functions, each taking a move request
+// as JSON and returning JSON:
+//
+//   spaceySnake.suggestMove(request)   the move, why and how sure we are
+//   spaceySnake.evaluate(request)      that and the features and value
+//                                      of every move, and the maps of
+//                                      the position, as /analyze gives
+//
+// Anything that goes wrong is returned as { "error": "..." }.
+// ----------------------------------------------------------------
+
+var errExpectedRequest = fmt.Errorf("expected a move request")
+
+func browserDecision (data []byte) (MoveDecision, error) {
+	request := MoveRequest{}
+	if err := json.Unmarshal(data, &request); err != nil { return MoveDecision{}, err }
+	if len(request.You.Body) == 0 { return MoveDecision{}, errExpectedRequest }
+	if !OnBoard(request.Board, request.You) { return MoveDecision{}, fmt.Errorf("%s isn't on the board", request.You.ID) }
+	return ThinkWithin(request.Game, request.Turn, request.Board, request.You, MoveBudget(request.Game)), nil
+}
+
+// The move we would make from a position
+func SuggestMove (data []byte) ([]byte, error) {
+	d, err := browserDecision(data)
+	if err != nil { return nil, err }
+	return json.Marshal(PositionAnalysis { Move: d.Move, Reason: d.Reason, Confidence: d.Confidence, Tier: d.Tier })
+}
+
+// The move we would make from a position, and what we made of each
+func EvaluatePosition (data []byte) ([]byte, error) {
+	d, err := browserDecision(data)
+	if err != nil { return nil, err }
+	return json.Marshal(d.Analysis())
+}
+
+func browserError (err error) string {
+	data, _ := json.Marshal(map[string]string { "error": err.Error() })
+	return string(data)
+}
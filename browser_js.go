@@ -0,0 +1,27 @@
+package main
+
+import (
+	"syscall/js"
+)
+
+// Wrap a function of a JSON request for JavaScript
+func browserFunc (f func ([]byte) ([]byte, error)) js.Func {
+	return js.FuncOf(func (this js.Value, args []js.Value) interface{} {
+		if len(args) != 1 || args[0].Type() != js.TypeString { return browserError(errExpectedRequest) }
+		data, err := f([]byte(args[0].String()))
+		if err != nil { return browserError(err) }
+		return string(data)
+	})
+}
+
+// Offer the engine to JavaScript, and wait for calls forever
+func ServeBrowser () {
+	engineLogging = false
+	ResetGameContexts()
+	configLoaded = true
+	js.Global().Set("spaceySnake", js.ValueOf(map[string]interface{} {
+		"suggestMove":	browserFunc(SuggestMove),
+		"evaluate":		browserFunc(EvaluatePosition),
+	}))
+	select {}
+}
@@ -0,0 +1,6 @@
+// +build !js
+
+package main
+
+// Only a WebAssembly build runs in the browser
+func ServeBrowser () {}
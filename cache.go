@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ----------------------------------------------------------------
+// Evaluation Cache
+//
+// Voronoi ownership is the most expensive map we build, and we often
+// build it more than once for the same position: when a move request
+// is retried, for the metrics of a move we just decided, and for the
+// candidates the influence tier weighs.  Maps are cached per game,
+// keyed by a hash of everything they depend on, the snakes' bodies,
+// heads and whether they are growing.  Any change to those gives a
+// new key, so a stale map is never used.
+//
+// Reusing work from one turn in the next would need more than this:
+// every head moves each turn, which changes the distance from it to
+// every cell, so a diff of the board invalidates the whole map.
+// ----------------------------------------------------------------
+
+// Positions remembered per game
+const evalCacheSize = 16
+
+var evalCacheStats struct {
+	sync.Mutex
+	hits, misses	int64
+}
+
+// A hash of the position as the Voronoi map sees it
+func (s *GameState) PositionHash () uint64 {
+	// FNV-1a, a word at a time
+	h := uint64(14695981039346656037)
+	mix := func(v int) {
+		h ^= uint64(v)
+		h *= 1099511628211
+	}
+
+	mix(s.w)
+	mix(s.h)
+	for _,snake := range s.snakes {
+		mix(-1)
+		mix(s.Index(snake.head))
+		if snake.growing { mix(1) } else { mix(0) }
+		for _,c := range snake.segments { mix(s.Index(c)) }
+	}
+	return h
+}
+
+// The cached map for a key, if any
+func CachedVoronoi (id string, key uint64) ([][]int, bool) {
+	gameContext.RLock()
+	context, ok := gameContext.m[id]
+	var m [][]int
+	if ok { m, ok = context.voronoi[key] }
+	gameContext.RUnlock()
+
+	evalCacheStats.Lock()
+	if ok { evalCacheStats.hits++ } else { evalCacheStats.misses++ }
+	evalCacheStats.Unlock()
+	return m, ok
+}
+
+func CacheVoronoi (id string, key uint64, m [][]int) {
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok { return }
+
+	// Older positions are rarely seen again, so start over when full
+	if context.voronoi == nil || len(context.voronoi) >= evalCacheSize {
+		context.voronoi = make(map[uint64][][]int)
+	}
+	context.voronoi[key] = m
+}
+
+func WriteCacheMetrics (w http.ResponseWriter) {
+	evalCacheStats.Lock()
+	defer evalCacheStats.Unlock()
+
+	fmt.Fprintf(w, "# HELP spacey_eval_cache_hits_total Voronoi maps found in the cache.\n")
+	fmt.Fprintf(w, "# TYPE spacey_eval_cache_hits_total counter\nspacey_eval_cache_hits_total %d\n", evalCacheStats.hits)
+	fmt.Fprintf(w, "# HELP spacey_eval_cache_misses_total Voronoi maps that had to be built.\n")
+	fmt.Fprintf(w, "# TYPE spacey_eval_cache_misses_total counter\nspacey_eval_cache_misses_total %d\n", evalCacheStats.misses)
+
+	batchCache.Lock()
+	defer batchCache.Unlock()
+	fmt.Fprintf(w, "# HELP spacey_batch_cache_hits_total Batch analyses found in the cache.\n")
+	fmt.Fprintf(w, "# TYPE spacey_batch_cache_hits_total counter\nspacey_batch_cache_hits_total %d\n", batchCache.hits)
+	fmt.Fprintf(w, "# HELP spacey_batch_cache_misses_total Batch analyses that had to be made.\n")
+	fmt.Fprintf(w, "# TYPE spacey_batch_cache_misses_total counter\nspacey_batch_cache_misses_total %d\n", batchCache.misses)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Cancellation
+//
+// Every expensive loop, the flood fills, the Voronoi search and the
+// rollouts, checks a Cancel as it goes and gives up once the move's
+// deadline has passed.  Reading the clock on every cell would cost
+// more than the fill, so the clock is only read every cancelInterval
+// checks, and once expired a Cancel stays expired.
+//
+// The watchdog in HandleMove answers with a safe move if thinking
+// still overruns, and the abandoned decision then winds down within
+// a few checks rather than burning CPU into the next turn.  A nil
+// Cancel never expires.
+// ----------------------------------------------------------------
+
+// Checks between readings of the clock
+const cancelInterval = 64
+
+// How long after the deadline the watchdog steps in
+const watchdogGrace = 50 * time.Millisecond
+
+type Cancel struct {
+	deadline	time.Time
+	checks		uint32		// only touched by the thinking goroutine
+	expired		int32
+}
+
+func NewCancel (deadline time.Time) *Cancel {
+	return &Cancel { deadline: deadline }
+}
+
+func (c *Cancel) Expired () bool {
+	if c == nil { return false }
+	if atomic.LoadInt32(&c.expired) != 0 { return true }
+	c.checks++
+	if c.checks % cancelInterval != 0 || time.Now().Before(c.deadline) { return false }
+	atomic.StoreInt32(&c.expired, 1)
+	return true
+}
+
+// Has the deadline already stopped the thinking?  Unlike Expired,
+// this doesn't read the clock
+func (c *Cancel) Stopped () bool {
+	return c != nil && atomic.LoadInt32(&c.expired) != 0
+}
+
+// A move that doesn't run straight into a wall or body, for when we
+// have run out of time to think
+func SafeMove (b Board, y Snake) string {
+	occupied := make(map[Coord]bool)
+	for _,snake := range b.Snakes {
+		if len(snake.Body) == 0 { continue }
+		for _,segment := range snake.Body[:len(snake.Body)-1] { occupied[segment] = true }
+	}
+
+	move := CurrentDirection(y)
+	head := y.Body[0]
+	for _,dir := range []string { move, "up", "down", "left", "right" } {
+		c := Step(head, dir)
+		if c.X < 0 || c.X >= b.Width || c.Y < 0 || c.Y >= b.Height || occupied[c] { continue }
+		return dir
+	}
+	return move
+}
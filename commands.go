@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Commands
+//
+// Besides running the server, the binary has subcommands for local
+// tooling, e.g.
+//
+//   spacey-snake scenario fixtures/edge-trap.json
+//   spacey-snake debug fixtures/edge-trap.json
+//   spacey-snake eval fixtures/edge-trap.json
+//   spacey-snake compare before.json after.json
+//   spacey-snake tournament -games 200 v11.json v12.json random
+//   spacey-snake sweep -param centerWeight=0:1:0.25 -against default
+//   spacey-snake loadtest -url http://localhost:8080 -games 40
+//   spacey-snake play default hunter
+//   spacey-snake --validate-config config.json
+// ----------------------------------------------------------------
+
+var commands = map[string]func(args []string) int {
+	"scenario":	ScenarioCommand,
+	"debug":	DebugCommand,
+	"games":	GamesCommand,
+	"tournament":	TournamentCommand,
+	"sweep":	SweepCommand,
+	"dataset":	DatasetCommand,
+	"validate":	ValidateCommand,
+	"bench":	BenchCommand,
+	"loadtest":	LoadTestCommand,
+	"play":		PlayCommand,
+	"solve":	SolveCommand,
+	"eval":		EvalCommand,
+	"compare":	CompareCommand,
+	"impact":	ImpactCommand,
+}
+
+// Run a command with the config loaded and no games in play, as
+// every command expects
+func RunCommand (name string, args []string) int {
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", name)
+		return 2
+	}
+	if err := LoadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load config: %v\n", err)
+		return 1
+	}
+	ResetGameContexts()
+	return command(args)
+}
+
+func ScenarioCommand (args []string) int {
+	flags := flag.NewFlagSet("scenario", flag.ExitOnError)
+	turns := flags.Int("turns", 0, "number of turns to play, overriding the scenario")
+	watch := flags.Bool("watch", false, "draw the board in colour each turn")
+	delay := flags.Duration("delay", 300 * time.Millisecond, "pause between turns when watching")
+	frames := flags.String("frames", "", "write the game to this file for the board viewer")
+	csv := flags.String("csv", "", "write our metrics for each turn to this file")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake scenario [-turns n] [-watch] [-delay d] [-frames file] [-csv file] file...\n")
+		return 2
+	}
+
+	status := 0
+	for _,path := range flags.Args() {
+		sc, err := LoadScenario(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if *turns > 0 { sc.Turns = *turns }
+		if err := RunScenario(sc, ScenarioOptions { Watch: *watch, Delay: *delay, Frames: *frames, CSV: *csv }); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+		}
+	}
+	return status
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+)
+
+// ----------------------------------------------------------------
+// Position Comparison
+//
+// Why did the score drop when I moved up?  Given two positions, such
+// as before and after a candidate move, compare works out what the
+// engine makes of each and the change in every term of the final
+// weighing, for the move it would make from each:
+//
+//   spacey-snake compare before.json after.json
+//   curl -d '{ "before": {...}, "after": {...} }' http://localhost:8080/analyze/compare
+//
+// The command takes scenario files, as eval does, and the endpoint
+// move requests.  Terms are signed as they count toward the move's
+// value, so a negative delta is a term that cost us, and "space" is
+// the size of the space the move leads into.  As with eval, "value"
+// is only set if the engine got as far as the final weighing.
+// ----------------------------------------------------------------
+
+type TermDelta struct {
+	Term	string		`json:"term"`
+	Before	float64		`json:"before"`
+	After	float64		`json:"after"`
+	Delta	float64		`json:"delta"`
+}
+
+type ComparedMove struct {
+	Move	string		`json:"move"`
+	Reason	Reason		`json:"reason"`
+}
+
+type PositionComparison struct {
+	Before	ComparedMove	`json:"before"`
+	After	ComparedMove	`json:"after"`
+	Terms	[]TermDelta		`json:"terms"`
+}
+
+// The terms of the move a decision makes, signed as they count
+// toward its value, in the order eval shows them
+func (d MoveDecision) Terms () ([]string, []float64) {
+	names := []string { "space", "compact", "food", "center", "refuge", "pressure", "starve",
+						"hazard", "threat", "external", "value" }
+	values := make([]float64, len(names))
+	for _,e := range d.Evaluate() {
+		if e.Move.dir != d.Move { continue }
+		if e.Move.space > 0 { values[0] = float64(d.state.spaces[e.Move.space].size) }
+		copy(values[1:], []float64 { float64(e.Compactness), 0 - float64(e.FoodDistance), 0 - e.Center, 0 - e.Refuge,
+									 0 - e.Pressure, e.Starvation, 0 - e.Hazard, 0 - e.Threat, e.External, e.Move.value })
+	}
+	return names, values
+}
+
+func ComparePositions (before, after MoveDecision) PositionComparison {
+	c := PositionComparison {
+		Before:	ComparedMove { before.Move, before.Reason },
+		After:	ComparedMove { after.Move, after.Reason },
+	}
+	names, was := before.Terms()
+	_, is := after.Terms()
+	for i,name := range names {
+		c.Terms = append(c.Terms, TermDelta { name, was[i], is[i], is[i] - was[i] })
+	}
+	return c
+}
+
+func WriteComparison (out io.Writer, c PositionComparison) {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "term\tbefore\tafter\tdelta\t\n")
+	for _,t := range c.Terms { fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%+.2f\t\n", t.Term, t.Before, t.After, t.Delta) }
+	w.Flush()
+	fmt.Fprintf(out, "Before: %s reason=%s\nAfter: %s reason=%s\n", c.Before.Move, c.Before.Reason, c.After.Move, c.After.Reason)
+}
+
+func HandleCompare (w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Before	MoveRequest	`json:"before"`
+		After	MoveRequest	`json:"after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil ||
+	   !OnBoard(request.Before.Board, request.Before.You) || !OnBoard(request.After.Board, request.After.You) {
+		http.Error(w, "Expected two move requests, before and after", http.StatusBadRequest)
+		return
+	}
+
+	before, _ := DecideApart(request.Before)
+	after, _ := DecideApart(request.After)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ComparePositions(before, after))
+}
+
+func CompareCommand (args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake compare before after\n")
+		return 2
+	}
+
+	engineLogging = false
+	var decisions [2]MoveDecision
+	for i,path := range flags.Args() {
+		sc, err := LoadScenario(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		sim := sc.Start()
+		you, ok := sim.Snake(sc.You)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: %s isn't on the board\n", path, sc.You)
+			return 1
+		}
+		decisions[i], _ = DecideApart(MoveRequest { sim.Game, sim.Turn, sim.Board, you })
+	}
+	WriteComparison(os.Stdout, ComparePositions(decisions[0], decisions[1]))
+	return 0
+}
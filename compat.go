@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ----------------------------------------------------------------
+// Engine Compatibility
+//
+// Community and local engines don't all send quite what the official
+// one does.  Two differences are handled for every game:
+//
+//   types      numbers sent as strings or strings as numbers, such as
+//              an int latency, are converted to what our types expect
+//              when a request otherwise fails to decode, and counted
+//              as "coerced" schema drift
+//   latency    a missing latency is already taken as unknown
+//
+// and quirks are set per game at /start, or at the first request we
+// see of a game whose /start we missed, as after a restart with no
+// log to recover it from:
+//
+//   flip-y     the engine's y axis runs up the board, the other way
+//              from ours, where y grows down it as in the original
+//              API, so every coordinate is flipped on the way in.
+//              Moves keep their meaning, so nothing changes on the
+//              way out.
+//
+// The engine still thinks on the original API's axis, so flip-y is
+// told from that request: an engine that sends the ruleset or the
+// snakes' heads speaks the current API, whose y axis runs up, and
+// one that sends neither speaks the original.  An engine that gets
+// this wrong can be set right by the config, for each preset path or
+// "*" for all, or by a query parameter, each laid over the last, with
+// no-flip-y to keep the axis as it is sent:
+//
+//   "quirks": { "local": [ "no-flip-y" ] }
+//   https://example.com/?quirks=flip-y
+// ----------------------------------------------------------------
+
+var knownQuirks = map[string]bool { "flip-y": true, "no-flip-y": true }
+
+type Quirks struct {
+	FlipY		bool
+}
+
+func (q Quirks) String () string {
+	var names []string
+	if q.FlipY { names = append(names, "flip-y") }
+	return strings.Join(names, ",")
+}
+
+// The quirks of every game in progress, by our snake's ID, recorded
+// even when there are none so we know not to look for them again
+var gameQuirks struct {
+	sync.Mutex
+	m map[string]Quirks
+}
+
+func CheckQuirks (names []string) error {
+	for _,name := range names {
+		if !knownQuirks[name] { return fmt.Errorf("unknown quirk %s", name) }
+	}
+	return nil
+}
+
+// The quirks of the engine sending a request over HTTP
+func RequestQuirks (r *http.Request, request *StartRequest) Quirks {
+	return RequestTenant(r).Config.StartQuirks(request, RequestPreset(r), r.URL.Query().Get("quirks"))
+}
+
+// The quirks that can be told from a request.  Move and end requests
+// are laid out as a /start request is, so convert them to look.
+func DetectQuirks (request *StartRequest) Quirks {
+	current := len(request.Game.Ruleset.Name) > 0 || len(request.Game.Ruleset.Version) > 0 || request.You.Head != nil
+	for _,snake := range request.Board.Snakes {
+		if snake.Head != nil { current = true }
+	}
+	return Quirks { FlipY: current }
+}
+
+// The quirks of the engine sending a request, with those the config
+// gives a preset and those listed in list, separated by commas,
+// laid over them in turn
+func (c Config) StartQuirks (request *StartRequest, preset string, list string) Quirks {
+	names := append([]string(nil), c.Quirks["*"]...)
+	names = append(names, c.Quirks[preset]...)
+	if len(list) > 0 { names = append(names, strings.Split(list, ",")...) }
+
+	q := DetectQuirks(request)
+	for _,name := range names {
+		switch name {
+		case "flip-y":		q.FlipY = true
+		case "no-flip-y":	q.FlipY = false
+		}
+	}
+	return q
+}
+
+func SetQuirks (id string, q Quirks) {
+	gameQuirks.Lock()
+	defer gameQuirks.Unlock()
+	if gameQuirks.m == nil { gameQuirks.m = make(map[string]Quirks) }
+	gameQuirks.m[id] = q
+}
+
+// The quirks recorded for a game, if any are
+func GameQuirks (id string) (Quirks, bool) {
+	gameQuirks.Lock()
+	defer gameQuirks.Unlock()
+	q, ok := gameQuirks.m[id]
+	return q, ok
+}
+
+// The quirks recorded for the game of a request, or if there are
+// none, as we missed its /start, those quirksOf finds, recorded now
+func RequestGameQuirks (request *StartRequest, quirksOf func (request *StartRequest) Quirks) Quirks {
+	if q, ok := GameQuirks(request.You.ID); ok { return q }
+	q := quirksOf(request)
+	SetQuirks(request.You.ID, q)
+	if q != (Quirks{}) { fmt.Printf("INFO: Engine quirks=%v\n", q) }
+	return q
+}
+
+func ForgetQuirks (id string) {
+	gameQuirks.Lock()
+	defer gameQuirks.Unlock()
+	delete(gameQuirks.m, id)
+}
+
+func flipCoords (coords []Coord, height int) {
+	for i := range coords { coords[i].Y = height - 1 - coords[i].Y }
+}
+
+func flipSnake (snake *Snake, height int) {
+	flipCoords(snake.Body, height)
+	if snake.Head != nil {
+		head := Coord{ snake.Head.X, height - 1 - snake.Head.Y }
+		snake.Head = &head
+	}
+}
+
+// Bring a request from an engine with quirks in line with the API
+func (q Quirks) Apply (b *Board, you *Snake) {
+	if !q.FlipY { return }
+	flipCoords(b.Food, b.Height)
+	flipCoords(b.Hazards, b.Height)
+	for i := range b.Snakes { flipSnake(&b.Snakes[i], b.Height) }
+	flipSnake(you, b.Height)
+}
+
+// ----------------------------------------------------------------
+// Coercing field types
+// ----------------------------------------------------------------
+
+// Convert numbers and strings in decoded JSON to the kinds the
+// fields of t expect, reporting each field converted
+func CoerceTypes (raw interface{}, t reflect.Type, path string, report func(field string)) interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return CoerceTypes(raw, t.Elem(), path, report)
+
+	case reflect.Slice:
+		values, ok := raw.([]interface{})
+		if !ok { return raw }
+		for i,value := range values { values[i] = CoerceTypes(value, t.Elem(), path, report) }
+		return values
+
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok { return raw }
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" || len(field.PkgPath) > 0 { continue }
+			if len(name) == 0 { name = field.Name }
+			if value, ok := object[name]; ok { object[name] = CoerceTypes(value, field.Type, path + name + ".", report) }
+		}
+		return object
+
+	case reflect.String:
+		if n, ok := raw.(float64); ok {
+			report(strings.TrimSuffix(path, "."))
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+
+	case reflect.Int, reflect.Int64:
+		if s, ok := raw.(string); ok {
+			if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+				report(strings.TrimSuffix(path, "."))
+				return n
+			}
+		}
+	}
+	return raw
+}
+
+// Decode a request whose fields came with the wrong types
+func (body *RequestBody) DecodeCoerced (endpoint string, v interface{}) error {
+	raw, err := body.Raw()
+	if err != nil { return err }
+	raw = CoerceTypes(raw, reflect.TypeOf(v), "", func (field string) {
+		CountDrift("coerced", endpoint, field)
+	})
+	coerced, err := json.Marshal(raw)
+	if err != nil { return err }
+	return json.Unmarshal(coerced, v)
+}
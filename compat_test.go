@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+// A request with our snake's head at (1,1) on a 5x5 board, from an
+// engine speaking the current API if ruleset or head says so.  As
+// when decoded, the board and you don't share a body.
+func quirksRequest (ruleset string, head bool) StartRequest {
+	snake := func () Snake {
+		you := Snake { ID: "you", Health: 100, Body: []Coord{ { 1, 1 }, { 1, 2 }, { 1, 3 } } }
+		if head { you.Head = &Coord{ 1, 1 } }
+		return you
+	}
+	return StartRequest {
+		Game:	Game { ID: "game", Ruleset: Ruleset { Name: ruleset } },
+		Board:	Board { Height: 5, Width: 5, Food: []Coord{ { 0, 0 } }, Snakes: []Snake{ snake() } },
+		You:	snake(),
+	}
+}
+
+func TestStartQuirks (t *testing.T) {
+	tests := []struct {
+		name	string
+		request	StartRequest
+		config	map[string][]string
+		list	string
+		flipY	bool
+	}{
+		{ "ruleset", quirksRequest("standard", false), nil, "", true },
+		{ "head only", quirksRequest("", true), nil, "", true },
+		{ "legacy", quirksRequest("", false), nil, "", false },
+		{ "legacy flipped by config", quirksRequest("", false), map[string][]string { "*": { "flip-y" } }, "", true },
+		{ "current kept by preset", quirksRequest("standard", false), map[string][]string { "local": { "no-flip-y" } }, "", false },
+		{ "other preset ignored", quirksRequest("standard", false), map[string][]string { "hunter": { "no-flip-y" } }, "", true },
+		{ "query over config", quirksRequest("", false), map[string][]string { "*": { "flip-y" } }, "no-flip-y", false },
+		{ "last of the list", quirksRequest("", false), nil, "no-flip-y,flip-y", true },
+	}
+	for _,test := range tests {
+		detected := DetectQuirks(&test.request)
+		q := Config { Quirks: test.config }.StartQuirks(&test.request, "local", test.list)
+		if q.FlipY != test.flipY { t.Errorf("%s: flip-y %v, expected %v (detected %v)", test.name, q.FlipY, test.flipY, detected.FlipY) }
+	}
+}
+
+// A move request for a game whose /start we missed takes its quirks
+// from the move, and keeps them for the rest of the game
+func TestMoveQuirks (t *testing.T) {
+	tests := []struct {
+		name	string
+		ruleset	string
+		flipY	bool
+		y		int		// of our head once prepared
+	}{
+		{ "current", "standard", true, 3 },
+		{ "legacy", "", false, 1 },
+	}
+	detect := func (request *StartRequest) Quirks { return config.StartQuirks(request, "", "") }
+	for _,test := range tests {
+		request := MoveRequest(quirksRequest(test.ruleset, false))
+		ForgetQuirks(request.You.ID)
+		PrepareRequest(&request, detect)
+
+		q, ok := GameQuirks(request.You.ID)
+		if !ok { t.Errorf("%s: no quirks recorded", test.name) }
+		if q.FlipY != test.flipY { t.Errorf("%s: flip-y %v, expected %v", test.name, q.FlipY, test.flipY) }
+		if y := request.You.Body[0].Y; y != test.y { t.Errorf("%s: head at y=%d, expected %d", test.name, y, test.y) }
+
+		// Later requests keep the quirks first recorded
+		next := MoveRequest(quirksRequest("", false))
+		PrepareRequest(&next, detect)
+		if again, _ := GameQuirks(next.You.ID); again != q { t.Errorf("%s: quirks changed to %v", test.name, again) }
+		ForgetQuirks(request.You.ID)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "sort"
+
+// ----------------------------------------------------------------
+// Confidence
+//
+// How sure we are of a move, from 0 to 1, taking in how hard we
+// thought about it, how clearly it beat the next best move, and
+// whether we only made it because nothing better was left:
+//
+//   tier        full 1, sampled 0.75, heuristic 0.5, influence 0.25
+//   margin      between the best two moves in rollouts, or in the
+//               final weighing of the heuristics, scaling the tier's
+//               figure from half to all of it
+//   fallback    a quarter for moves made in desperation, and half for
+//               moves that were only the least bad of their kind
+//
+// "riskTolerance" in the config, from 0 to 1 and 1 by default, sets
+// how unsure we may be in a tournament game before playing safe.
+// Below a confidence of 1 - riskTolerance we take the open move into
+// the largest space instead, if that isn't the move already.
+// ----------------------------------------------------------------
+
+var tierConfidence = map[string]float64 {
+	"full":			1,
+	"sampled":		0.75,
+	"heuristic":	0.5,
+	"influence":	0.25,
+}
+
+// Moves made only because every alternative was worse
+var leastBadReasons = map[Reason]bool {
+	ReasonSelfEnclosed: true, ReasonLargestSmallSpace: true, ReasonLeastThreatened: true,
+	ReasonSqueeze: true, ReasonSqueezed: true,
+}
+
+// The gap between the best two of some values, or -1 with fewer
+// than two
+func topMargin (values []float64) float64 {
+	if len(values) < 2 { return -1 }
+	sort.Sort(sort.Reverse(sort.Float64Slice(values)))
+	return values[0] - values[1]
+}
+
+// Our confidence in a decision, given the rollout value of each move
+// if there were rollouts
+func (d MoveDecision) EstimateConfidence (rollouts map[string]float64) float64 {
+	margin := 1.0
+	if len(rollouts) > 1 {
+		var values []float64
+		for _,move := range d.Moves {
+			if v, ok := rollouts[move.dir]; ok { values = append(values, v) }
+		}
+		margin = topMargin(values) / rolloutMargin
+	} else if d.Reason == ReasonFoodProgress || d.Reason == ReasonCompact {
+		var values []float64
+		for _,move := range d.Moves {
+			if move.RuledOut() { continue }
+			values = append(values, move.value)
+		}
+		if gap := topMargin(values); gap >= 0 { margin = gap / (gap + 1) }
+	}
+	if margin > 1 { margin = 1 }
+
+	confidence := tierConfidence[d.Tier] * (0.5 + 0.5 * margin)
+	switch {
+	case d.Reason.Category() == "fallback":	confidence *= 0.25
+	case leastBadReasons[d.Reason]:			confidence *= 0.5
+	}
+	return confidence
+}
+
+// The open move into the largest space, if it isn't the move we made
+func (d MoveDecision) SaferMove () (string, bool) {
+	s := d.state
+	if s == nil { return "", false }
+	safest := ""
+	largest := 0
+	for _,move := range d.Moves {
+		if move.space <= 0 || move.smallSpace || move.nlonger > 0 { continue }
+		if size := s.spaces[move.space].size; size > largest {
+			safest = move.dir
+			largest = size
+		}
+	}
+	if len(safest) == 0 || safest == d.Move { return "", false }
+	for _,move := range d.Moves {
+		if move.dir == d.Move && move.space > 0 && s.spaces[move.space].size >= largest { return "", false }
+	}
+	return safest, true
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateConfidence (t *testing.T) {
+	moves := []MoveType{ { dir: "up", value: 3 }, { dir: "left", value: 1 }, { dir: "right", value: 2, nlonger: 1 } }
+	tests := []struct {
+		name		string
+		tier		string
+		reason		Reason
+		rollouts	map[string]float64
+		confidence	float64
+	}{
+		{ "clear rollouts", "full", ReasonRollout, map[string]float64{ "up": 1.5, "left": 1 }, 1 },
+		{ "level rollouts", "full", ReasonFood, map[string]float64{ "up": 1, "left": 1 }, 0.5 },
+		{ "close rollouts", "sampled", ReasonFood, map[string]float64{ "up": 1.125, "left": 1 }, 0.75 * 0.75 },
+		{ "no rollouts", "heuristic", ReasonAttack, nil, 0.5 },
+		{ "food progress margin", "heuristic", ReasonFoodProgress, nil, 0.5 * (0.5 + 0.5 * 2.0 / 3) },
+		{ "fallback", "full", ReasonWatchdog, nil, 0.25 },
+		{ "least bad", "influence", ReasonLeastThreatened, nil, 0.25 * 0.5 },
+	}
+	for _,test := range tests {
+		d := MoveDecision { Move: "up", Reason: test.reason, Tier: test.tier, Moves: moves }
+		if confidence := d.EstimateConfidence(test.rollouts); math.Abs(confidence - test.confidence) > 1e-9 {
+			t.Errorf("%s: confidence %.4f, expected %.4f", test.name, confidence, test.confidence)
+		}
+	}
+}
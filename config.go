@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// ----------------------------------------------------------------
+// Weights
+//
+// The tunable parameters of our move selection.  Each ruleset gets
+// its own profile since what works in standard games doesn't work
+// in Royale or Constrictor.
+// ----------------------------------------------------------------
+
+type Weights struct {
+	SatedHealth		int		`json:"satedHealth"`		// health above which we stop chasing food
+	StarvingHealth	int		`json:"starvingHealth"`		// health at or below which a snake must eat
+	CenterWeight	float64	`json:"centerWeight"`		// penalty per cell of distance from the center
+	CenterFadeTurn	int		`json:"centerFadeTurn"`		// turn by which the center preference has faded out
+	RefugeWeight	float64	`json:"refugeWeight"`		// penalty per cell of distance from the refuge
+	PressureWeight	float64	`json:"pressureWeight"`		// pull per cell toward shorter snakes that are slow to respond
+	StarveWeight	float64	`json:"starveWeight"`		// reward per cell our move takes from opponents' reachable space
+	HazardWeight	float64	`json:"hazardWeight"`		// penalty per point of health a hazard will cost us, at full health
+	ThreatTolerance	float64	`json:"threatTolerance"`	// chance of a head to head with a longer snake we will risk
+	ThreatWeight	float64	`json:"threatWeight"`		// penalty per unit of that chance
+	TwoStepWeight	float64	`json:"twoStepWeight"`		// penalty when every next move could meet a longer snake
+	ExternalWeight	float64	`json:"externalWeight"`		// how much an external evaluator's scores count
+	Cautious		bool	`json:"cautious,omitempty"`	// treat any space smaller than twice our length as too small
+	Ties			string	`json:"ties,omitempty"`		// when to risk a head to head with a snake as long as us
+	Truce			bool	`json:"truce"`				// leave our teammates alone
+	Disable			[]string	`json:"disable,omitempty"`	// heuristic rules to switch off
+	Experiments		[]string	`json:"-"`					// experiments the game is in
+}
+
+func DefaultWeights () Weights {
+	return Weights {
+		SatedHealth:	50,
+		StarvingHealth:	25,
+		CenterWeight:	0.5,
+		CenterFadeTurn:	150,
+		RefugeWeight:	0.5,
+		PressureWeight:	0.5,
+		StarveWeight:	0.1,
+		HazardWeight:	0.04,
+		ThreatTolerance:	0.2,
+		ThreatWeight:	20,
+		TwoStepWeight:	1,
+		ExternalWeight:	1,
+		Ties:			"desperate",
+		Truce:			true,
+	}
+}
+
+// ----------------------------------------------------------------
+// Ties
+//
+// Two snakes of the same length meeting head to head both die.  The
+// "ties" weight says when we will risk that:
+//
+//   avoid       never, a tie is as bad as meeting a longer snake
+//   desperate   only when every move is threatened anyway, where a
+//               tie is better than losing outright
+//   leader      as desperate, and also when the other snake is the
+//               longest on the board and the game goes on without us
+//               both, so that taking it out helps whoever is left
+// ----------------------------------------------------------------
+
+var TiePolicies = []string { "avoid", "desperate", "leader" }
+
+// Check the ranges of the weights and the rules and policies they name
+func (w Weights) Check () error {
+	if err := w.CheckRanges(); err != nil { return err }
+	if err := CheckRules(w.Disable); err != nil { return err }
+	if len(w.Ties) == 0 { return nil }
+	for _,policy := range TiePolicies {
+		if policy == w.Ties { return nil }
+	}
+	return UnknownName("tie policy", w.Ties, TiePolicies)
+}
+
+// ----------------------------------------------------------------
+// Ablation
+//
+// Individual rules can be switched off by listing them under 
+// "disable" in a profile, so that a tournament between profiles
+// with and without a rule shows whether it is pulling its weight:
+//
+//   attack      go for the head of a shorter snake
+//   edge-trap   pin a wall-hugging snake against the wall
+//   squeeze     avoid moving along a wall beside another snake
+//   corridors   avoid dead ends and corridors that can be plugged
+//   triage      leave food to starving snakes that will get there first
+//   compact     coil up instead of chasing food once we are largest
+//   center      prefer the center of the board early on
+//   refuge      drift toward the middle of the safe area in Royale
+//   pressure    crowd shorter snakes that are close to timing out
+//   rollouts    check the chosen move against simulated playouts
+//   starve      take space away from other snakes
+//   hazards     weigh the health a hazard costs against what it gains
+//   two-step    beware of longer snakes that could meet us next move
+//   forcing     leave an opponent only losing replies
+//   simultaneous  play head to head stand-offs as simultaneous games
+//   external    add the scores of an external evaluator
+// ----------------------------------------------------------------
+
+var Rules = []string {
+	"attack", "edge-trap", "squeeze", "corridors", "triage", "compact", "center", "refuge",
+	"pressure", "rollouts", "starve", "hazards", "two-step", "forcing", "simultaneous", "external",
+}
+
+func (w Weights) Enabled (rule string) bool {
+	for _,disabled := range w.Disable {
+		if disabled == rule { return false }
+	}
+	return true
+}
+
+func CheckRules (rules []string) error {
+	for _,rule := range rules {
+		known := false
+		for _,r := range Rules {
+			if r == rule { known = true }
+		}
+		if !known { return UnknownName("rule", rule, Rules) }
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------
+// Config
+//
+// Loaded once at startup from the JSON file named by the CONFIG
+// environment variable, or config.json in the working directory.
+// Profiles are keyed by ruleset name and only need to list the 
+// weights that differ from the defaults, or from the "*" profile if
+// there is one.  A config can extend another, see Layered Configs.
+//
+// With "explain" set, every move shouts a compact explanation of why
+// it was chosen, so spectators and recorded games carry our reasoning.
+// Otherwise, if "phrases" names a directory of phrase packs, each
+// personality shouts from its own pack in the configured "language".
+// "shoutRules" react to what our opponents shout.  "preset" names the
+// play style for games that don't ask for one, "strategies" chooses
+// one by the game, and "presets" adds to the built in styles.
+// "verboseSources" limits recording the features of every move to
+// games from those sources, e.g. [ "custom" ] for test games only.
+// "teammates" names the snakes this server plays as.  "riskTolerance"
+// is how unsure we may be of a move in a tournament before playing
+// safe.  "evaluator" adds the scores of an external evaluator.
+// "protection" limits what any one client can ask of the server.
+// "tenants" hosts other teams' snakes, each with its own config.
+// "experiments" tries changes out on a share of games.
+// ----------------------------------------------------------------
+
+type Config struct {
+	Profiles	map[string]Weights
+	Explain		bool
+	Phrases		string
+	Language	string
+	ShoutRules	[]ShoutRule
+	Preset		string
+	Presets		map[string]json.RawMessage
+	Strategies	[]StrategyRule
+	VerboseSources	[]string
+	Teammates	[]string
+	RiskTolerance	float64
+	Blunders	int
+	BlunderFixtures	bool
+	Webhook		string
+	Retention	Retention
+	Quirks		map[string][]string
+	Evaluator	EvaluatorConfig
+	Protection	Protection
+	Tenants		map[string]TenantConfig
+	Experiments	map[string]Experiment
+}
+
+var config = Config { Profiles: map[string]Weights{}, RiskTolerance: 1, Blunders: 3, Protection: DefaultProtection() }
+
+func LoadConfig () error {
+	path := os.Getenv("CONFIG")
+	if len(path) == 0 {
+		path = "config.json"
+		if _,err := os.Stat(path); os.IsNotExist(err) {
+			configLoaded = true
+			return nil
+		}
+	}
+
+	loaded, err := ReadConfig(path)
+	if err != nil { return err }
+	others, err := LoadTenants(loaded)
+	if err != nil { return fmt.Errorf("%s: %v", path, err) }
+	config = loaded
+	tenants = others
+
+	if len(config.Phrases) > 0 {
+		packs, err := LoadPhrasePacks(config.Phrases, config.Language)
+		if err != nil { return err }
+		phrasePacks = packs
+	}
+	configLoaded = true
+	return nil
+}
+
+func ReadConfig (path string) (Config, error) {
+	c := Config { Profiles: map[string]Weights{}, RiskTolerance: 1, Blunders: 3, Protection: DefaultProtection() }
+
+	data, err := ReadLayers(path)
+	if err != nil { return c, err }
+
+	var raw struct {
+		Profiles	map[string]json.RawMessage	`json:"profiles"`
+		Explain		bool						`json:"explain"`
+		Phrases		string						`json:"phrases"`
+		Language	string						`json:"language"`
+		ShoutRules	[]ShoutRule					`json:"shoutRules"`
+		Preset		string						`json:"preset"`
+		Presets		map[string]json.RawMessage	`json:"presets"`
+		Strategies	[]StrategyRule				`json:"strategies"`
+		VerboseSources	[]string				`json:"verboseSources"`
+		Teammates	[]string					`json:"teammates"`
+		RiskTolerance	float64					`json:"riskTolerance"`
+		Blunders	int							`json:"blunders"`
+		BlunderFixtures	bool					`json:"blunderFixtures"`
+		Webhook		string						`json:"webhook"`
+		Retention	Retention					`json:"retention"`
+		Quirks		map[string][]string			`json:"quirks"`
+		Evaluator	EvaluatorConfig				`json:"evaluator"`
+		Protection	Protection					`json:"protection"`
+		Tenants		map[string]TenantConfig		`json:"tenants"`
+		Experiments	map[string]Experiment		`json:"experiments"`
+	}
+	raw.RiskTolerance = 1
+	raw.Blunders = 3
+	raw.Protection = DefaultProtection()
+	if err := DecodeConfig(data, &raw); err != nil { return c, fmt.Errorf("%s: %v", path, err) }
+	if raw.RiskTolerance < 0 || raw.RiskTolerance > 1 { return c, fmt.Errorf("%s: riskTolerance must be from 0 to 1", path) }
+	c.RiskTolerance = raw.RiskTolerance
+	if raw.Blunders < 0 { return c, fmt.Errorf("%s: blunders must not be negative", path) }
+	c.Blunders = raw.Blunders
+	c.BlunderFixtures = raw.BlunderFixtures
+	c.Webhook = raw.Webhook
+	if raw.Retention.Days < 0 || raw.Retention.Every < 0 { return c, fmt.Errorf("%s: retention must not be negative", path) }
+	if raw.Retention.Every == 0 { raw.Retention.Every = 10 }
+	c.Retention = raw.Retention
+	for preset,quirks := range raw.Quirks {
+		if err := CheckQuirks(quirks); err != nil { return c, fmt.Errorf("%s: quirks for %s: %v", path, preset, err) }
+	}
+	c.Quirks = raw.Quirks
+	if err := raw.Evaluator.Check(); err != nil { return c, fmt.Errorf("%s: %v", path, err) }
+	c.Evaluator = raw.Evaluator
+	if err := raw.Protection.Check(); err != nil { return c, fmt.Errorf("%s: %v", path, err) }
+	c.Protection = raw.Protection
+	c.Protection.engines, _ = ParseAddresses(raw.Protection.Engines)
+	c.Explain = raw.Explain
+	c.Phrases = raw.Phrases
+	c.Language = raw.Language
+	c.Preset = raw.Preset
+	c.VerboseSources = raw.VerboseSources
+	c.Teammates = raw.Teammates
+	c.Presets = raw.Presets
+
+	for name,preset := range raw.Presets {
+		weights := DefaultWeights()
+		if err := DecodeConfig(preset, &weights); err != nil { return c, fmt.Errorf("%s: presets.%s: %v", path, name, err) }
+		if err := weights.Check(); err != nil { return c, fmt.Errorf("%s: presets.%s: %v", path, name, err) }
+	}
+	names := c.PresetNames()
+	known := func(preset string) bool {
+		_, builtin := builtinPresets[preset]
+		_, ok := c.Presets[preset]
+		return builtin || ok
+	}
+	if len(c.Preset) > 0 && !known(c.Preset) { return c, fmt.Errorf("%s: preset: %v", path, UnknownName("preset", c.Preset, names)) }
+
+	for i := range raw.Strategies {
+		rule := &raw.Strategies[i]
+		if len(rule.Preset) == 0 { return c, fmt.Errorf("%s: strategies[%d]: names no preset", path, i) }
+		if !known(rule.Preset) { return c, fmt.Errorf("%s: strategies[%d]: %v", path, i, UnknownName("preset", rule.Preset, names)) }
+		if err := CheckRulesetName(rule.Ruleset); len(rule.Ruleset) > 0 && err != nil {
+			return c, fmt.Errorf("%s: strategies[%d]: %v", path, i, err)
+		}
+		if len(rule.Opponent) > 0 {
+			re, err := regexp.Compile(rule.Opponent)
+			if err != nil { return c, fmt.Errorf("%s: strategies[%d]: %v", path, i, err) }
+			rule.re = re
+		}
+	}
+	c.Strategies = raw.Strategies
+
+	for i := range raw.ShoutRules {
+		rule := &raw.ShoutRules[i]
+		if len(rule.Pattern) == 0 { return c, fmt.Errorf("%s: shoutRules[%d]: has no pattern", path, i) }
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil { return c, fmt.Errorf("%s: shoutRules[%d]: %v", path, i, err) }
+		if err := CheckRules(rule.Disable); err != nil { return c, fmt.Errorf("%s: shoutRules[%d]: %v", path, i, err) }
+		rule.re = re
+	}
+	c.ShoutRules = raw.ShoutRules
+
+	for name,tenant := range raw.Tenants {
+		if err := CheckTenantName(name, c.PresetNames()); err != nil { return c, fmt.Errorf("%s: %v", path, err) }
+		if len(tenant.Config) == 0 { return c, fmt.Errorf("%s: tenant %s has no config", path, name) }
+	}
+	c.Tenants = raw.Tenants
+
+	for name,e := range raw.Experiments {
+		if err := e.Check(); err != nil { return c, fmt.Errorf("%s: experiments.%s: %v", path, name, err) }
+	}
+	c.Experiments = raw.Experiments
+
+	base := DefaultWeights()
+	if profile, ok := raw.Profiles["*"]; ok {
+		if err := DecodeConfig(profile, &base); err != nil { return c, fmt.Errorf("%s: profiles.*: %v", path, err) }
+	}
+	for name,profile := range raw.Profiles {
+		if err := CheckRulesetName(name); err != nil { return c, fmt.Errorf("%s: profiles.%s: %v", path, name, err) }
+		weights := base
+		weights.Disable = append([]string(nil), base.Disable...)
+		if err := DecodeConfig(profile, &weights); err != nil { return c, fmt.Errorf("%s: profiles.%s: %v", path, name, err) }
+		if err := weights.Check(); err != nil { return c, fmt.Errorf("%s: profiles.%s: %v", path, name, err) }
+		c.Profiles[name] = weights
+	}
+	return c, nil
+}
+
+// Select the weights for a ruleset, falling back to the standard
+// profile, then the base profile and then the built in defaults
+func WeightsFor (ruleset string) Weights {
+	return config.WeightsFor(ruleset)
+}
+
+func (c Config) WeightsFor (ruleset string) Weights {
+	if weights, ok := c.Profiles[ruleset]; ok { return weights }
+	if weights, ok := c.Profiles["standard"]; ok { return weights }
+	if weights, ok := c.Profiles["*"]; ok { return weights }
+	return DefaultWeights()
+}
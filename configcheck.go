@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Config Validation
+//
+// Configs are written by hand, and a misspelt weight used to be
+// dropped without a word, leaving the default to play a tournament.
+// Every config is now read strictly: unknown keys, values of the
+// wrong type, weights out of range, strategies without a preset and
+// names of presets or rulesets that look like typos all stop the
+// server at startup, saying where the problem is and, where it can,
+// what was probably meant:
+//
+//   config.json: profiles.royale: unknown field "centreWeight" (did you mean "centerWeight"?)
+//   config.json: strategies[1]: unknown preset huntr (did you mean hunter?)
+//
+// Configs can be checked without starting anything, as in CI, with
+// the config the server would load or any others:
+//
+//   spacey-snake --validate-config
+//   spacey-snake --validate-config config.json weights/*.json
+// ----------------------------------------------------------------
+
+// Decode a config, or part of one, rejecting anything it doesn't know
+func DecodeConfig (data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	err := d.Decode(v)
+	if err == nil { return nil }
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line, column := LineColumn(data, e.Offset)
+		return fmt.Errorf("line %d, column %d: %v", line, column, e)
+	case *json.UnmarshalTypeError:
+		if len(e.Field) == 0 { return fmt.Errorf("expected %s, not %s", JSONType(e.Type), e.Value) }
+		return fmt.Errorf("%s: expected %s, not %s", e.Field, JSONType(e.Type), e.Value)
+	}
+
+	const unknown = "json: unknown field "
+	if msg := err.Error(); strings.HasPrefix(msg, unknown) {
+		field, qerr := strconv.Unquote(strings.TrimPrefix(msg, unknown))
+		if qerr != nil { return err }
+		if suggestion, ok := Suggest(field, JSONFields(reflect.TypeOf(v))); ok {
+			return fmt.Errorf("unknown field %q (did you mean %q?)", field, suggestion)
+		}
+		return fmt.Errorf("unknown field %q", field)
+	}
+	return err
+}
+
+// What a Go type is called in JSON
+func JSONType (t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool: return "true or false"
+	case reflect.String: return "string"
+	case reflect.Slice, reflect.Array: return "array"
+	case reflect.Map, reflect.Struct: return "object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		 reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "whole number"
+	case reflect.Float32, reflect.Float64: return "number"
+	}
+	return t.String()
+}
+
+// The line and column of an offset into a file, counting from 1
+func LineColumn (data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) { offset = int64(len(data)) }
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// The JSON names of every field of a type and the types within it
+func JSONFields (t reflect.Type) []string {
+	seen := make(map[reflect.Type]bool)
+	names := make(map[string]bool)
+	var walk func (t reflect.Type)
+	walk = func (t reflect.Type) {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct || seen[t] { return }
+		seen[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if len(field.PkgPath) > 0 { continue }
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" { continue }
+			if len(name) == 0 { name = field.Name }
+			names[name] = true
+			walk(field.Type)
+		}
+	}
+	walk(t)
+
+	var all []string
+	for name := range names { all = append(all, name) }
+	sort.Strings(all)
+	return all
+}
+
+// The name closest to one that isn't known, if it is close enough to
+// be a typo
+func Suggest (name string, known []string) (string, bool) {
+	best, bestDistance := "", -1
+	for _,k := range known {
+		d := EditDistance(strings.ToLower(name), strings.ToLower(k))
+		if bestDistance < 0 || d < bestDistance { best, bestDistance = k, d }
+	}
+	limit := len(name) / 3
+	if limit < 2 { limit = 2 }
+	return best, bestDistance >= 0 && bestDistance <= limit
+}
+
+// The Levenshtein distance between two strings
+func EditDistance (a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev { prev[j] = j }
+	for i := 1; i <= len(a); i++ {
+		row := make([]int, len(b)+1)
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] { cost = 0 }
+			row[j] = prev[j-1] + cost
+			if prev[j] + 1 < row[j] { row[j] = prev[j] + 1 }
+			if row[j-1] + 1 < row[j] { row[j] = row[j-1] + 1 }
+		}
+		prev = row
+	}
+	return prev[len(b)]
+}
+
+// An unknown name, with what was probably meant if anything
+func UnknownName (kind, name string, known []string) error {
+	if suggestion, ok := Suggest(name, known); ok {
+		return fmt.Errorf("unknown %s %s (did you mean %s?)", kind, name, suggestion)
+	}
+	return fmt.Errorf("unknown %s %s", kind, name)
+}
+
+// A ruleset we don't know is fine, since new ones come along, but not
+// one that is a typo of one we do
+func CheckRulesetName (name string) error {
+	if knownRulesets[name] { return nil }
+	var known []string
+	for ruleset := range knownRulesets { known = append(known, ruleset) }
+	sort.Strings(known)
+	if suggestion, ok := Suggest(name, known); ok {
+		return fmt.Errorf("unknown ruleset %s (did you mean %s?)", name, suggestion)
+	}
+	return nil
+}
+
+// Check each weight is within its sensible range
+func (w Weights) CheckRanges () error {
+	health := []struct { name string; value int } {
+		{ "satedHealth", w.SatedHealth },
+		{ "starvingHealth", w.StarvingHealth },
+	}
+	for _,h := range health {
+		if h.value < 0 || h.value > 100 { return fmt.Errorf("%s must be from 0 to 100, not %d", h.name, h.value) }
+	}
+	if w.CenterFadeTurn <= 0 { return fmt.Errorf("centerFadeTurn must be at least 1, not %d", w.CenterFadeTurn) }
+
+	weights := []struct { name string; value float64 } {
+		{ "centerWeight", w.CenterWeight },
+		{ "refugeWeight", w.RefugeWeight },
+		{ "pressureWeight", w.PressureWeight },
+		{ "starveWeight", w.StarveWeight },
+		{ "hazardWeight", w.HazardWeight },
+		{ "threatWeight", w.ThreatWeight },
+		{ "twoStepWeight", w.TwoStepWeight },
+		{ "externalWeight", w.ExternalWeight },
+	}
+	for _,weight := range weights {
+		if weight.value < 0 { return fmt.Errorf("%s must not be negative, not %g", weight.name, weight.value) }
+	}
+	if w.ThreatTolerance < 0 || w.ThreatTolerance >= 1 {
+		return fmt.Errorf("threatTolerance must be at least 0 and below 1, not %g", w.ThreatTolerance)
+	}
+	return nil
+}
+
+// Check configs and the tenants they list, without starting anything
+func ValidateConfigCommand (args []string) int {
+	paths := args
+	if len(paths) == 0 {
+		path := os.Getenv("CONFIG")
+		if len(path) == 0 { path = "config.json" }
+		paths = []string { path }
+	}
+
+	status := 0
+	for _,path := range paths {
+		c, err := ReadConfig(path)
+		if err == nil {
+			_, err = LoadTenants(c)
+			if err != nil { err = fmt.Errorf("%s: %v", path, err) }
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: ok\n", path)
+	}
+	return status
+}
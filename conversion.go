@@ -0,0 +1,58 @@
+package main
+
+// ----------------------------------------------------------------
+// Conversion
+//
+// Once every opponent is sealed into a space it can't survive in,
+// and we have room of our own, the game is ours unless we throw it
+// away.  From then until the last of them is gone we refuse every
+// risk we don't have to take: small spaces are judged as if we had
+// been warned of a trap, ties are avoided, rules that go after other
+// snakes are switched off and rollouts can't overrule the
+// heuristics.
+//
+// A snake is sealed in when the cells it can reach are fewer than
+// its length, none of the bodies around them will move out of the
+// way before it runs out of room, and none of them is next to our
+// head, so it can't meet us head to head either.
+// ----------------------------------------------------------------
+
+// Rules that take risks to hurt other snakes
+var conversionDisable = []string { "attack", "edge-trap", "pressure", "starve", "forcing" }
+
+func (s *GameState) Sealed (sx int) bool {
+	snake := s.snakes[sx]
+	room := s.ReachableSpace(snake.head)
+	if room >= snake.length { return false }
+
+	myHead := s.snakes[0].head
+	var buf [4]Coord
+	for i := 0; i < s.w*s.h; i++ {
+		if !s.visited.Has(i) { continue }
+		c := Coord{ i % s.w, i / s.w }
+		if ManDist(c, myHead) == 1 { return false }
+		for _,neighbour := range s.Neighbours(c, &buf) {
+			if s.visited.Has(s.Index(neighbour)) || s.SnakeNo(neighbour) < 0 { continue }
+			if s.VacateTime(neighbour) <= room { return false }
+		}
+	}
+	return true
+}
+
+// Is every opponent sealed in while we have room?
+func (s *GameState) CertainWin () bool {
+	if len(s.snakes) < 2 || s.snakes[0].space < s.snakes[0].length { return false }
+	for sx := 1; sx < len(s.snakes); sx++ {
+		if !s.Sealed(sx) { return false }
+	}
+	return true
+}
+
+// Play safe from here on
+func (s *GameState) Convert () {
+	s.converting = true
+	s.cautious = true
+	s.weights.Ties = "avoid"
+	s.weights.Disable = append(append([]string(nil), s.weights.Disable...), conversionDisable...)
+	s.info.Printf("Every opponent is sealed in, playing safe\n")
+}
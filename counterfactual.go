@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Counterfactuals
+//
+// For each turn of a recorded game, what would have happened if we
+// had moved differently?  From the recorded position we play the
+// move we made, and each other move that doesn't hit anything
+// straight away, through many short random games, and count how
+// many turns we survive on average.  Turns where the best of the
+// other moves survives clearly longer, by counterfactualMargin
+// turns, are marked, which is usually enough to find the decision
+// that lost a game:
+//
+//   spacey-snake games analyze <id> [-rollouts n] [-depth d]
+// ----------------------------------------------------------------
+
+const counterfactualMargin = 3.0
+
+type Counterfactual struct {
+	Turn			int
+	Move			string		// the move we made
+	Survived		float64		// average turns survived after it
+	Alternative		string		// the best of the other moves, if any
+	AltSurvived		float64
+}
+
+// Did the alternative clearly do better?
+func (c Counterfactual) Regret () bool {
+	return len(c.Alternative) > 0 && c.AltSurvived - c.Survived >= counterfactualMargin
+}
+
+func (c Counterfactual) String () string {
+	line := fmt.Sprintf("turn=%d moved=%s survived=%.1f", c.Turn, c.Move, c.Survived)
+	if len(c.Alternative) > 0 { line += fmt.Sprintf(" alternative=%s survived=%.1f", c.Alternative, c.AltSurvived) }
+	if c.Regret() { line += " <--" }
+	return line
+}
+
+// The average number of turns, up to depth, we survive random games
+// after making the given move
+func RolloutTurns (st *SearchState, me int, first string, n, depth int, rng *rand.Rand) float64 {
+	moves := make([]string, len(st.Snakes))
+	total := 0
+	for rollout := 0; rollout < n; rollout++ {
+		step := 0
+		for ; step < depth && st.Snakes[me].Alive; step++ {
+			for i := range st.Snakes {
+				if st.Snakes[i].Alive { moves[i] = st.RandomMove(i, rng) }
+			}
+			if step == 0 { moves[me] = first }
+			st.Apply(moves)
+		}
+		if !st.Snakes[me].Alive { step-- }
+		total += step
+		for st.Depth() > 0 { st.Undo() }
+	}
+	return float64(total) / float64(n)
+}
+
+// The move we made on each turn, as recorded or else as seen from
+// where our head went next
+func (r *GameRecord) OurMove (fx int) string {
+	turn := r.Frames[fx].Turn
+	if move, ok := r.Moves[turn]; ok { return move }
+	if fx+1 >= len(r.Frames) { return "" }
+
+	var head, next *FrameCoord
+	for _,snake := range r.Frames[fx].Snakes {
+		if snake.ID == r.You && snake.Death == nil && len(snake.Body) > 0 { head = &snake.Body[0] }
+	}
+	for _,snake := range r.Frames[fx+1].Snakes {
+		if snake.ID == r.You && snake.Death == nil && len(snake.Body) > 0 { next = &snake.Body[0] }
+	}
+	if head == nil || next == nil { return "" }
+	from, to := Coord{ head.X, head.Y }, Coord{ next.X, next.Y }
+	if ManDist(from, to) != 1 { return "" }
+	return Direction(from, to)
+}
+
+func Counterfactuals (r *GameRecord, n, depth int) []Counterfactual {
+	h := fnv.New64a()
+	h.Write([]byte(r.Game.ID))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	var result []Counterfactual
+	for fx,frame := range r.Frames {
+		move := r.OurMove(fx)
+		if len(move) == 0 { continue }
+		b := FrameBoard(r, frame)
+		st := NewSearchState(b, frame.Turn, depth)
+		me := -1
+		for i,snake := range st.Snakes {
+			if snake.ID == r.You { me = i }
+		}
+		if me < 0 { continue }
+
+		c := Counterfactual { Turn: frame.Turn, Move: move }
+		c.Survived = RolloutTurns(st, me, move, n, depth, rng)
+		for _,o := range neighbourOffsets {
+			head := st.Snakes[me].Body[0]
+			next := Coord{ head.X + o.dx, head.Y + o.dy }
+			if o.dir == move || st.Index(next) < 0 || st.Occupied(next) { continue }
+			survived := RolloutTurns(st, me, o.dir, n, depth, rng)
+			if len(c.Alternative) == 0 || survived > c.AltSurvived {
+				c.Alternative = o.dir
+				c.AltSurvived = survived
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func AnalyzeCommand (args []string) int {
+	flags := flag.NewFlagSet("games analyze", flag.ExitOnError)
+	n := flags.Int("rollouts", 64, "random games per move")
+	depth := flags.Int("depth", 20, "turns per random game")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake games analyze [-rollouts n] [-depth d] id\n")
+		return 2
+	}
+
+	r, err := ReadGameRecord(RecordPath(flags.Arg(0)))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	var lines []string
+	regrets := 0
+	for _,c := range Counterfactuals(r, *n, *depth) {
+		if c.Regret() { regrets++ }
+		lines = append(lines, c.String())
+	}
+	fmt.Println(Summarize(r))
+	fmt.Println(strings.Join(lines, "\n"))
+	fmt.Printf("%d turns where another move clearly survived longer\n", regrets)
+	return 0
+}
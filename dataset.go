@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Imitation Datasets
+//
+// Every frame we record shows where each opponent moved, so the
+// archive already holds a record of what strong snakes do in the
+// positions they meet.  The dataset command turns those moves into
+// supervised examples: for each opponent and turn, the features of
+// every move open to it, as our engine sees them from its point of
+// view, labelled with the move it actually made.
+//
+//   spacey-snake dataset -snake "Top Snake" -winners > top.jsonl
+//
+// Output is one JSON object per line.  Moves that left the snake dead
+// can't be seen in the next frame, so they are left out.  With
+// -anonymize, the snakes and games are given pseudonyms, as in
+// anonymized exports of the archive.
+// ----------------------------------------------------------------
+
+type Example struct {
+	Game		string			`json:"game"`
+	Turn		int				`json:"turn"`
+	Snake		string			`json:"snake"`
+	Name		string			`json:"name"`
+	Move		string			`json:"move"`		// the move the snake made
+	Engine		string			`json:"engine"`		// the move we would have made in its place
+	Names		[]string		`json:"names,omitempty"`
+	Moves		[]MoveFeatures	`json:"moves"`
+}
+
+// The examples for every snake in a recorded game that select accepts
+func GameExamples (r *GameRecord, selected func(snake FrameSnake) bool) []Example {
+	var examples []Example
+	g := Game { ID: r.Game.ID, Ruleset: Ruleset { Name: r.Game.Ruleset["name"] } }
+
+	for fx := 0; fx+1 < len(r.Frames); fx++ {
+		frame, next := r.Frames[fx], r.Frames[fx+1]
+		heads := make(map[string]Coord)
+		for _,snake := range next.Snakes {
+			if snake.Death == nil && len(snake.Body) > 0 { heads[snake.ID] = Coord{ snake.Body[0].X, snake.Body[0].Y } }
+		}
+
+		b := FrameBoard(r, frame)
+		for _,snake := range b.Snakes {
+			head, ok := heads[snake.ID]
+			if !ok || ManDist(snake.Body[0], head) != 1 { continue }
+
+			var fs FrameSnake
+			for _,s := range frame.Snakes {
+				if s.ID == snake.ID { fs = s }
+			}
+			if !selected(fs) { continue }
+
+			// Our engine expects the snake it moves for to be first
+			view := b
+			view.Snakes = []Snake { snake }
+			for _,other := range b.Snakes {
+				if other.ID != snake.ID { view.Snakes = append(view.Snakes, other) }
+			}
+
+			decision := Decide(g, frame.Turn, view, snake)
+			features := decision.Features()
+			if len(features.Moves) == 0 { continue }
+
+			examples = append(examples, Example {
+				Game:	r.Game.ID,
+				Turn:	frame.Turn,
+				Snake:	snake.ID,
+				Name:	snake.Name,
+				Move:	Direction(snake.Body[0], head),
+				Engine:	decision.Move,
+				Moves:	features.Moves,
+			})
+		}
+	}
+	return examples
+}
+
+func DatasetCommand (args []string) int {
+	flags := flag.NewFlagSet("dataset", flag.ExitOnError)
+	dir := flags.String("dir", RecordDir(), "directory of recorded games, RECORD_DIR by default")
+	names := flags.String("snake", "", "comma separated names of the snakes to learn from, all opponents by default")
+	winners := flags.Bool("winners", false, "only learn from snakes that won their game")
+	self := flags.Bool("self", false, "include our own moves")
+	anonymize := flags.Bool("anonymize", false, "replace the opponents and games by pseudonyms")
+	key := flags.String("key", os.Getenv("ANONYMIZE_KEY"), "the secret pseudonyms are made with, ANONYMIZE_KEY by default")
+	flags.Parse(args)
+
+	var a *Anonymizer
+	if *anonymize {
+		var err error
+		if a, err = NewAnonymizer(*key); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+	}
+
+	if len(*dir) == 0 { *dir = "." }
+	wanted := make(map[string]bool)
+	for _,name := range strings.Split(*names, ",") {
+		if len(name) > 0 { wanted[name] = true }
+	}
+
+	paths, err := filepath.Glob(filepath.Join(*dir, "*.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	engineLogging = false
+	out := json.NewEncoder(os.Stdout)
+	first := true
+	for _,path := range paths {
+		r, err := ReadGameRecord(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if len(r.Frames) == 0 { continue }
+
+		won := make(map[string]bool)
+		last := r.Frames[len(r.Frames)-1]
+		nalive := 0
+		for _,snake := range last.Snakes {
+			if snake.Death == nil { nalive++ }
+		}
+		for _,snake := range last.Snakes {
+			if snake.Death == nil && nalive == 1 { won[snake.ID] = true }
+		}
+
+		examples := GameExamples(r, func(snake FrameSnake) bool {
+			if snake.ID == r.You && !*self { return false }
+			if len(wanted) > 0 && !wanted[snake.Name] { return false }
+			return !*winners || won[snake.ID]
+		})
+		for _,example := range examples {
+			if a != nil { example = a.Example(r.You, example) }
+			// Name the features once, on the first line
+			if first { example.Names = FeatureNames }
+			first = false
+			out.Encode(example)
+		}
+	}
+	return 0
+}
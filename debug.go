@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Debugger
+//
+// Step the engine through a scenario one turn at a time, with
+// commands to override the moves of other snakes, look at how the
+// engine scored its options and compare its choice against another
+// set of weights.
+// ----------------------------------------------------------------
+
+const debugHelp = `Commands:
+  s, step [n]          play n turns (default 1)
+  o, override id dir   force the next move of a snake
+  e, eval              show how our snake scores its moves this turn
+  d, diff file         compare our move against the weights in a config file
+  m, maps file         write the danger, voronoi and region maps to a file
+  b, board             show the board
+  t, trace on|off      show the engine's debug log while stepping
+  q, quit
+`
+
+type Debugger struct {
+	sc			*Scenario
+	sim			*Simulation
+	overrides	map[string]string
+	color		bool
+	out			io.Writer
+}
+
+func DebugCommand (args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	color := flags.Bool("color", false, "draw the board in colour")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake debug [-color] file\n")
+		return 2
+	}
+
+	sc, err := LoadScenario(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	engineLogging = false
+
+	d := Debugger { sc: sc, color: *color, out: os.Stdout, overrides: map[string]string{} }
+	d.sim = sc.Start()
+	d.Run(os.Stdin)
+	return 0
+}
+
+func (d *Debugger) Run (in io.Reader) {
+	d.Board()
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(d.out, "(turn %d) ", d.sim.Turn)
+		if !scanner.Scan() { return }
+
+		words := strings.Fields(scanner.Text())
+		if len(words) == 0 { continue }
+
+		switch words[0] {
+		case "s", "step":
+			n := 1
+			if len(words) > 1 { n, _ = strconv.Atoi(words[1]) }
+			for i := 0; i < n && d.sc.Running(d.sim); i++ { d.Step() }
+			d.Board()
+		case "o", "override":
+			if len(words) != 3 {
+				fmt.Fprintf(d.out, "Usage: override id dir\n")
+				continue
+			}
+			d.overrides[words[1]] = words[2]
+		case "e", "eval":
+			d.Eval()
+		case "d", "diff":
+			if len(words) != 2 {
+				fmt.Fprintf(d.out, "Usage: diff file\n")
+				continue
+			}
+			d.Diff(words[1])
+		case "m", "maps":
+			if len(words) != 2 {
+				fmt.Fprintf(d.out, "Usage: maps file\n")
+				continue
+			}
+			d.Maps(words[1])
+		case "b", "board":
+			d.Board()
+		case "t", "trace":
+			engineLogging = len(words) > 1 && words[1] == "on"
+		case "q", "quit":
+			return
+		default:
+			fmt.Fprint(d.out, debugHelp)
+		}
+	}
+}
+
+func (d *Debugger) Board () {
+	if d.color {
+		fmt.Fprint(d.out, RenderBoardANSI(d.sim.Board, d.overrides))
+	} else {
+		fmt.Fprint(d.out, RenderBoard(d.sim.Board))
+	}
+}
+
+func (d *Debugger) Step () {
+	ids := SnakeIDs(d.sim)
+	moves, _ := d.sc.NextMoves(d.sim)
+	for id,dir := range d.overrides { moves[id] = dir }
+	d.overrides = map[string]string{}
+
+	d.sc.Apply(d.sim, moves)
+
+	fmt.Fprintf(d.out, "Turn %d: %s\n", d.sim.Turn-1, FormatMoves(ids, moves))
+	for _,e := range d.sim.Eliminated {
+		if e.Turn != d.sim.Turn { continue }
+		fmt.Fprintf(d.out, "Turn %d: %s eliminated by %s %s\n", e.Turn, e.ID, e.Cause, e.By)
+	}
+}
+
+// Decide our move without playing it
+func (d *Debugger) Decide () (MoveDecision, bool) {
+	you, ok := d.sim.Snake(d.sc.You)
+	if !ok {
+		fmt.Fprintf(d.out, "%s has been eliminated\n", d.sc.You)
+		return MoveDecision{}, false
+	}
+	return Decide(d.sim.Game, d.sim.Turn, d.sim.Board, you), true
+}
+
+func (d *Debugger) Eval () {
+	decision, ok := d.Decide()
+	if !ok { return }
+
+	for _,move := range decision.Moves {
+		fmt.Fprintf(d.out, "%-5s space=%d small=%t longer=%d shorter=%d squeezed=%t corridor=%d value=%.2f\n",
+					move.dir, move.space, move.smallSpace, move.nlonger, move.nshorter,
+					move.squeezed, move.corridor.length, move.value)
+	}
+	fmt.Fprintf(d.out, "Move: %s\n", decision.Move)
+}
+
+func (d *Debugger) Diff (path string) {
+	alt, err := ReadConfig(path)
+	if err != nil {
+		fmt.Fprintf(d.out, "%v\n", err)
+		return
+	}
+
+	decision, ok := d.Decide()
+	if !ok { return }
+
+	weights := alt.WeightsFor(d.sim.Game.Ruleset.Name)
+	gameContext.Lock()
+	context := gameContext.m[d.sc.You]
+	saved := context.weights
+	context.weights = &weights
+	gameContext.Unlock()
+
+	altDecision, _ := d.Decide()
+
+	gameContext.Lock()
+	context.weights = saved
+	gameContext.Unlock()
+
+	fmt.Fprintf(d.out, "Current weights: %s\n%s: %s\n", decision.Move, path, altDecision.Move)
+}
+
+func (d *Debugger) Maps (path string) {
+	you, ok := d.sim.Snake(d.sc.You)
+	if !ok {
+		fmt.Fprintf(d.out, "%s has been eliminated\n", d.sc.You)
+		return
+	}
+
+	s := NewGameState(d.sim.Game, d.sim.Turn, d.sim.Board, you)
+	data, err := json.MarshalIndent(s.Maps(), "", "  ")
+	if err == nil { err = ioutil.WriteFile(path, data, 0644) }
+	if err != nil { fmt.Fprintf(d.out, "%v\n", err) }
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Disagreements
+//
+// When the rollouts rate another move above the one the heuristics
+// chose, whether or not by enough to overrule them, we log both and
+// keep a record of it with the game.  Positions where the two keep
+// disagreeing are where the heuristics are poorly calibrated, and
+// across the archive
+//
+//   spacey-snake games disagreements
+//
+// lists them, with how often the search disagreed with each reason
+// the heuristics give.
+// ----------------------------------------------------------------
+
+type Disagreement struct {
+	Turn			int
+	Heuristic		string		// the move the heuristics chose
+	Reason			Reason		// and why
+	HeuristicValue	float64		// its value in rollouts
+	Search			string		// the move the rollouts rated best
+	SearchValue		float64
+	Overruled		bool		// did we make the search's move?
+}
+
+func (d Disagreement) String () string {
+	overruled := ""
+	if d.Overruled { overruled = " overruled" }
+	return fmt.Sprintf("turn=%d heuristic=%s reason=%s value=%.2f search=%s value=%.2f%s", d.Turn,
+					   d.Heuristic, d.Reason, d.HeuristicValue, d.Search, d.SearchValue, overruled)
+}
+
+func RecordDisagreement (id string, g Game, b Board, d Disagreement) {
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok { return }
+	if context.record == nil {
+		context.record = NewGameRecord(g, b)
+		context.record.You = id
+	}
+
+	// A retried turn replaces what we recorded for it before
+	r := context.record
+	if n := len(r.Disagreements); n > 0 && r.Disagreements[n-1].Turn == d.Turn {
+		r.Disagreements = r.Disagreements[:n-1]
+	}
+	r.Disagreements = append(r.Disagreements, d)
+}
+
+// List the disagreements in an archive, then count them by reason
+func ListDisagreements (dir string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil { return "", err }
+
+	var b strings.Builder
+	counts := make(map[Reason]int)
+	moves := make(map[Reason]int)
+	for _,path := range paths {
+		r, err := ReadArchivedGame(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: Skipping %v\n", err)
+			continue
+		}
+		for _,reason := range r.Reasons { moves[reason]++ }
+		for _,d := range r.Disagreements {
+			fmt.Fprintf(&b, "%s %v\n", r.Game.ID, d)
+			counts[d.Reason]++
+		}
+	}
+
+	var reasons []Reason
+	for reason := range counts { reasons = append(reasons, reason) }
+	sort.Slice(reasons, func(i, j int) bool {
+		if counts[reasons[i]] != counts[reasons[j]] { return counts[reasons[i]] > counts[reasons[j]] }
+		return reasons[i] < reasons[j]
+	})
+	for _,reason := range reasons {
+		fmt.Fprintf(&b, "%s: %d disagreements", reason, counts[reason])
+		if moves[reason] > 0 { fmt.Fprintf(&b, " in %d moves", moves[reason]) }
+		fmt.Fprintf(&b, "\n")
+	}
+	return b.String(), nil
+}
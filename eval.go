@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// ----------------------------------------------------------------
+// Evaluation
+//
+// What does the engine think of a position?  For each move from our
+// snake's head, the eval command prints what the rules found and
+// what each heuristic of the final weighing adds to or takes from
+// it, under the weights the config gives the scenario's ruleset:
+//
+//   spacey-snake eval fixtures/edge-trap.json
+//
+// "value" is the move's score in the final weighing, if the engine
+// got that far, and the last line is the move it makes and why.  The
+// heuristics are worked out for every move, including those the
+// rules had already discarded or picked ahead of the weighing.
+// ----------------------------------------------------------------
+
+type Evaluation struct {
+	Move			MoveType
+	Compactness		int
+	FoodDistance	int
+	Center			float64
+	Refuge			float64
+	Pressure		float64
+	Starvation		float64
+	Hazard			float64
+	Threat			float64
+	External		float64
+}
+
+// The contribution of every heuristic to each move of a decision
+func (d MoveDecision) Evaluate () []Evaluation {
+	s := d.state
+	if s == nil { return nil }
+	head := s.snakes[0].head
+
+	var evaluations []Evaluation
+	for _,move := range d.Moves {
+		e := Evaluation { Move: move }
+		if s.Index(move.c) >= 0 {
+			e.Compactness = s.Compactness(move.c, head)
+			e.FoodDistance = s.FoodDistance(move.c)
+			e.Center = s.CenterPenalty(move.c)
+			e.Refuge = s.RefugePenalty(move.c)
+			e.Pressure = s.PressurePenalty(move.c)
+			e.Starvation = s.StarvationBonus(move.c)
+			e.Hazard = s.HazardCost(move.c)
+			e.Threat = s.ThreatCost(move)
+			e.External = s.ExternalScore(move)
+		}
+		evaluations = append(evaluations, e)
+	}
+	return evaluations
+}
+
+func WriteEvaluation (out io.Writer, d MoveDecision) {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "move\tspace\tsmall\tlonger\tshorter\tsqueezed\tcorridor\tdiscarded\t" +
+				   "compact\tfood\tcenter\trefuge\tpressure\tstarve\thazard\tthreat\texternal\tvalue\t\n")
+	for _,e := range d.Evaluate() {
+		move := e.Move
+		size := 0
+		if move.space > 0 { size = d.state.spaces[move.space].size }
+		fmt.Fprintf(w, "%s\t%d\t%t\t%d\t%d\t%t\t%d\t%t\t%d\t%d\t-%.2f\t-%.2f\t-%.2f\t+%.2f\t-%.2f\t-%.2f\t%+.2f\t%.2f\t\n",
+					move.dir, size, move.smallSpace, move.nlonger, move.nshorter, move.squeezed,
+					move.corridor.length, move.discarded, e.Compactness, e.FoodDistance, e.Center,
+					e.Refuge, e.Pressure, e.Starvation, e.Hazard, e.Threat, e.External, move.value)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "Move: %s reason=%s\n", d.Move, d.Reason)
+}
+
+func EvalCommand (args []string) int {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake eval file...\n")
+		return 2
+	}
+
+	engineLogging = false
+	for _,path := range flags.Args() {
+		sc, err := LoadScenario(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		sim := sc.Start()
+		you, ok := sim.Snake(sc.You)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s: %s isn't on the board\n", path, sc.You)
+			return 1
+		}
+
+		if flags.NArg() > 1 { fmt.Printf("%s:\n", path) }
+		WriteEvaluation(os.Stdout, Decide(sim.Game, sim.Turn, sim.Board, you))
+	}
+	return 0
+}
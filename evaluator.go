@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// External Evaluators
+//
+// Experimental evaluators, such as a learned model, can score our
+// moves without changes to the engine.  An evaluator is registered
+// under a name with RegisterEvaluator, and the config's "evaluator"
+// says which to use, or gives a program to run as one:
+//
+//   "evaluator": { "command": [ "python3", "model.py" ], "timeout": 20 }
+//
+// The program is started once and kept running.  For each move we
+// write it one line of JSON on stdin, the request as the engine
+// would send it with the moves open to us, and it answers with one
+// line on stdout, scoring as many of them as it likes:
+//
+//   { "id": 7, "game": {...}, "turn": 31, "board": {...}, "you": {...}, "moves": [ "up", "left" ] }
+//   { "id": 7, "scores": { "up": 0.8, "left": -0.2 } }
+//
+// Scores are added to the final weighing times the externalWeight of
+// our weights, so a profile or preset can count them more or less,
+// and the rule can be switched off as "external".  A move that isn't
+// scored, or every move if no answer comes within "timeout"
+// milliseconds, scores 0.  A program that exits is started again for
+// the next move.
+// ----------------------------------------------------------------
+
+// Milliseconds to wait for an evaluator by default
+const defaultEvaluatorTimeout = 20
+
+type Evaluator interface {
+	Score (g Game, t int, b Board, you Snake, moves []string) (map[string]float64, error)
+}
+
+type EvaluatorConfig struct {
+	Name		string		`json:"name"`		// a registered evaluator
+	Command		[]string	`json:"command"`	// or a program to run
+	Timeout		int			`json:"timeout"`	// milliseconds to wait for scores
+}
+
+var evaluators = struct {
+	sync.Mutex
+	m		map[string]Evaluator
+	process	*ProcessEvaluator
+} { m: make(map[string]Evaluator) }
+
+func RegisterEvaluator (name string, e Evaluator) {
+	evaluators.Lock()
+	evaluators.m[name] = e
+	evaluators.Unlock()
+}
+
+func (c EvaluatorConfig) Check () error {
+	if len(c.Name) > 0 && len(c.Command) > 0 { return fmt.Errorf("evaluator has both a name and a command") }
+	if c.Timeout < 0 { return fmt.Errorf("evaluator timeout must not be negative") }
+	if len(c.Name) == 0 { return nil }
+	evaluators.Lock()
+	defer evaluators.Unlock()
+	if _, ok := evaluators.m[c.Name]; !ok { return fmt.Errorf("unknown evaluator %s", c.Name) }
+	return nil
+}
+
+// The evaluator the config asks for, if any
+func ActiveEvaluator () Evaluator {
+	evaluators.Lock()
+	defer evaluators.Unlock()
+	if len(config.Evaluator.Command) > 0 {
+		if evaluators.process == nil { evaluators.process = NewProcessEvaluator(config.Evaluator) }
+		return evaluators.process
+	}
+	if e, ok := evaluators.m[config.Evaluator.Name]; ok { return e }
+	return nil
+}
+
+// Score our moves with the active evaluator
+func (s *GameState) ScoreExternally (g Game, t int, b Board, you Snake, moves []MoveType) {
+	s.external = nil
+	if s.weights.ExternalWeight == 0 || !s.weights.Enabled("external") { return }
+	e := ActiveEvaluator()
+	if e == nil { return }
+
+	var dirs []string
+	for _,move := range moves { dirs = append(dirs, move.dir) }
+	scores, err := e.Score(g, t, b, you, dirs)
+	if err != nil {
+		s.info.Printf("Evaluator: %v\n", err)
+		return
+	}
+	s.external = scores
+}
+
+func (s *GameState) ExternalScore (move MoveType) float64 {
+	return s.weights.ExternalWeight * s.external[move.dir]
+}
+
+// ----------------------------------------------------------------
+// Process Evaluators
+// ----------------------------------------------------------------
+
+type evaluatorRequest struct {
+	ID		int64		`json:"id"`
+	MoveRequest
+	Moves	[]string	`json:"moves"`
+}
+
+type evaluatorReply struct {
+	ID		int64				`json:"id"`
+	Scores	map[string]float64	`json:"scores"`
+}
+
+type ProcessEvaluator struct {
+	sync.Mutex
+	command	[]string
+	timeout	time.Duration
+	in		io.WriteCloser
+	replies	chan evaluatorReply		// closed when the program exits
+	next	int64
+}
+
+func NewProcessEvaluator (c EvaluatorConfig) *ProcessEvaluator {
+	timeout := c.Timeout
+	if timeout == 0 { timeout = defaultEvaluatorTimeout }
+	return &ProcessEvaluator { command: c.Command, timeout: time.Duration(timeout) * time.Millisecond }
+}
+
+func (p *ProcessEvaluator) start () error {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil { return err }
+	out, err := cmd.StdoutPipe()
+	if err != nil { return err }
+	if err := cmd.Start(); err != nil { return err }
+
+	replies := make(chan evaluatorReply, 16)
+	go func() {
+		defer close(replies)
+		scanner := bufio.NewScanner(out)
+		scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+		for scanner.Scan() {
+			var reply evaluatorReply
+			if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil {
+				fmt.Printf("ERROR: Evaluator %s: %v\n", p.command[0], err)
+				continue
+			}
+			replies <- reply
+		}
+		cmd.Wait()
+	}()
+	p.in, p.replies = in, replies
+	return nil
+}
+
+func (p *ProcessEvaluator) Score (g Game, t int, b Board, you Snake, moves []string) (map[string]float64, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.replies == nil {
+		if err := p.start(); err != nil { return nil, err }
+	}
+
+	p.next++
+	request := evaluatorRequest { p.next, RemoteRequest(g, t, b, you), moves }
+	request.Game.Timeout = g.Timeout
+	data, err := json.Marshal(request)
+	if err != nil { return nil, err }
+	if _, err := p.in.Write(append(data, '\n')); err != nil {
+		p.in.Close()
+		p.replies = nil
+		return nil, err
+	}
+
+	// Answers to requests we gave up on may still come
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case reply, ok := <-p.replies:
+			if !ok {
+				p.in.Close()
+				p.replies = nil
+				return nil, fmt.Errorf("%s exited", p.command[0])
+			}
+			if reply.ID == request.ID { return reply.Scores, nil }
+		case <-timer.C:
+			return nil, fmt.Errorf("no scores from %s within %v", p.command[0], p.timeout)
+		}
+	}
+}
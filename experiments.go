@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ----------------------------------------------------------------
+// Experiments
+//
+// A risky change is rolled out to a share of our games first, so
+// that its effect can be measured against the games played without
+// it before it plays everywhere.  The config names each experiment,
+// the percentage of games to run it in, the sources of games it may
+// run in if not all of them, and the weights it lays over those the
+// game would otherwise play with, as a preset does:
+//
+//   "experiments": {
+//     "late-center": { "percent": 10, "sources": [ "custom", "arena" ],
+//                      "weights": { "centerFadeTurn": 300 } },
+//     "two-step-off": { "percent": 5, "weights": { "disable": [ "two-step" ] } }
+//   }
+//
+// Whether a game is in an experiment is decided at /start from the
+// game and experiment names, so it is the same for every snake of
+// ours in the game and on every server.  Heuristics still being
+// tried out can also check whether the game is in an experiment of
+// their name, with s.weights.Trying("late-center").
+//
+// Every game of a source an experiment may run in is recorded as
+// in it or not, on the game record and its summary, so the archive
+// can compare the two:
+//
+//   spacey-snake games report
+//   spacey-snake games grep -experiment late-center -result loss
+//   spacey-snake games grep -experiment late-center=off
+//
+// and the games and wins of each are counted in /metrics.
+// ----------------------------------------------------------------
+
+type Experiment struct {
+	Percent		float64			`json:"percent"`
+	Sources		[]string		`json:"sources"`
+	Weights		json.RawMessage	`json:"weights"`
+}
+
+func (e Experiment) Check () error {
+	if e.Percent < 0 || e.Percent > 100 { return fmt.Errorf("percent must be from 0 to 100, not %g", e.Percent) }
+	for _,source := range e.Sources {
+		if !knownSource(source) { return UnknownName("source", source, gameSources) }
+	}
+	if len(e.Weights) == 0 { return nil }
+	weights := DefaultWeights()
+	if err := DecodeConfig(e.Weights, &weights); err != nil { return fmt.Errorf("weights: %v", err) }
+	return weights.Check()
+}
+
+// Can the experiment run in games from a source?
+func (e Experiment) RunsIn (source string) bool {
+	if len(e.Sources) == 0 { return true }
+	for _,s := range e.Sources {
+		if s == source { return true }
+	}
+	return false
+}
+
+// Is a game in the experiment?  Spread evenly over hundredths of a
+// percent by the game and experiment, so that experiments are
+// assigned independently of each other
+func (e Experiment) Includes (name string, gameID string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + gameID))
+	return float64(h.Sum32() % 10000) < e.Percent * 100
+}
+
+// Is the game in an experiment of this name?
+func (w Weights) Trying (experiment string) bool {
+	for _,name := range w.Experiments {
+		if name == experiment { return true }
+	}
+	return false
+}
+
+// Decide which of the tenant's experiments a new game is in, laying
+// the weights of each it is in over the game's
+func AssignExperiments (id string, c *Config, g Game) {
+	var names []string
+	for name := range c.Experiments { names = append(names, name) }
+	sort.Strings(names)
+
+	context := GetContext(id)
+	weights := c.WeightsFor(g.Ruleset.Name)
+	if context.weights != nil { weights = *context.weights }
+	weights.Experiments = nil
+
+	arms := make(map[string]bool)
+	for _,name := range names {
+		e := c.Experiments[name]
+		if !e.RunsIn(g.Source) { continue }
+		arms[name] = e.Includes(name, g.ID)
+		if !arms[name] { continue }
+
+		// Weights are checked when the config is read, so this is a
+		// config changed under us; leave the game out of the experiment
+		trying := weights
+		trying.Disable = nil
+		if len(e.Weights) > 0 {
+			if err := json.Unmarshal(e.Weights, &trying); err != nil {
+				fmt.Printf("ERROR: Experiment %s: %v\n", name, err)
+				delete(arms, name)
+				continue
+			}
+		}
+		trying.Disable = append(append([]string(nil), weights.Disable...), trying.Disable...)
+		weights = trying
+		weights.Experiments = append(weights.Experiments, name)
+	}
+	if len(arms) == 0 { return }
+
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	if context, ok := gameContext.m[id]; ok {
+		context.experiments = arms
+		if len(weights.Experiments) > 0 { context.weights = &weights }
+		fmt.Printf("INFO(%s): Experiments %s\n", context.color, FormatArms(arms))
+	}
+}
+
+// The experiments a game is in and out of, as name=on or name=off
+func FormatArms (arms map[string]bool) string {
+	var names []string
+	for name := range arms { names = append(names, name) }
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i,name := range names { parts[i] = name + "=" + Arm(arms[name]) }
+	return strings.Join(parts, ",")
+}
+
+func Arm (in bool) string {
+	if in { return "on" }
+	return "off"
+}
+
+// Does a game summary match name, for games in the experiment, or
+// name=on or name=off?
+func (g GameSummary) InExperiment (spec string) bool {
+	parts := strings.SplitN(spec, "=", 2)
+	in, ok := g.Experiments[parts[0]]
+	if !ok { return false }
+	if len(parts) == 1 { return in }
+	return parts[1] == Arm(in)
+}
+
+// ----------------------------------------------------------------
+// Experiment stats
+// ----------------------------------------------------------------
+
+type armStats struct {
+	games	int
+	wins	int
+}
+
+var experimentStats struct {
+	sync.Mutex
+	m map[[3]string]*armStats	// by tenant, experiment and arm
+}
+
+func RecordExperimentOutcome (id string) {
+	context := GetContext(id)
+	if len(context.experiments) == 0 { return }
+	_, summary, ok := GameOutcome(id)
+	if !ok { return }
+
+	experimentStats.Lock()
+	defer experimentStats.Unlock()
+	if experimentStats.m == nil { experimentStats.m = make(map[[3]string]*armStats) }
+	for name,in := range context.experiments {
+		key := [3]string { context.Tenant().Name, name, Arm(in) }
+		stats, ok := experimentStats.m[key]
+		if !ok {
+			stats = new(armStats)
+			experimentStats.m[key] = stats
+		}
+		stats.games++
+		if summary.Result == "win" { stats.wins++ }
+	}
+}
+
+func WriteExperimentMetrics (w http.ResponseWriter) {
+	experimentStats.Lock()
+	defer experimentStats.Unlock()
+
+	var keys [][3]string
+	for key := range experimentStats.m { keys = append(keys, key) }
+	sort.Slice(keys, func(i, j int) bool {
+		for k := range keys[i] {
+			if keys[i][k] != keys[j][k] { return keys[i][k] < keys[j][k] }
+		}
+		return false
+	})
+	labels := func (key [3]string) string {
+		tenant := ""
+		if len(key[0]) > 0 { tenant = fmt.Sprintf("tenant=\"%s\",", EscapeLabel(key[0])) }
+		return fmt.Sprintf("%sexperiment=\"%s\",arm=\"%s\"", tenant, EscapeLabel(key[1]), key[2])
+	}
+
+	fmt.Fprintf(w, "# HELP spacey_experiment_games_total Games played in and out of each experiment.\n")
+	fmt.Fprintf(w, "# TYPE spacey_experiment_games_total counter\n")
+	for _,key := range keys {
+		fmt.Fprintf(w, "spacey_experiment_games_total{%s} %d\n", labels(key), experimentStats.m[key].games)
+	}
+	fmt.Fprintf(w, "# HELP spacey_experiment_wins_total Games won in and out of each experiment.\n")
+	fmt.Fprintf(w, "# TYPE spacey_experiment_wins_total counter\n")
+	for _,key := range keys {
+		fmt.Fprintf(w, "spacey_experiment_wins_total{%s} %d\n", labels(key), experimentStats.m[key].wins)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+// ----------------------------------------------------------------
+// Feature Vectors
+//
+// The inputs our heuristics look at for each candidate move, as a
+// flat vector of numbers so that ML experiments can train on our
+// recorded games without re-implementing the board logic.  The
+// order is given by FeatureNames and is stable: new features are
+// only ever added at the end.
+//
+// Game records carry the features of every move we considered on
+// each turn, alongside the frames, unless the config limits this to
+// games from some sources.
+// ----------------------------------------------------------------
+
+var FeatureNames = []string {
+	// The position
+	"turn", "health", "length", "space", "snakes", "largest", "shrinking",
+
+	// The move
+	"food", "hazard", "nlonger", "alternate", "nshorter",
+	"space_size", "space_snakes", "space_self", "small_space", "squeezed",
+	"corridor_length", "corridor_dead_end", "corridor_escape", "corridor_plugged",
+	"closer_to_longer", "closer_to_shorter", "food_dist",
+	"compactness", "center_penalty", "refuge_penalty", "pressure_penalty",
+}
+
+type MoveFeatures struct {
+	Move		string		`json:"move"`
+	Features	[]float64	`json:"features"`
+}
+
+type TurnFeatures struct {
+	Turn	int				`json:"turn"`
+	Move	string			`json:"move"`		// the move we chose
+	Reason	Reason			`json:"reason"`
+	Moves	[]MoveFeatures	`json:"moves"`
+}
+
+func boolFeature (b bool) float64 {
+	if b { return 1 }
+	return 0
+}
+
+// The features of one candidate move, in the order of FeatureNames
+func (s *GameState) Features (move MoveType) []float64 {
+	me := s.snakes[0]
+	largest := true
+	for _,snake := range s.snakes[1:] {
+		if snake.length > me.length { largest = false }
+	}
+
+	// Distance to the nearest food nobody else has claimed
+	foodDist := -1
+	for _,food := range s.food {
+		if food.claimed { continue }
+		if d := ManDist(move.c, food.pos); foodDist < 0 || d < foodDist { foodDist = d }
+	}
+
+	space := s.spaces[move.space]
+	return []float64 {
+		float64(s.turn),
+		float64(me.health),
+		float64(me.length),
+		float64(me.space),
+		float64(len(s.snakes)),
+		boolFeature(largest),
+		boolFeature(s.shrinking),
+
+		boolFeature(s.IsFood(move.c)),
+		boolFeature(s.IsHazard(move.c)),
+		float64(move.nlonger),
+		float64(move.alternate),
+		float64(move.nshorter),
+		float64(space.size),
+		float64(space.nsnakes),
+		boolFeature(space.self),
+		boolFeature(move.smallSpace),
+		boolFeature(move.squeezed),
+		float64(move.corridor.length),
+		boolFeature(move.corridor.deadEnd),
+		boolFeature(move.corridor.escape),
+		boolFeature(move.corridor.plugged),
+		float64(move.closerToLonger),
+		float64(move.closerToShorter),
+		float64(foodDist),
+		float64(s.Compactness(move.c, me.head)),
+		s.CenterPenalty(move.c),
+		s.RefugePenalty(move.c),
+		s.PressurePenalty(move.c),
+	}
+}
+
+// The features of every move considered for a decision.  Moves made
+// by a special case before the candidates are weighed have none.
+func (d MoveDecision) Features () TurnFeatures {
+	f := TurnFeatures { Move: d.Move, Reason: d.Reason, Moves: []MoveFeatures{} }
+	if d.state == nil { return f }
+	f.Turn = d.state.turn
+	for _,move := range d.Moves {
+		f.Moves = append(f.Moves, MoveFeatures { move.dir, d.state.Features(move) })
+	}
+	return f
+}
+
+// Add the features for a turn to the record, keeping the latest if
+// the turn is repeated
+func (r *GameRecord) AddFeatures (f TurnFeatures) {
+	if n := len(r.Features); n > 0 && r.Features[n-1].Turn == f.Turn {
+		r.Features = r.Features[:n-1]
+	}
+	r.Features = append(r.Features, f)
+}
+
+func RecordFeatures (id string, d MoveDecision) {
+	if d.state == nil || len(d.state.snakes) == 0 { return }
+	f := d.Features()
+
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok || context.record == nil || !context.Tenant().Config.VerboseSource(context.source) { return }
+	context.record.AddFeatures(f)
+}
+
+// Should games from a source record features?
+func (c Config) VerboseSource (source string) bool {
+	if len(c.VerboseSources) == 0 { return true }
+	for _,verbose := range c.VerboseSources {
+		if verbose == source { return true }
+	}
+	return false
+}
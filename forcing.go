@@ -0,0 +1,54 @@
+package main
+
+// ----------------------------------------------------------------
+// Forced Errors
+//
+// Rather than only keeping out of danger, we look for moves that
+// leave an opponent nothing good to do.  After our move, each of an
+// opponent's replies is checked: moving into a space smaller than
+// itself, or meeting our head when it is the shorter snake, loses.
+// If every reply an opponent has loses, our move has forced an error,
+// and a move that does so, and is safe for us, is taken ahead of any
+// other.  Only opponents whose heads are near enough for our move to
+// have made the difference are checked.
+// ----------------------------------------------------------------
+
+// The number of opponents whose every reply loses after we move to c
+func (s *GameState) ForcedErrors (c Coord) int {
+	me := s.snakes[0]
+	myLength := me.length
+	if s.IsFood(c) { myLength++ }
+
+	// Play our move on the grid while we look, our head moving to c
+	saved, savedHead := s.grid[c.X][c.Y], s.grid[me.head.X][me.head.Y]
+	s.grid[c.X][c.Y] = HeadCell(0)
+	s.grid[me.head.X][me.head.Y] = BodyCell(0)
+	defer func() {
+		s.grid[c.X][c.Y] = saved
+		s.grid[me.head.X][me.head.Y] = savedHead
+	}()
+
+	forced := 0
+	var buf [4]Coord
+	for _,snake := range s.snakes[1:] {
+		if snake.teammate || ManDist(snake.head, c) > 2 { continue }
+
+		replies, losing := 0, 0
+		for _,reply := range s.Neighbours(snake.head, &buf) {
+			if reply == c {
+				replies++
+				if snake.length < myLength { losing++ }
+				continue
+			}
+			if !s.IsFree(reply) { continue }
+			replies++
+			if s.ReachableSpace(reply) + 1 < snake.length { losing++ }
+		}
+		if replies > 0 && losing == replies {
+			s.debug.Printf("Snake at: [H](%d,%d) has only losing replies if we move to (%d,%d)\n",
+						   snake.head.X, snake.head.Y, c.X, c.Y)
+			forced++
+		}
+	}
+	return forced
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Garbage Collection
+//
+// A collection in the middle of deciding on a move costs us time we
+// would rather spend thinking, so every move notes how many
+// collections ran while it was being decided and how long they
+// paused for.  These are logged with the move and exported from
+// /metrics, and the bench command and the tests fail if allocations
+// creep past a budget:
+//
+//   spacey-snake bench -max-allocs 40 fixtures/*.json
+//   go test -run Allocs
+// ----------------------------------------------------------------
+
+var gcWatch struct {
+	sync.Mutex
+	stats		debug.GCStats		// reused, so that reading doesn't allocate
+	moves		int64				// moves with a collection while deciding
+	collections	int64				// collections while deciding
+	paused		time.Duration		// pause time of those collections
+}
+
+// Collections so far, to compare with after a move
+func NumGC () int64 {
+	gcWatch.Lock()
+	defer gcWatch.Unlock()
+	debug.ReadGCStats(&gcWatch.stats)
+	return gcWatch.stats.NumGC
+}
+
+// Count and log the collections since before, a count from NumGC
+func RecordMoveGC (before int64, d MoveDecision) {
+	gcWatch.Lock()
+	debug.ReadGCStats(&gcWatch.stats)
+	n := gcWatch.stats.NumGC - before
+	if n <= 0 {
+		gcWatch.Unlock()
+		return
+	}
+
+	// Pauses are listed most recent first
+	var paused time.Duration
+	for i := int64(0); i < n && i < int64(len(gcWatch.stats.Pause)); i++ { paused += gcWatch.stats.Pause[i] }
+	gcWatch.moves++
+	gcWatch.collections += n
+	gcWatch.paused += paused
+	gcWatch.Unlock()
+
+	if d.state != nil {
+		d.state.info.Printf("Garbage collected %d times during move, paused %v\n", n, paused)
+	}
+}
+
+func WriteGCMetrics (w http.ResponseWriter) {
+	gcWatch.Lock()
+	defer gcWatch.Unlock()
+
+	fmt.Fprintf(w, "# HELP spacey_gc_moves_total Moves during which the garbage collector ran.\n")
+	fmt.Fprintf(w, "# TYPE spacey_gc_moves_total counter\nspacey_gc_moves_total %d\n", gcWatch.moves)
+	fmt.Fprintf(w, "# HELP spacey_gc_collections_total Garbage collections while deciding on moves.\n")
+	fmt.Fprintf(w, "# TYPE spacey_gc_collections_total counter\nspacey_gc_collections_total %d\n", gcWatch.collections)
+	fmt.Fprintf(w, "# HELP spacey_gc_pause_seconds_total Pause time of collections while deciding on moves.\n")
+	fmt.Fprintf(w, "# TYPE spacey_gc_pause_seconds_total counter\nspacey_gc_pause_seconds_total %g\n", gcWatch.paused.Seconds())
+}
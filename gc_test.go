@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+// Allocations a move on an 11x11 board may cost, deciding from the
+// evaluation alone as bench -max-allocs checks in CI, and thinking
+// it through in tiers as a live move does
+const (
+	decideAllocBudget	= 40
+	findMoveAllocBudget	= 64
+)
+
+func TestDecideAllocs (t *testing.T) {
+	sim, you := benchPosition(t, "fixtures/edge-trap.json")
+	allocs := testing.AllocsPerRun(100, func () { Decide(sim.Game, sim.Turn, sim.Board, you) })
+	if allocs > decideAllocBudget {
+		t.Errorf("Decide allocates %.1f times a move, over the budget of %d", allocs, decideAllocBudget)
+	}
+}
+
+func TestFindMoveAllocs (t *testing.T) {
+	sim, you := benchPosition(t, "fixtures/edge-trap.json")
+	allocs := testing.AllocsPerRun(100, func () { FindMove(sim.Game, sim.Turn, sim.Board, you) })
+	if allocs > findMoveAllocBudget {
+		t.Errorf("FindMove allocates %.1f times a move, over the budget of %d", allocs, findMoveAllocBudget)
+	}
+}
+
+func TestNeighboursAllocs (t *testing.T) {
+	sim, you := benchPosition(t, "fixtures/edge-trap.json")
+	s := NewGameState(sim.Game, sim.Turn, sim.Board, you)
+	allocs := testing.AllocsPerRun(100, func () {
+		var buf [4]Coord
+		for y := 0; y < s.h; y++ {
+			for x := 0; x < s.w; x++ { s.Neighbours(Coord{ x, y }, &buf) }
+		}
+	})
+	if allocs > 0 { t.Errorf("Neighbours allocates %.1f times a board", allocs) }
+}
+
+func TestNumGCAllocs (t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func () { NumGC() })
+	if allocs > 0 { t.Errorf("NumGC allocates %.1f times a call", allocs) }
+}
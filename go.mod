@@ -3,6 +3,13 @@ module github.com/blainey/spacey-snake
 // +heroku goVersion go1.14
 go 1.14
 
+// gRPC is the only module the server itself imports.  Add another
+// only when a request can't be met without one, and only if it still
+// builds with the go 1.14 runtime Heroku gives us.  grpc v1.43.0 does,
+// and its messages travel as JSON, so there is no protobuf code to
+// generate.  WASM runtimes fail the second test: wazero needs a much
+// newer Go and the others need cgo, so a WASM model is run as an
+// evaluator program instead.
 require (
 	github.com/go-delve/delve v1.4.0 // indirect
 	github.com/ramya-rao-a/go-outline v0.0.0-20200117021646-2a048b4510eb // indirect
@@ -10,4 +17,5 @@ require (
 	github.com/stamblerre/gocode v1.0.0 // indirect
 	github.com/uudashr/gopkgs/v2 v2.1.2 // indirect
 	golang.org/x/tools v0.0.0-20200415034506-5d8e1897c761 // indirect
+	google.golang.org/grpc v1.43.0
 )
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ----------------------------------------------------------------
+// gRPC
+//
+// Local tools and engines that don't speak HTTP can play us over
+// gRPC instead, with less overhead per move.  With GRPC_PORT set,
+// the server also serves a spaceysnake.Snake service alongside the
+// HTTP one, with the same engine behind both:
+//
+//   /spaceysnake.Snake/Start    a StartRequest, answered as /start
+//   /spaceysnake.Snake/Move     a MoveRequest, answered as /move
+//   /spaceysnake.Snake/End      an EndRequest, answered with {}
+//   /spaceysnake.Snake/Analyze  a MoveRequest, answered as /analyze
+//
+// Messages are the JSON of the HTTP API rather than protocol buffers,
+// so a client has to ask for the "json" content subtype, that is a
+// content-type of application/grpc+json.  The tenant, preset and
+// quirks that the HTTP server takes from the URL are taken from the
+// "tenant", "preset" and "quirks" metadata of the call to Start.
+//
+// The service listens on this machine only, unless GRPC_HOST names
+// the address to listen on, and is held to the same protection as
+// the HTTP server: calls not from the engine are rate limited, all
+// are capped at maxBody bytes, Start, Move and End have to come from
+// an engine we know or carry ENGINE_SECRET as "x-engine-secret"
+// metadata, and Analyze needs ADMIN_TOKEN as "authorization"
+// metadata, "Bearer <token>":
+//
+//   GRPC_PORT=9090 GRPC_HOST=0.0.0.0 ENGINE_SECRET=... spacey-snake
+//
+// Remote participants in our simulations can be given as grpc://
+// URLs, to be played this way, with the URL's query sent as metadata:
+//
+//   spacey-snake tournament -games 50 default grpc://localhost:9090?preset=hunter
+// ----------------------------------------------------------------
+
+const grpcService = "spaceysnake.Snake"
+
+type jsonCodec struct {}
+
+func (jsonCodec) Marshal (v interface{}) ([]byte, error) { return json.Marshal(v) }
+func (jsonCodec) Unmarshal (data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name () string { return "json" }
+
+func init () {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// A unary method of the service, whose requests are made by
+// newRequest and answered by handle
+func grpcMethod (name string, newRequest func () interface{},
+				 handle func (ctx context.Context, request interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc {
+		MethodName:	name,
+		Handler:	func (srv interface{}, ctx context.Context, dec func (interface{}) error,
+						  interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			arrived := time.Now()
+			request := newRequest()
+			if err := dec(request); err != nil { return nil, status.Error(codes.InvalidArgument, err.Error()) }
+			ctx = context.WithValue(ctx, grpcArrived{}, arrived)
+			if interceptor == nil { return handle(ctx, request) }
+			info := &grpc.UnaryServerInfo { Server: srv, FullMethod: "/" + grpcService + "/" + name }
+			return interceptor(ctx, request, info, handle)
+		},
+	}
+}
+
+// When a call arrived, before its request was decoded
+type grpcArrived struct {}
+
+// The first value of a call's metadata under key
+func grpcMetadata (ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok { return "" }
+	if values := md.Get(key); len(values) > 0 { return values[0] }
+	return ""
+}
+
+var snakeService = grpc.ServiceDesc {
+	ServiceName:	grpcService,
+	HandlerType:	(*interface{})(nil),
+	Methods:		[]grpc.MethodDesc {
+		grpcMethod("Start", func () interface{} { return &StartRequest{} }, grpcStart),
+		grpcMethod("Move", func () interface{} { return &MoveRequest{} }, grpcMove),
+		grpcMethod("End", func () interface{} { return &EndRequest{} }, grpcEnd),
+		grpcMethod("Analyze", func () interface{} { return &MoveRequest{} }, grpcAnalyze),
+	},
+	Metadata:		"grpc.go",
+}
+
+// The tenant named by a call's metadata, if any
+func grpcTenant (ctx context.Context) (*Tenant, error) {
+	name := grpcMetadata(ctx, "tenant")
+	if len(name) == 0 { return ourTenant, nil }
+	tenant, ok := TenantNamed(name)
+	if !ok { return nil, status.Errorf(codes.NotFound, "unknown tenant %s", name) }
+	return tenant, nil
+}
+
+// The quirks of the engine making a call, told from its request and
+// the preset and quirks of the call's metadata
+func grpcQuirks (ctx context.Context, tenant *Tenant) func (request *StartRequest) Quirks {
+	return func (request *StartRequest) Quirks {
+		return tenant.Config.StartQuirks(request, grpcMetadata(ctx, "preset"), grpcMetadata(ctx, "quirks"))
+	}
+}
+
+func grpcStart (ctx context.Context, r interface{}) (interface{}, error) {
+	request := r.(*StartRequest)
+	tenant, err := grpcTenant(ctx)
+	if err != nil { return nil, err }
+	PrepareRequest(request, grpcQuirks(ctx, tenant))
+	response := StartGame(*request, tenant, grpcMetadata(ctx, "preset"))
+	return &response, nil
+}
+
+func grpcMove (ctx context.Context, r interface{}) (interface{}, error) {
+	request := r.(*MoveRequest)
+	if len(request.You.ID) == 0 { return nil, status.Error(codes.InvalidArgument, "expected a move request") }
+	tenant, err := grpcTenant(ctx)
+	if err != nil { return nil, err }
+	PrepareRequest(request, grpcQuirks(ctx, tenant))
+	arrived, _ := ctx.Value(grpcArrived{}).(time.Time)
+	var response MoveResponse
+	PlayMove(*request, arrived, time.Now(), func (move, shout string) { response = MoveResponse { move, shout } })
+	return &response, nil
+}
+
+func grpcEnd (ctx context.Context, r interface{}) (interface{}, error) {
+	request := r.(*EndRequest)
+	tenant, err := grpcTenant(ctx)
+	if err != nil { return nil, err }
+	PrepareRequest(request, grpcQuirks(ctx, tenant))
+	EndGame(*request)
+	return &struct{}{}, nil
+}
+
+func grpcAnalyze (ctx context.Context, r interface{}) (interface{}, error) {
+	request := r.(*MoveRequest)
+	if len(request.You.Body) == 0 { return nil, status.Error(codes.InvalidArgument, "expected a move request") }
+	maps := NewGameState(request.Game, request.Turn, request.Board, request.You).Maps()
+	return &maps, nil
+}
+
+// Hold a call to the checks the HTTP server makes of its request: the
+// rate limit for every call, and the engine check for the game calls
+// or the admin check for Analyze
+func grpcGuard (ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+				handler grpc.UnaryHandler) (interface{}, error) {
+	address := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		address = p.Addr.String()
+		if host, _, err := net.SplitHostPort(address); err == nil { address = host }
+	}
+	secret := grpcMetadata(ctx, "x-engine-secret")
+	if !EngineVerified(address, secret) && !config.Protection.Allow(address, time.Now()) {
+		CountRejection("rate")
+		return nil, status.Error(codes.ResourceExhausted, "too many requests")
+	}
+	if info.FullMethod == "/" + grpcService + "/Analyze" {
+		token := strings.TrimPrefix(grpcMetadata(ctx, "authorization"), "Bearer ")
+		if !AdminAllowed(address, token) {
+			CountRejection("auth")
+			return nil, status.Error(codes.Unauthenticated, "admin token required")
+		}
+	} else if !EngineAllowed(address, secret) {
+		CountRejection("engine")
+		return nil, status.Error(codes.PermissionDenied, "not from a known engine")
+	}
+	return handler(ctx, request)
+}
+
+// Serve the snake over gRPC too, if GRPC_PORT is set
+func StartGRPC () {
+	port := os.Getenv("GRPC_PORT")
+	if len(port) == 0 { return }
+	host := os.Getenv("GRPC_HOST")
+	if len(host) == 0 { host = "127.0.0.1" }
+	address := net.JoinHostPort(host, port)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: gRPC: %v\n", err)
+		return
+	}
+	options := []grpc.ServerOption { grpc.UnaryInterceptor(grpcGuard) }
+	if max := config.Protection.MaxBody; max > 0 { options = append(options, grpc.MaxRecvMsgSize(int(max))) }
+	server := grpc.NewServer(options...)
+	server.RegisterService(&snakeService, nil)
+	fmt.Printf("Starting gRPC Server at %s...\n", address)
+	go func() {
+		if err := server.Serve(listener); err != nil { fmt.Fprintf(os.Stderr, "ERROR: gRPC: %v\n", err) }
+	}()
+}
+
+// ----------------------------------------------------------------
+// gRPC Clients
+// ----------------------------------------------------------------
+
+func IsGRPC (spec string) bool { return strings.HasPrefix(spec, "grpc://") }
+
+func DialGRPC (host string) (*grpc.ClientConn, error) {
+	return grpc.Dial(host, grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")))
+}
+
+// Call the method of the service for an HTTP endpoint, such as /move,
+// passing the parameters of a URL's query as metadata
+func InvokeGRPC (conn *grpc.ClientConn, endpoint string, query url.Values, timeout time.Duration, request, reply interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if len(query) > 0 { ctx = metadata.NewOutgoingContext(ctx, metadata.MD(query)) }
+	if len(engineSecret) > 0 { ctx = metadata.AppendToOutgoingContext(ctx, "x-engine-secret", engineSecret) }
+	name := strings.TrimPrefix(endpoint, "/")
+	if len(name) > 0 { name = strings.ToUpper(name[:1]) + name[1:] }
+	return conn.Invoke(ctx, "/" + grpcService + "/" + name, request, reply)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Protection
+//
+// The server is open to the internet, so scanners and abusive
+// clients mustn't be able to take the CPU our live games need.  Every
+// request passes three checks before it is handled:
+//
+//   rate     each address gets "rate" requests a second, in bursts of
+//            up to "burst", and is answered 429 beyond that
+//   paths    only the paths we serve, or those the config lists, are
+//            answered, with 404 for anything else; a listed path
+//            ending in / allows everything under it
+//   maxBody  a request body larger than this many bytes, once
+//            decompressed, is answered 413
+//
+// Behind a proxy such as Heroku's router every request comes from
+// the router, so with "proxied" set the address is taken from the
+// last entry of X-Forwarded-For, the one the proxy added:
+//
+//   "protection": { "rate": 50, "burst": 100, "maxBody": 1048576, "proxied": true }
+//
+// The engine plays every game from a handful of addresses, so a rate
+// limit would turn away moves in a busy arena.  Rate limiting is off
+// unless the config sets a rate, and even then requests verified as
+// coming from the engine, by its addresses or ENGINE_SECRET, are
+// never limited; see Engine Verification.  A rate or maxBody of 0 is
+// no limit.  Local tools, such as loadtest, run on this machine, so
+// with "trustLoopback" set requests from a loopback address are
+// never limited either.  The gRPC service, if there is one, is held
+// to the same limits.
+// ----------------------------------------------------------------
+
+type Protection struct {
+	Rate		float64		`json:"rate"`		// requests a second from one address
+	Burst		int			`json:"burst"`		// requests at once, twice the rate if 0
+	MaxBody		int64		`json:"maxBody"`	// bytes in a request body
+	Paths		[]string	`json:"paths"`		// the paths to answer, all we serve if empty
+	Proxied		bool		`json:"proxied"`	// trust X-Forwarded-For
+	Engines		[]string	`json:"engines"`	// addresses games may come from, any if empty
+	PublicGames	bool		`json:"publicGames"`	// serve recorded games without the admin token
+	TrustLoopback	bool	`json:"trustLoopback"`	// let this machine through without credentials
+	engines		[]*net.IPNet
+}
+
+func DefaultProtection () Protection {
+	return Protection { MaxBody: 1 << 20 }
+}
+
+func (p Protection) Check () error {
+	if p.Rate < 0 || p.Burst < 0 || p.MaxBody < 0 { return fmt.Errorf("protection limits must not be negative") }
+	for _,path := range p.Paths {
+		if !strings.HasPrefix(path, "/") { return fmt.Errorf("protected path %s must start with /", path) }
+	}
+	_, err := ParseAddresses(p.Engines)
+	return err
+}
+
+// Parse addresses and networks, such as 10.0.0.0/8
+func ParseAddresses (list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _,s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil { return nil, fmt.Errorf("bad address %s", s) }
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil { ip, bits = ip4, 32 }
+			nets = append(nets, &net.IPNet { IP: ip, Mask: net.CIDRMask(bits, bits) })
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil { return nil, err }
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Forget addresses that have been quiet for this long, once there
+// are more than maxBuckets
+const bucketIdle = time.Minute
+const maxBuckets = 10000
+
+type bucket struct {
+	tokens	float64
+	last	time.Time
+}
+
+var guard = struct {
+	sync.Mutex
+	buckets		map[string]*bucket
+	rejected	map[string]int64	// by reason
+} { buckets: make(map[string]*bucket), rejected: make(map[string]int64) }
+
+// The address a request comes from
+func (p Protection) Address (r *http.Request) string {
+	if p.Proxied {
+		if forwarded := r.Header["X-Forwarded-For"]; len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil { return r.RemoteAddr }
+	return host
+}
+
+// Is an address one we let through without credentials or limits?
+func (p Protection) Trusted (address string) bool {
+	if !p.TrustLoopback { return false }
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Take a token from an address's bucket, if it has one
+func (p Protection) Allow (address string, now time.Time) bool {
+	if p.Rate == 0 || p.Trusted(address) { return true }
+	burst := float64(p.Burst)
+	if burst == 0 { burst = 2 * p.Rate }
+
+	guard.Lock()
+	defer guard.Unlock()
+	if len(guard.buckets) > maxBuckets {
+		for a,b := range guard.buckets {
+			if now.Sub(b.last) > bucketIdle { delete(guard.buckets, a) }
+		}
+	}
+	b, ok := guard.buckets[address]
+	if !ok {
+		b = &bucket { tokens: burst, last: now }
+		guard.buckets[address] = b
+	}
+	b.tokens += p.Rate * now.Sub(b.last).Seconds()
+	if b.tokens > burst { b.tokens = burst }
+	b.last = now
+	if b.tokens < 1 { return false }
+	b.tokens--
+	return true
+}
+
+// Is a request for a path we answer?
+func (p Protection) Serves (r *http.Request, mux *http.ServeMux) bool {
+	if len(p.Paths) == 0 {
+		_, pattern := mux.Handler(r)
+		return pattern != "/" || r.URL.Path == "/"
+	}
+	for _,path := range p.Paths {
+		if r.URL.Path == path || (strings.HasSuffix(path, "/") && strings.HasPrefix(r.URL.Path, path)) { return true }
+	}
+	return false
+}
+
+func reject (w http.ResponseWriter, reason string, status int) {
+	CountRejection(reason)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func CountRejection (reason string) {
+	guard.Lock()
+	guard.rejected[reason]++
+	guard.Unlock()
+}
+
+// Check every request against the config's protection before mux
+// handles it
+func Guard (mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+		p := config.Protection
+		address := p.Address(r)
+		if !EngineVerified(address, r.Header.Get("X-Engine-Secret")) && !p.Allow(address, time.Now()) {
+			reject(w, "rate", http.StatusTooManyRequests)
+			return
+		}
+		if !p.Serves(r, mux) {
+			reject(w, "path", http.StatusNotFound)
+			return
+		}
+		if p.MaxBody > 0 {
+			if r.ContentLength > p.MaxBody {
+				reject(w, "body", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, p.MaxBody)
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func WriteGuardMetrics (w http.ResponseWriter) {
+	guard.Lock()
+	defer guard.Unlock()
+
+	fmt.Fprintf(w, "# HELP spacey_rejected_requests_total Requests turned away by our protection, by reason.\n")
+	fmt.Fprintf(w, "# TYPE spacey_rejected_requests_total counter\n")
+	var reasons []string
+	for reason := range guard.rejected { reasons = append(reasons, reason) }
+	sort.Strings(reasons)
+	for _,reason := range reasons {
+		fmt.Fprintf(w, "spacey_rejected_requests_total{reason=\"%s\"} %d\n", reason, guard.rejected[reason])
+	}
+	fmt.Fprintf(w, "# HELP spacey_rate_limited_addresses Addresses whose request rate we are tracking.\n")
+	fmt.Fprintf(w, "# TYPE spacey_rate_limited_addresses gauge\nspacey_rate_limited_addresses %d\n", len(guard.buckets))
+}
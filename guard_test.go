@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProtectionAllow (t *testing.T) {
+	now := time.Now()
+	limited := Protection { Rate: 1, Burst: 2 }
+	tests := []struct {
+		name		string
+		p			Protection
+		address		string
+		at			[]time.Duration		// after now, in order
+		allowed		[]bool
+	}{
+		{ "no rate", Protection{}, "192.0.2.10", []time.Duration{ 0, 0, 0, 0 }, []bool{ true, true, true, true } },
+		{ "burst then limited", limited, "192.0.2.11", []time.Duration{ 0, 0, 0 }, []bool{ true, true, false } },
+		{ "refilled", limited, "192.0.2.12", []time.Duration{ 0, 0, 0, time.Second }, []bool{ true, true, false, true } },
+		{ "twice the rate by default", Protection { Rate: 2 }, "192.0.2.13", []time.Duration{ 0, 0, 0, 0, 0 },
+		  []bool{ true, true, true, true, false } },
+		{ "loopback limited", limited, "127.0.0.1", []time.Duration{ 0, 0, 0 }, []bool{ true, true, false } },
+		{ "loopback trusted", Protection { Rate: 1, Burst: 2, TrustLoopback: true }, "::1", []time.Duration{ 0, 0, 0 },
+		  []bool{ true, true, true } },
+	}
+	for _,test := range tests {
+		guard.Lock()
+		delete(guard.buckets, test.address)
+		guard.Unlock()
+		for i,at := range test.at {
+			if allowed := test.p.Allow(test.address, now.Add(at)); allowed != test.allowed[i] {
+				t.Errorf("%s: request %d allowed %v, expected %v", test.name, i, allowed, test.allowed[i])
+			}
+		}
+	}
+}
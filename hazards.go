@@ -0,0 +1,79 @@
+package main
+
+// ----------------------------------------------------------------
+// Hazards
+//
+// A hazard cell costs hazardDamage health on top of the usual one a
+// turn, and once in we have to keep paying until we are out again.
+// That is sometimes worth it, to reach food or cut across an
+// opponent, so hazards aren't ruled out, but each move into one is
+// charged for the health it will cost to get out again, at
+// hazardWeight per point of health.  The lower our health, the more
+// every point is worth.  A move that would cost more health than we
+// have, with no food to make up for it, is treated as a small space
+// and only taken if nothing else is open.
+//
+// Food in a hazard is only worth going for if eating it restores more
+// health than the round trip costs, by hazardFoodMargin.  We arrive
+// down by a point a move and the hazard's damage on the way in, and
+// pay the damage again on the way out.
+// ----------------------------------------------------------------
+
+const hazardFoodMargin = 20
+
+// Moves from a cell to the nearest cell outside the hazards, counting
+// the cell itself, or 0 if it isn't in a hazard
+func (s *GameState) HazardRun (c Coord) int {
+	if !s.IsHazard(c) { return 0 }
+	run := s.w + s.h
+	for x := 0; x < s.w; x++ {
+		for y := 0; y < s.h; y++ {
+			if s.grid[x][y].hazard { continue }
+			if d := ManDist(c, Coord{ x, y }); d < run { run = d }
+		}
+	}
+	return run
+}
+
+// Health it will cost us to cross the hazard from a cell, less the
+// first cell's cost if there is food there to restore our health
+func (s *GameState) HazardHealth (c Coord) int {
+	run := s.HazardRun(c)
+	if run == 0 { return 0 }
+	if s.IsFood(c) { run-- }
+	return run * (hazardDamage + 1)
+}
+
+// Our health as we start across the hazard from a cell
+func (s *GameState) HazardStart (c Coord) int {
+	if s.IsFood(c) { return 100 }
+	return s.snakes[0].health
+}
+
+// Would crossing the hazard from a cell starve us?
+func (s *GameState) HazardFatal (c Coord) bool {
+	if !s.weights.Enabled("hazards") { return false }
+	return s.HazardHealth(c) >= s.HazardStart(c)
+}
+
+func (s *GameState) HazardCost (c Coord) float64 {
+	if !s.weights.Enabled("hazards") { return 0 }
+	health := s.HazardHealth(c)
+	if health == 0 { return 0 }
+	start := s.HazardStart(c)
+	if start < 1 { start = 1 }
+	return s.weights.HazardWeight * float64(health) * 100 / float64(start)
+}
+
+// Damage taken going into the hazard for food and out again
+func (s *GameState) HazardRoundTrip (food FoodState) int {
+	if food.hazardRun == 0 { return 0 }
+	return (2*food.hazardRun - 1) * hazardDamage
+}
+
+func (s *GameState) FoodWorthIt (food FoodState) bool {
+	if food.hazardRun == 0 || !s.weights.Enabled("hazards") { return true }
+	arrival := s.snakes[0].health - food.dist - food.hazardRun * hazardDamage
+	if arrival <= 0 { return false }
+	return 100 - arrival - s.HazardRoundTrip(food) >= hazardFoodMargin
+}
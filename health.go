@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Health Checks
+//
+// /healthz answers as long as the process is up.  /readyz only
+// answers OK once everything we need to play is in place: the config
+// and its weights are loaded, the game contexts are set up, and the
+// record directory of each tenant, ours included, can be written
+// to.  Orchestrators should gate traffic on /readyz rather than the
+// cosmetic /ping.
+// ----------------------------------------------------------------
+
+var configLoaded bool
+
+func HandleHealthz (w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok\n")
+}
+
+// Check everything a move depends on, returning what isn't ready
+func ReadinessProblems () []string {
+	var problems []string
+	if !configLoaded { problems = append(problems, "config not loaded") }
+
+	gameContext.RLock()
+	if gameContext.m == nil { problems = append(problems, "game contexts not initialized") }
+	gameContext.RUnlock()
+
+	problems = append(problems, WALProblems()...)
+	for _,t := range Tenants() {
+		dir := t.RecordDir()
+		if len(dir) == 0 { continue }
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			problems = append(problems, "record directory missing: " + dir)
+		} else if f, err := ioutil.TempFile(dir, ".readyz"); err != nil {
+			problems = append(problems, "record directory not writable: " + dir)
+		} else {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	return problems
+}
+
+func HandleReadyz (w http.ResponseWriter, r *http.Request) {
+	if problems := ReadinessProblems(); len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ok\n")
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ----------------------------------------------------------------
+// Impact of a Weight Change
+//
+// Before deploying new weights, how much of our play do they change?
+// Given two sets of weights, as for tournaments, the impact command
+// goes through every recorded game in the archive and
+//
+//   - decides each turn we played with both, counting the turns on
+//     which the move would change
+//   - plays each game again from its first frame with both, the
+//     opponents making the moves they made in the record and then
+//     going straight, and food appearing where it did, and lists
+//     the games where we survive with one and not the other
+//
+//   spacey-snake impact current.json candidate.json
+//
+// Moves are decided by the heuristics alone, without rollouts, so
+// that the comparison is the same from one run to the next.
+// ----------------------------------------------------------------
+
+type GameImpact struct {
+	ID			string
+	Turns		int			// turns we played
+	Changed		int			// turns our move would change
+	Survived	[2]int		// turns survived when played again with each set of weights
+	Alive		[2]bool		// were we alive at the end?
+}
+
+func (g GameImpact) Flipped () bool { return g.Alive[0] != g.Alive[1] }
+
+// Decide our move as a snake with the given weights
+func DecideWith (weights Weights, g Game, t int, b Board, you Snake) MoveDecision {
+	gameContext.RLock()
+	_, ok := gameContext.m[you.ID]
+	gameContext.RUnlock()
+	if !ok { NewContext(you.ID, b) }
+
+	gameContext.Lock()
+	gameContext.m[you.ID].weights = &weights
+	gameContext.Unlock()
+	return Decide(g, t, b, you)
+}
+
+func ForgetContext (id string) {
+	gameContext.Lock()
+	delete(gameContext.m, id)
+	gameContext.Unlock()
+}
+
+// The move each snake made from each frame of a record, by turn
+func RecordedMoves (r *GameRecord) map[int]map[string]string {
+	moves := make(map[int]map[string]string)
+	for fx := 0; fx+1 < len(r.Frames); fx++ {
+		heads := make(map[string]Coord)
+		for _,snake := range r.Frames[fx+1].Snakes {
+			if snake.Death == nil && len(snake.Body) > 0 { heads[snake.ID] = Coord{ snake.Body[0].X, snake.Body[0].Y } }
+		}
+		turn := make(map[string]string)
+		for _,snake := range r.Frames[fx].Snakes {
+			next, ok := heads[snake.ID]
+			if !ok || snake.Death != nil || len(snake.Body) == 0 { continue }
+			head := Coord{ snake.Body[0].X, snake.Body[0].Y }
+			if ManDist(head, next) == 1 { turn[snake.ID] = Direction(head, next) }
+		}
+		moves[r.Frames[fx].Turn] = turn
+	}
+	return moves
+}
+
+// Play a recorded game again with our snake on the given weights,
+// returning the turns we survived and whether we were alive at the end
+func Replay (r *GameRecord, weights Weights, recorded map[int]map[string]string) (int, bool) {
+	first := r.Frames[0]
+	g := Game { ID: r.Game.ID, Ruleset: Ruleset { Name: r.Game.Ruleset["name"] } }
+	sim := NewSimulation(g, first.Turn, FrameBoard(r, first))
+	last := r.Frames[len(r.Frames)-1].Turn
+
+	food := make(map[int][]Coord)
+	for fx := 1; fx < len(r.Frames); fx++ {
+		had := make(map[Coord]bool)
+		for _,c := range r.Frames[fx-1].Food { had[Coord{ c.X, c.Y }] = true }
+		for _,c := range r.Frames[fx].Food {
+			if !had[Coord{ c.X, c.Y }] { food[r.Frames[fx].Turn] = append(food[r.Frames[fx].Turn], Coord{ c.X, c.Y }) }
+		}
+	}
+
+	for sim.Turn < last {
+		you, ok := sim.Snake(r.You)
+		if !ok { break }
+		moves := make(map[string]string)
+		for id,move := range recorded[sim.Turn] { moves[id] = move }
+		moves[r.You] = DecideWith(weights, sim.Game, sim.Turn, sim.Board, you).Move
+		sim.Step(moves)
+		sim.Board.Food = append(sim.Board.Food, food[sim.Turn]...)
+	}
+	_, alive := sim.Snake(r.You)
+	return sim.Turn - first.Turn, alive
+}
+
+func ImpactCommand (args []string) int {
+	flags := flag.NewFlagSet("impact", flag.ExitOnError)
+	dir := flags.String("dir", RecordDir(), "directory of recorded games, RECORD_DIR by default")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake impact [-dir d] weights weights\n")
+		return 2
+	}
+	if len(*dir) == 0 { *dir = "." }
+
+	paths, err := filepath.Glob(filepath.Join(*dir, "*.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	engineLogging = false
+	var impacts []GameImpact
+	turns, changed, flipped := 0, 0, 0
+	for _,path := range paths {
+		r, err := ReadGameRecord(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if len(r.Frames) == 0 || len(r.You) == 0 { continue }
+
+		var weights [2]Weights
+		for i,spec := range flags.Args() {
+			participant, err := LoadParticipant(spec, r.Game.Ruleset["name"])
+			if err == nil && participant.Weights == nil { err = fmt.Errorf("%s has no weights", spec) }
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			weights[i] = *participant.Weights
+		}
+
+		impact := GameImpact { ID: r.Game.ID }
+		g := Game { ID: r.Game.ID, Ruleset: Ruleset { Name: r.Game.Ruleset["name"] } }
+		for fx,frame := range r.Frames {
+			if len(r.OurMove(fx)) == 0 { continue }
+			b := FrameBoard(r, frame)
+			for _,you := range b.Snakes {
+				if you.ID != r.You { continue }
+				impact.Turns++
+				if DecideWith(weights[0], g, frame.Turn, b, you).Move != DecideWith(weights[1], g, frame.Turn, b, you).Move {
+					impact.Changed++
+				}
+			}
+		}
+
+		recorded := RecordedMoves(r)
+		for i := range weights {
+			ForgetContext(r.You)
+			impact.Survived[i], impact.Alive[i] = Replay(r, weights[i], recorded)
+		}
+		ForgetContext(r.You)
+
+		turns += impact.Turns
+		changed += impact.Changed
+		if impact.Flipped() { flipped++ }
+		impacts = append(impacts, impact)
+	}
+
+	a, b := flags.Arg(0), flags.Arg(1)
+	for _,impact := range impacts {
+		if !impact.Flipped() { continue }
+		fmt.Printf("%s changed=%d/%d %s: alive=%t turns=%d %s: alive=%t turns=%d\n", impact.ID, impact.Changed,
+				   impact.Turns, a, impact.Alive[0], impact.Survived[0], b, impact.Alive[1], impact.Survived[1])
+	}
+	percent := 0.0
+	if turns > 0 { percent = 100 * float64(changed) / float64(turns) }
+	fmt.Printf("%d of %d turns change (%.1f%%), %d of %d games flip\n", changed, turns, percent, flipped, len(impacts))
+	return 0
+}
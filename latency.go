@@ -0,0 +1,75 @@
+package main
+
+import "strconv"
+
+// ----------------------------------------------------------------
+// Latency
+//
+// The engine tells us how long each snake took to respond last turn.
+// A snake that keeps coming close to the timeout is struggling to
+// search its position, and if it times out the engine moves it in
+// the direction it was already going, which is often fatal.  So when
+// a shorter snake is slow we crowd its head, giving it more branches
+// to think about and less room for a default move to be safe.
+// Latencies also go into the opponent models, so a snake that has
+// been slow in our other games counts as slow from the start.
+// ----------------------------------------------------------------
+
+// How many turns of latency we keep for each snake, and how many of
+// them must be close to the timeout for the snake to count as slow
+const latencyHistory = 5
+const slowTurns = 2
+
+// The fraction of the timeout that counts as close to it
+const slowFraction = 0.8
+
+const defaultTimeout = 500
+
+func RecordLatencies (id string, g Game, turn int, snakes []Snake) {
+	timeout := g.Timeout
+	if timeout <= 0 { timeout = defaultTimeout }
+
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok { return }
+	if context.latencies == nil { context.latencies = make(map[string][]int) }
+	if len(context.latencies) > 0 && context.latencyTurn == turn { return }
+	context.latencyTurn = turn
+
+	for _,snake := range snakes {
+		latency, err := strconv.Atoi(snake.Latency)
+		if err != nil { continue }
+		if snake.ID != id { context.Tenant().Opponents.Observe(snake.Name, g.ID, turn, float64(latency) >= slowFraction * float64(timeout)) }
+		history := append(context.latencies[snake.ID], latency)
+		if len(history) > latencyHistory { history = history[len(history)-latencyHistory:] }
+		context.latencies[snake.ID] = history
+	}
+}
+
+// Has a snake come close to the timeout on enough recent turns?
+func SlowSnake (id string, snakeID string, timeout int) bool {
+	if timeout <= 0 { timeout = defaultTimeout }
+
+	gameContext.RLock()
+	defer gameContext.RUnlock()
+	context, ok := gameContext.m[id]
+	if !ok { return false }
+
+	n := 0
+	for _,latency := range context.latencies[snakeID] {
+		if float64(latency) >= slowFraction * float64(timeout) { n++ }
+	}
+	return n >= slowTurns
+}
+
+func (s *GameState) PressurePenalty (c Coord) float64 {
+	if !s.weights.Enabled("pressure") { return 0 }
+
+	penalty := 0.0
+	for _,snake := range s.snakes[1:] {
+		if !snake.slow || snake.teammate || snake.length >= s.snakes[0].length { continue }
+		penalty += s.weights.PressureWeight * float64(ManDist(c, snake.head))
+	}
+	return penalty
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// ----------------------------------------------------------------
+// Layered Configs
+//
+// Weights are built up in layers, so that a change is made once, in
+// the layer it belongs to, rather than in forked copies of the whole
+// weight set:
+//
+//   defaults   the built in weights
+//   base       the "*" profile, for every ruleset
+//   ruleset    the profile of the ruleset being played
+//   preset     the preset chosen for the game, if any
+//
+// and a config can be laid over another with "extends", naming the
+// config underneath relative to its own file, so that tournament day
+// tweaks are a small override file:
+//
+//   events/worlds.json
+//   {
+//     "extends": "../config.json",
+//     "profiles": { "*": { "threatTolerance": 0.1 }, "royale": { "refugeWeight": null } },
+//     "strategies": [ { "source": "tournament", "preset": "cautious" } ]
+//   }
+//
+//   CONFIG=events/worlds.json spacey-snake
+//
+// Configs being laid over each other merge key by key, however deep,
+// so a profile that sets one weight only changes that one.  Anything
+// else, a list included, replaces what is underneath: "disable",
+// "strategies" and "teammates" are given in full.  A null takes the
+// key out, leaving what the layers below it give.  A config can
+// extend one that extends another, but not one of its own.
+// ----------------------------------------------------------------
+
+// The JSON of a config with everything it extends merged in
+func ReadLayers (path string) ([]byte, error) {
+	layer, err := readLayer(path, nil)
+	if err != nil { return nil, err }
+	return json.Marshal(layer)
+}
+
+func readLayer (path string, extending []string) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil { return nil, err }
+	for _,p := range extending {
+		if p == abs { return nil, fmt.Errorf("%s: extends itself", path) }
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil { return nil, err }
+	var layer map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&layer); err != nil {
+		if e, ok := err.(*json.SyntaxError); ok {
+			line, column := LineColumn(data, e.Offset)
+			return nil, fmt.Errorf("%s: line %d, column %d: %v", path, line, column, e)
+		}
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	base, ok := layer["extends"]
+	if !ok { return layer, nil }
+	delete(layer, "extends")
+	name, ok := base.(string)
+	if !ok || len(name) == 0 { return nil, fmt.Errorf("%s: extends must name a config", path) }
+	if !filepath.IsAbs(name) { name = filepath.Join(filepath.Dir(path), name) }
+
+	under, err := readLayer(name, append(extending, abs))
+	if err != nil { return nil, err }
+	return MergeLayer(under, layer), nil
+}
+
+// Lay one config over another
+func MergeLayer (under, over map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(under))
+	for key,value := range under { merged[key] = value }
+	for key,value := range over {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		below, ok := merged[key].(map[string]interface{})
+		above, isObject := value.(map[string]interface{})
+		if ok && isObject {
+			merged[key] = MergeLayer(below, above)
+		} else {
+			merged[key] = stripNulls(value)
+		}
+	}
+	return merged
+}
+
+// Nulls in an object that has nothing underneath have nothing to take
+// out, so leave the key to the layers below
+func stripNulls (value interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok { return value }
+	return MergeLayer(map[string]interface{}{}, object)
+}
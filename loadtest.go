@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Load Tests
+//
+// Check a server can keep up before registering it for a big event,
+// by sending it many concurrent /move requests and measuring how
+// quickly it answers:
+//
+//   spacey-snake loadtest -url http://localhost:8080 -games 40 -duration 30s
+//
+// Positions come from the games recorded in -dir, from our point of
+// view, or from simulated random games if there are none.  Each of
+// the -games concurrent games sends its next move as soon as the
+// last was answered, as the engine would, under its own snake ID.
+// A move counts as timed out if it fails or takes longer than
+// -timeout.
+// ----------------------------------------------------------------
+
+// Positions to replay, from our recorded games
+func RecordedRequests (dir string) ([]MoveRequest, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil { return nil, err }
+
+	var requests []MoveRequest
+	for _,path := range paths {
+		r, err := ReadGameRecord(path)
+		if err != nil { return nil, err }
+		g := Game { ID: r.Game.ID, Ruleset: Ruleset { Name: r.Game.Ruleset["name"] }, Timeout: r.Game.SnakeTimeout }
+		for _,frame := range r.Frames {
+			b := FrameBoard(r, frame)
+			for _,snake := range b.Snakes {
+				if snake.ID == r.You { requests = append(requests, MoveRequest { g, frame.Turn, b, snake }) }
+			}
+		}
+	}
+	return requests, nil
+}
+
+// Positions from random games, for when nothing has been recorded
+func SimulatedRequests (n, size, nsnakes int, rng *rand.Rand) []MoveRequest {
+	var requests []MoveRequest
+	for game := 0; len(requests) < n; game++ {
+		var ids []string
+		for i := 0; i < nsnakes; i++ { ids = append(ids, fmt.Sprintf("loadtest-%d-%d", game, i)) }
+		g, b := NewRandomGame(fmt.Sprintf("loadtest-%d", game), "standard", size, size, ids, rng)
+		sim := NewSimulation(g, 0, b)
+		for !sim.Over() && len(requests) < n {
+			moves := make(map[string]string)
+			for _,snake := range sim.Board.Snakes {
+				moves[snake.ID] = RandomMove(sim.Board, snake, rng)
+			}
+			sim.Step(moves)
+			sim.SpawnFood(rng, 1, 0.15)
+			if you, ok := sim.Snake(ids[0]); ok {
+				requests = append(requests, MoveRequest { sim.Game, sim.Turn, CopyBoard(sim.Board), you })
+			}
+		}
+	}
+	return requests
+}
+
+// The latency at quantile q of sorted latencies
+func Percentile (sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 { return 0 }
+	i := int(q * float64(len(sorted)))
+	if i >= len(sorted) { i = len(sorted)-1 }
+	return sorted[i]
+}
+
+func LoadTestCommand (args []string) int {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:8080", "server to test")
+	dir := flags.String("dir", RecordDir(), "directory of recorded games to replay, RECORD_DIR by default")
+	games := flags.Int("games", 20, "concurrent games")
+	duration := flags.Duration("duration", 10 * time.Second, "how long to keep sending moves")
+	timeout := flags.Duration("timeout", 500 * time.Millisecond, "time a move may take")
+	size := flags.Int("size", 11, "board size for simulated games")
+	nsnakes := flags.Int("snakes", 4, "snakes in simulated games")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "random seed")
+	flags.Parse(args)
+
+	rng := rand.New(rand.NewSource(*seed))
+	var requests []MoveRequest
+	if len(*dir) > 0 {
+		var err error
+		if requests, err = RecordedRequests(*dir); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	if len(requests) == 0 { requests = SimulatedRequests(1000, *size, *nsnakes, rng) }
+	fmt.Printf("Replaying %d positions in %d concurrent games for %v\n", len(requests), *games, *duration)
+
+	client := &http.Client { Timeout: 2 * *timeout }
+	var mu sync.Mutex
+	var latencies []time.Duration
+	failures, late := 0, 0
+	stop := time.Now().Add(*duration)
+
+	var wg sync.WaitGroup
+	for game := 0; game < *games; game++ {
+		wg.Add(1)
+		go func(game int, offset int) {
+			defer wg.Done()
+			for i := offset; time.Now().Before(stop); i++ {
+				request := requests[i % len(requests)]
+				you := request.You.ID
+				request.You.ID = fmt.Sprintf("loadtest-%d", game)
+				request.Board.Snakes = append([]Snake(nil), request.Board.Snakes...)
+				for sx := range request.Board.Snakes {
+					if request.Board.Snakes[sx].ID == you { request.Board.Snakes[sx].ID = request.You.ID }
+				}
+				data, _ := json.Marshal(request)
+
+				start := time.Now()
+				resp, err := client.Post(*url + "/move", "application/json", bytes.NewReader(data))
+				elapsed := time.Since(start)
+				if err == nil {
+					resp.Body.Close()
+					if resp.StatusCode != http.StatusOK { err = fmt.Errorf("%s", resp.Status) }
+				}
+
+				mu.Lock()
+				latencies = append(latencies, elapsed)
+				if err != nil {
+					failures++
+				} else if elapsed > *timeout {
+					late++
+				}
+				mu.Unlock()
+			}
+		}(game, rng.Intn(len(requests)))
+	}
+	wg.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	n := len(latencies)
+	if n == 0 {
+		fmt.Fprintf(os.Stderr, "No moves were sent\n")
+		return 1
+	}
+	fmt.Printf("Moves: %d (%.1f/s)\n", n, float64(n) / duration.Seconds())
+	fmt.Printf("Latency: p50=%v p90=%v p99=%v max=%v\n", Percentile(latencies, 0.5), Percentile(latencies, 0.9),
+			   Percentile(latencies, 0.99), latencies[n-1])
+	fmt.Printf("Timeouts: %d (%.2f%%), late=%d failed=%d\n", late + failures, 100 * float64(late + failures) / float64(n),
+			   late, failures)
+	return 0
+}
@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"runtime"
+	"runtime/debug"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -22,21 +25,38 @@ type Coord struct {
 }
 
 type Snake struct {
-	ID     string  `json:"id"`
-	Name   string  `json:"name"`
-	Health int     `json:"health"`
-	Body   []Coord `json:"body"`
+	ID             string          `json:"id"`
+	Name           string          `json:"name"`
+	Health         int             `json:"health"`
+	Body           []Coord         `json:"body"`
+	Shout          string          `json:"shout,omitempty"`
+	Latency        string          `json:"latency,omitempty"`
+	Head           *Coord          `json:"head,omitempty"`
+	Length         int             `json:"length,omitempty"`
+	Squad          string          `json:"squad,omitempty"`
+	Customizations json.RawMessage `json:"customizations,omitempty"`
+}
+
+type Ruleset struct {
+	Name     string          `json:"name"`
+	Version  string          `json:"version"`
+	Settings json.RawMessage `json:"settings,omitempty"`
 }
 
 type Game struct {
-	ID string `json:"id"`
+	ID      string  `json:"id"`
+	Ruleset Ruleset `json:"ruleset"`
+	Timeout int     `json:"timeout,omitempty"`
+	Source  string  `json:"source,omitempty"`
+	Map     string  `json:"map,omitempty"`
 }
 
 type Board struct {
-	Height int     `json:"height"`
-	Width  int     `json:"width"`
-	Food   []Coord `json:"food"`
-	Snakes []Snake `json:"snakes"`
+	Height  int     `json:"height"`
+	Width   int     `json:"width"`
+	Food    []Coord `json:"food"`
+	Hazards []Coord `json:"hazards"`
+	Snakes  []Snake `json:"snakes"`
 }
 
 type StartRequest struct {
@@ -46,6 +66,21 @@ type StartRequest struct {
 	You   Snake `json:"you"`
 }
 
+// Our appearance, as v1 engines echo it back in every snake's
+// customizations
+type Customization struct {
+	Color	string	`json:"color"`
+	Head	string	`json:"head"`
+	Tail	string	`json:"tail"`
+}
+
+func (snake Snake) Customization () (Customization, bool) {
+	var c Customization
+	if len(snake.Customizations) == 0 { return c, false }
+	if err := json.Unmarshal(snake.Customizations, &c); err != nil { return c, false }
+	return c, len(c.Color) > 0 || len(c.Head) > 0 || len(c.Tail) > 0
+}
+
 type StartResponse struct {
 	Color    string `json:"color,omitempty"`
 	HeadType string `json:"headType,omitempty"`
@@ -94,14 +129,103 @@ func Translate (a Coord, dx, dy int) Coord {
 	return Coord{ a.X+dx, a.Y+dy }
 }
 
+// Move one cell in a direction
+func Step (c Coord, dir string) Coord {
+	switch dir {
+	case "left":	c.X--
+	case "right":	c.X++
+	case "up":		c.Y--
+	default:		c.Y++
+	}
+	return c
+}
+
+// Name the direction of a unit step
+func Direction (from, to Coord) string {
+	switch {
+	case to.X < from.X: return "left"
+	case to.X > from.X: return "right"
+	case to.Y < from.Y: return "up"
+	default: return "down"
+	}
+}
+
 // ----------------------------------------------------------------
 // Game Context
 // ----------------------------------------------------------------
 
 type ContextType struct {
 	color string
-	heads map[string]Coord
+	heads []SnakeHead			// where each snake's head was last turn, by ID
 	food []Coord
+	spaces map[string][]int		// reachable space of each snake, most recent last
+	spaceTurn int				// turn the spaces were last recorded
+	latencies map[string][]int	// response time of each snake in ms, most recent last
+	latencyTurn int				// turn the latencies were last recorded
+	weights *Weights			// weights to use in place of the ruleset profile
+	tenant *Tenant				// whose game this is, if not ours
+	experiments map[string]bool	// whether the game is in each experiment that may run in it
+	record *GameRecord			// frames of the game so far
+	metrics []TurnMetrics		// our metrics for each turn so far, if we are recording
+	lastMove string				// the move we made last turn
+	shouted map[string]int		// turn each phrase was last shouted on
+	tier string					// how hard we thought last turn
+	tierTimes map[string]time.Duration	// average time each tier has taken
+	quiet bool					// don't log, as for warm-up games
+	voronoi map[uint64][][]int	// Voronoi maps of recent positions, by PositionHash
+	source string				// where the game comes from: tournament, league, custom, ...
+}
+
+// Context that decisions read is kept in slices in a fixed order,
+// never iterated out of a map, so that the same inputs always give
+// the same move and a recorded decision can be reproduced
+type SnakeHead struct {
+	ID		string
+	Head	Coord
+}
+
+// Where a snake's head was last turn
+func LastHead (heads []SnakeHead, id string) (Coord, bool) {
+	i := sort.Search(len(heads), func(i int) bool { return heads[i].ID >= id })
+	if i < len(heads) && heads[i].ID == id { return heads[i].Head, true }
+	return Coord{}, false
+}
+
+// How many turns of space history we keep for each snake
+const spaceHistory = 8
+
+// Turns in a row a snake's space has to shrink for us to press harder
+const spaceTrendTurns = 3
+
+// Record the reachable space of each snake for this turn
+func RecordSpaces (id string, turn int, snakes []SnakeState) {
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok { return }
+	if context.spaces == nil { context.spaces = make(map[string][]int) }
+	if len(context.spaces) > 0 && context.spaceTurn == turn { return }
+	context.spaceTurn = turn
+	for _,snake := range snakes {
+		history := append(context.spaces[snake.ID], snake.space)
+		if len(history) > spaceHistory { history = history[len(history)-spaceHistory:] }
+		context.spaces[snake.ID] = history
+	}
+}
+
+// Has the reachable space of a snake shrunk for n turns in a row?
+func SpaceShrinking (id string, snakeID string, n int) bool {
+	gameContext.RLock()
+	defer gameContext.RUnlock()
+	context, ok := gameContext.m[id]
+	if !ok { return false }
+	history := context.spaces[snakeID]
+
+	if len(history) < n+1 { return false }
+	for i := len(history)-n; i < len(history); i++ {
+		if history[i] >= history[i-1] { return false }
+	}
+	return true
 }
 
 var gameContext struct {
@@ -109,6 +233,23 @@ var gameContext struct {
 	m map[string]*ContextType
 }
 
+// Forget every game, to start playing afresh
+func ResetGameContexts () {
+	gameContext.Lock()
+	gameContext.m = make(map[string]*ContextType)
+	gameContext.Unlock()
+}
+
+// Look up the context of a game, or an empty one for positions 
+// outside of any game we are playing, e.g. when analyzing
+func GetContext (id string) *ContextType {
+	gameContext.RLock()
+	context, ok := gameContext.m[id]
+	gameContext.RUnlock()
+	if !ok { return &ContextType { color: "none" } }
+	return context
+}
+
 // ----------------------------------------------------------------
 // Logging
 // ----------------------------------------------------------------
@@ -116,18 +257,23 @@ var gameContext struct {
 type Log struct {
 	color string
 	level string
+	quiet bool
 }
 
 func NewLogger (ID string, level string) Log {
 	var l Log
-	gameContext.RLock()
-	l.color = gameContext.m[ID].color
-	gameContext.RUnlock()
+	context := GetContext(ID)
+	l.color = context.color
+	l.quiet = context.quiet
 	l.level = level
 	return l
 }
 
+// Logging can be turned off by local tools
+var engineLogging = true
+
 func (l Log) Printf (s string, msgs ...interface{}) {
+	if !engineLogging || l.quiet { return }
 	fmt.Printf("%s(%s):",l.level,l.color)
 	fmt.Printf(s,msgs...)
 }
@@ -138,7 +284,7 @@ func (l Log) Printf (s string, msgs ...interface{}) {
 
 type GameCell struct {
 	content		uint16
-	space		uint16
+	hazard		bool
 }
 
 func (c GameCell) IsEmpty() bool {
@@ -170,7 +316,7 @@ func (c GameCell) SnakeNo() int {
 }
 
 func FoodCell() GameCell { 
-	return GameCell { 1, 0 }
+	return GameCell { 1, false }
 }
 
 func BodyCell(s int) GameCell {
@@ -206,6 +352,12 @@ type SnakeState struct {
 	segments []Coord
 	dist	 int
 	growing  bool
+	space	 int
+	shrinking bool	// its space has shrunk for spaceTrendTurns turns in a row
+	health	 int
+	slow	 bool
+	teammate bool	// one of ours, while we are keeping the truce
+	foodShare float64	// how often it eats food beside its head
 }
 
 // ----------------------------------------------------------------
@@ -225,6 +377,16 @@ type SpaceState struct {
 	nfood	int
 }
 
+// The space we need to keep moving in a region.  Filling it, we pass
+// over the food in it and eat it, and each food eaten grows us by one
+// by the time we have worked our way round to our tail.  If we have
+// been warned of a trap, we want twice our length besides.
+func (s *GameState) RequiredSpace (space int) int {
+	required := s.snakes[0].length + s.spaces[space].nfood
+	if s.cautious { required += s.snakes[0].length }
+	return required
+}
+
 // ----------------------------------------------------------------
 // FoodState
 //
@@ -236,6 +398,8 @@ type FoodState struct {
 	pos				Coord
 	dist			int
 	closerSnakes	int
+	claimed			bool	// a starving snake must take this food and will get there first
+	hazardRun		int		// moves from the food to the nearest cell outside the hazards
 }
 
 // ----------------------------------------------------------------
@@ -254,7 +418,24 @@ type GameState struct {
 	grid 	[][]GameCell
 	snakes	[]SnakeState
 	food	[]FoodState
-	spaces	[4]SpaceState
+	spaces	[5]SpaceState	// the space after each move, from 1
+	ruleset	string
+	weights	Weights
+	refuge	Coord
+	shrinking bool
+	stack	[]Coord		// flood fill stack, reused across fills
+	visited	Bitset		// cells visited by the current flood fill
+	bounds	[]bool		// backing for the snakes bounding each space
+	cautious bool		// an opponent's shout has us checking our escape routes
+	converting bool		// the game is won, so take no risks
+	reply	string		// what to shout back, if anything
+	standOffs []StandOff	// cells we and another snake could both move to
+	external map[string]float64	// each move's score from an external evaluator
+	occupancy []uint8	// segments stacked on each cell, by Index
+	vacate	[]int		// moves until each cell is free of the body on it, by Index
+	cancel	*Cancel		// cuts expensive loops short when time runs out
+	phases	PhaseTimings	// time spent in each phase so far
+	lap		time.Time		// when the current phase started
 }
 
 func (s *GameState) IsEmpty(c Coord) bool {
@@ -265,6 +446,21 @@ func (s *GameState) IsFood(c Coord) bool {
 	return s.grid[c.X][c.Y].IsFood()
 }
 
+// How far c is from the food we are heading for: the first worth
+// having that we are closer to than anyone, or else the first we are
+// at least getting closer to.  h + w if there is none.
+func (s *GameState) FoodDistance(c Coord) int {
+	for _,food := range s.food {
+		mdist := ManDist(c,food.pos)
+		if mdist < food.dist && food.closerSnakes == 0 && !food.claimed && s.FoodWorthIt(food) { return mdist }
+	}
+	for _,food := range s.food {
+		mdist := ManDist(c,food.pos)
+		if mdist < food.dist && !food.claimed && s.FoodWorthIt(food) { return mdist }
+	}
+	return s.h + s.w
+}
+
 func (s *GameState) IsBody(c Coord) bool {
 	return s.grid[c.X][c.Y].IsBody()
 }
@@ -281,13 +477,42 @@ func (s *GameState) IsSelf(c Coord) bool {
 	return s.grid[c.X][c.Y].IsSelf()
 }
 
+func (s *GameState) IsHazard(c Coord) bool {
+	return s.grid[c.X][c.Y].hazard
+}
+
 func (s *GameState) SnakeNo(c Coord) int {
 	return s.grid[c.X][c.Y].SnakeNo()
 }
 
 // ----------------------------------------------------------------
 // Generic traversal of neighboring cells
+//
+// VisitNeighbours is the convenient form.  In the innermost loops,
+// Neighbours fills a small array from a table of offsets instead, 
+// so there is no closure to call for every cell.  Both give the 
+// neighbours in the same order.
 // ----------------------------------------------------------------
+
+var neighbourOffsets = [4]struct {
+	dx, dy	int
+	dir		string
+} {
+	{ -1, 0, "left" }, { 1, 0, "right" }, { 0, -1, "up" }, { 0, 1, "down" },
+}
+
+// The neighbours of c that are on the board, using out for storage
+func (s *GameState) Neighbours (c Coord, out *[4]Coord) []Coord {
+	n := 0
+	for _,o := range neighbourOffsets {
+		p := Coord{ c.X + o.dx, c.Y + o.dy }
+		if p.X < 0 || p.X >= s.w || p.Y < 0 || p.Y >= s.h { continue }
+		out[n] = p
+		n++
+	}
+	return out[:n]
+}
+
 func (s *GameState) VisitNeighbours (c Coord, visitor func(Coord,string)) {
 	left := c; left.X--
 	if left.X >= 0 { visitor(left,"left") }
@@ -305,45 +530,403 @@ func (s *GameState) VisitNeighbours (c Coord, visitor func(Coord,string)) {
 // ----------------------------------------------------------------
 // Space Mapping
 //
-// This is a flood fill algorithm which is used to map out a
-// space adjacent to the head of our snake.  A space is any set 
-// of cells bounded by the bodies or heads of snakes, either
-// our own or others.
+// This is a flood fill algorithm which is used to map out the space
+// we would have after moving to a cell adjacent to the head of our
+// snake.  A space is any set of cells bounded by the bodies or heads
+// of snakes, either our own or others.
+//
+// The move is played out as the fill goes: it spreads a turn at a
+// time from the cell we move to, and a cell of our own body counts
+// as space once our tail will have left it by the time we could get
+// there, a turn later if the move eats.  Other snakes' tails are
+// only counted if they leave on the next move, as their owners may
+// eat.
 // ----------------------------------------------------------------
 func (s *GameState) MapSpace (c Coord, space int) int {
-	stack := make([]Coord, s.h * s.w * 4)
-	top := 0
-	stack[top] = c
+	if len(s.visited) == 0 { s.visited = NewBitset(s.h * s.w) }
+	s.visited.Clear()
+	s.visited.Set(s.Index(c))
 
-	s.spaces[space].snakes = make([]bool, len(s.snakes)+1)
+	// Cells are marked as they are queued, so each is queued at most
+	// once and the queue never needs to be larger than the board
+	if cap(s.stack) < s.h * s.w { s.stack = make([]Coord, 0, s.h * s.w) }
+	queue := s.stack[:0]
+
+	if len(s.bounds) < len(s.spaces) * (len(s.snakes)+1) {
+		s.bounds = make([]bool, len(s.spaces) * (len(s.snakes)+1))
+	}
+	s.spaces[space].snakes = s.bounds[space*(len(s.snakes)+1):(space+1)*(len(s.snakes)+1)]
+
+	// Moving onto food, we grow and our tail stays put a turn longer
+	grow := 0
+	if s.IsFood(c) { grow = 1 }
+
+	count := 1
+	if s.IsFood(c) { s.spaces[space].nfood++ }
+	queue = append(queue, c)
+
+	var buf [4]Coord
+	for next, turn := 0, 2; next < len(queue) && !s.cancel.Expired(); turn++ {
+		// The cells reached on this turn are those queued from the last
+		for end := len(queue); next < end; next++ {
+			for _,neighbour := range s.Neighbours(queue[next], &buf) {
+				i := s.Index(neighbour)
+				if s.visited.Has(i) { continue }
+				cell := s.grid[neighbour.X][neighbour.Y]
+				free := cell.IsEmpty() || cell.IsFood() || (cell.IsTail() && s.VacatedNext(neighbour))
+				if cell.IsSelf() { free = s.VacateTime(neighbour) + grow <= turn }
+				if free {
+					s.visited.Set(i)
+					count++
+					if cell.IsFood() { s.spaces[space].nfood++ }
+					queue = append(queue, neighbour)
+				} else if cell.IsBody() || cell.IsHead() {
+					s.spaces[space].snakes[cell.SnakeNo()] = true
+				}
+			}
+		}
+	}
+
+	s.stack = queue
+	return count
+}
+
+// ----------------------------------------------------------------
+// Snake Space Mapping
+//
+// A separate flood fill from the head of every snake, ours included,
+// counting the cells each snake can reach.  Unlike MapSpace this
+// does not label the grid, so spaces of different snakes may overlap.
+//
+// Each snake's space is kept for the last few turns.  A snake whose
+// space has shrunk turn after turn is being hemmed in, and each rule
+// that hunts other snakes can press it while the trap is closing.
+// ----------------------------------------------------------------
+func (s *GameState) ReachableSpace (c Coord) int {
+	if len(s.visited) == 0 { s.visited = NewBitset(s.h * s.w) }
+	s.visited.Clear()
+	s.visited.Set(s.Index(c))
+
+	if cap(s.stack) < s.h * s.w { s.stack = make([]Coord, 0, s.h * s.w) }
+	stack := append(s.stack[:0], c)
 
 	count := 0
-	for top >= 0 {
-		p := stack[top]
-		top--
-
-		pcell := s.grid[p.X][p.Y]
-		if (pcell.space != 0) { continue }
-
-		count++
-		s.grid[p.X][p.Y].space = uint16(space)
-		if pcell.IsFood() { s.spaces[space].nfood++ }
-
-		s.VisitNeighbours (p, func (neighbour Coord, dir string) {
-			if s.IsEmpty(neighbour) || s.IsFood(neighbour) || 
-			   (s.IsTail(neighbour) && !s.snakes[s.SnakeNo(neighbour)].growing) {
-				top++
-				stack[top] = neighbour
-			} else if s.IsBody(neighbour) || s.IsHead(neighbour) {
-				s.spaces[space].snakes[s.SnakeNo(neighbour)] = true
+	var buf [4]Coord
+	for len(stack) > 0 && !s.cancel.Expired() {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		for _,neighbour := range s.Neighbours(p, &buf) {
+			i := s.Index(neighbour)
+			if s.visited.Has(i) { continue }
+			cell := s.grid[neighbour.X][neighbour.Y]
+			if cell.IsEmpty() || cell.IsFood() || 
+			   (cell.IsTail() && s.VacatedNext(neighbour)) {
+				s.visited.Set(i)
+				count++
+				stack = append(stack,neighbour)
+			}
+		}
+	}
+
+	s.stack = stack
+	return count
+}
+
+func (s *GameState) MapSnakeSpaces () {
+	for sx := range s.snakes {
+		s.snakes[sx].space = s.ReachableSpace(s.snakes[sx].head)
+	}
+
+	// Our snake is at index 0 and owns the context
+	me := s.snakes[0].ID
+	RecordSpaces(me, s.turn, s.snakes)
+
+	for sx := range s.snakes {
+		snake := &s.snakes[sx]
+		snake.shrinking = SpaceShrinking(me, snake.ID, spaceTrendTurns)
+		trend := ""
+		if snake.shrinking { trend = " shrinking" }
+		s.debug.Printf("Snake at: [H](%d,%d) has space=%d%s\n",
+					   snake.head.X,snake.head.Y,snake.space,trend)
+	}
+}
+
+// Is a shorter snake whose space keeps shrinking next to c?  One that
+// is being hemmed in is worth going after head on before the midgame.
+func (s *GameState) CorneredNear (c Coord) bool {
+	for _,snake := range s.snakes[1:] {
+		if snake.shrinking && snake.length < s.snakes[0].length && ManDist(c, snake.head) == 1 { return true }
+	}
+	return false
+}
+
+// ----------------------------------------------------------------
+// Edge Trap
+//
+// Look for an opponent travelling along a wall while our head is
+// alongside it in the lane one cell in from the wall.  If we keep
+// moving in the same direction as the opponent, it can neither turn
+// away from the wall nor into us, and will be pinned until it runs
+// into the corner.  Returns the direction to move to keep the pin,
+// or "" if there is no such opportunity.
+// ----------------------------------------------------------------
+func (s *GameState) EdgeTrap (lastHeads []SnakeHead) string {
+	myHead := s.snakes[0].head
+	for _,snake := range s.snakes[1:] {
+		prev, ok := LastHead(lastHeads, snake.ID)
+		if !ok || ManDist(prev,snake.head) != 1 || snake.teammate { continue }
+
+		head := snake.head
+		alongside := false
+		switch {
+		case head.X == 0 && prev.X == 0:
+			alongside = myHead == Coord{ 1, head.Y }
+		case head.X == s.w-1 && prev.X == s.w-1:
+			alongside = myHead == Coord{ s.w-2, head.Y }
+		case head.Y == 0 && prev.Y == 0:
+			alongside = myHead == Coord{ head.X, 1 }
+		case head.Y == s.h-1 && prev.Y == s.h-1:
+			alongside = myHead == Coord{ head.X, s.h-2 }
+		}
+
+		if alongside {
+			s.debug.Printf("Snake at: [H](%d,%d) is travelling along the wall beside us\n",
+						   head.X,head.Y)
+			return Direction(prev,head)
+		}
+	}
+	return ""
+}
+
+// ----------------------------------------------------------------
+// Corridors
+//
+// A corridor is a run of cells one wide, i.e. each cell has just
+// one free neighbour other than the one we entered from.  We follow
+// the corridor from a cell adjacent to our head to find its length
+// and whether it is a dead end.  In a dead end we can only escape if
+// part of our own body next to the far end will have moved away by
+// the time we get there.  Otherwise, the corridor is a trap if an
+// opponent can reach the far end before us and plug it.
+// ----------------------------------------------------------------
+
+type CorridorState struct {
+	length	int			// number of cells in the corridor, 0 if not a corridor
+	exit	Coord		// last cell of the corridor
+	deadEnd	bool		// is there no way out at the far end?
+	escape	bool		// will our tail vacate a cell next to a dead end in time?
+	plugged	bool		// can an opponent block the exit before we get there?
+}
+
+// The longest snake other than us, or -1 if that is a tie between
+// other snakes
+func (s *GameState) Leader () int {
+	leader := -1
+	longest := 0
+	for sx := 1; sx < len(s.snakes); sx++ {
+		switch {
+		case s.snakes[sx].length > longest:
+			leader = sx
+			longest = s.snakes[sx].length
+		case s.snakes[sx].length == longest:
+			leader = -1
+		}
+	}
+	return leader
+}
+
+// Segments on a cell
+func (s *GameState) Occupancy (c Coord) int {
+	i := s.Index(c)
+	if i < 0 { return 0 }
+	return int(s.occupancy[i])
+}
+
+// Moves until a cell is no longer part of the body on it, 0 if there
+// is none
+func (s *GameState) VacateTime (c Coord) int {
+	i := s.Index(c)
+	if i < 0 { return 0 }
+	return s.vacate[i]
+}
+
+// Whether the body on a cell will have moved off it after our next
+// move.  The grid marks a cell with the tail even when body segments
+// are stacked under it, so only the vacate time can say whether a
+// tail is safe to enter.
+func (s *GameState) VacatedNext (c Coord) bool {
+	return s.VacateTime(c) <= 1
+}
+
+func (s *GameState) IsFree (c Coord) bool {
+	return s.IsEmpty(c) || s.IsFood(c) || 
+		   (s.IsTail(c) && s.VacatedNext(c))
+}
+
+func (s *GameState) TraceCorridor (from, c Coord) CorridorState {
+	var corridor CorridorState
+
+	prev := from
+	cur := c
+	length := 1
+	var buf, free [4]Coord
+	var onward []Coord
+	for {
+		onward = free[:0]
+		for _,neighbour := range s.Neighbours(cur, &buf) {
+			if neighbour != prev && s.IsFree(neighbour) {
+				onward = append(onward,neighbour)
 			}
-		})
+		}
+		if len(onward) != 1 || length > s.h * s.w { break }
+		prev, cur = cur, onward[0]
+		length++
+	}
+
+	// The first cell must itself be one wide
+	if length == 1 && len(onward) > 1 { return corridor }
 
+	corridor.length = length
+	corridor.exit = cur
+	corridor.deadEnd = len(onward) == 0
+
+	if corridor.deadEnd {
+		// Will a segment of our body next to the dead end move away in time?
+		for _,segment := range s.snakes[0].segments {
+			if ManDist(segment,cur) != 1 { continue }
+			if s.VacateTime(segment) <= length { corridor.escape = true }
+		}
+	} else if length > 1 {
+		for _,snake := range s.snakes[1:] {
+			if ManDist(snake.head,cur) < length { corridor.plugged = true }
+		}
 	}
 
+	return corridor
+}
+
+// ----------------------------------------------------------------
+// Compactness
+//
+// Count the segments of our own body, other than the head we are
+// leaving, next to a cell.  Moving alongside our own body keeps us
+// coiled, which leaves larger contiguous regions of the board free
+// and keeps our tail close by.
+// ----------------------------------------------------------------
+
+func (s *GameState) Compactness (c Coord, myHead Coord) int {
+	count := 0
+	var buf [4]Coord
+	for _,neighbour := range s.Neighbours(c, &buf) {
+		if neighbour != myHead && s.IsSelf(neighbour) { count++ }
+	}
 	return count
 }
 
+// ----------------------------------------------------------------
+// Center Control
+//
+// A mild preference for cells near the middle of the board, which
+// fades out as the game goes on.  Holding the center keeps our 
+// options open in the opening and midgame, while edges and corners
+// matter less once the board fills up.
+// ----------------------------------------------------------------
+
+func (s *GameState) CenterPenalty (c Coord) float64 {
+	if s.weights.CenterFadeTurn <= 0 || !s.weights.Enabled("center") { return 0 }
+	fade := 1.0 - float64(s.turn)/float64(s.weights.CenterFadeTurn)
+	if fade <= 0 { return 0 }
+
+	cx := float64(s.w-1) / 2
+	cy := float64(s.h-1) / 2
+	dist := math.Abs(float64(c.X)-cx) + math.Abs(float64(c.Y)-cy)
+	return s.weights.CenterWeight * fade * dist
+}
+
+// ----------------------------------------------------------------
+// Refuge
+//
+// In Royale, hazards creep in from the edges of the board until 
+// only a small safe region is left.  We can't know which sides will
+// shrink next, but the final region lies inside the cells that are
+// still safe, so we treat the middle of the safe area as our refuge
+// and drift toward it long before the hazards force us there.  The
+// pull is stronger if an opponent is already closer to it than us.
+// ----------------------------------------------------------------
+
+func (s *GameState) FindRefuge () {
+	s.shrinking = s.ruleset == "royale"
+	if !s.shrinking { return }
+
+	minX, maxX, minY, maxY := s.w, -1, s.h, -1
+	for x := 0; x < s.w; x++ {
+		for y := 0; y < s.h; y++ {
+			if s.grid[x][y].hazard { continue }
+			if x < minX { minX = x }
+			if x > maxX { maxX = x }
+			if y < minY { minY = y }
+			if y > maxY { maxY = y }
+		}
+	}
+	if maxX < 0 {
+		// Nowhere is safe any more
+		s.shrinking = false
+		return
+	}
+
+	s.refuge = Coord{ (minX+maxX)/2, (minY+maxY)/2 }
+	for _,snake := range s.snakes {
+		s.debug.Printf("Snake at: [H](%d,%d) is %d from the refuge at (%d,%d)\n",
+					   snake.head.X,snake.head.Y,ManDist(snake.head,s.refuge),
+					   s.refuge.X,s.refuge.Y)
+	}
+}
+
+func (s *GameState) RefugePenalty (c Coord) float64 {
+	if !s.shrinking || !s.weights.Enabled("refuge") { return 0 }
+
+	weight := s.weights.RefugeWeight
+	myDist := ManDist(s.snakes[0].head,s.refuge)
+	for _,snake := range s.snakes[1:] {
+		if ManDist(snake.head,s.refuge) < myDist {
+			weight *= 2
+			break
+		}
+	}
+	return weight * float64(ManDist(c,s.refuge))
+}
+
+// ----------------------------------------------------------------
+// Starvation
+//
+// Our own space is not the only space that matters.  A move that
+// cuts an opponent off from part of the board is worth making even
+// when it costs us nothing, since a snake with less room has fewer
+// ways out and dies sooner.  We take the cell a move puts our head
+// on out of the board and flood fill again from every other head,
+// rewarding the cells they lose.  A snake whose space has been
+// shrinking for a few turns is already being hemmed in, so the cells
+// it loses count double, to close the trap while it is closing.
+// ----------------------------------------------------------------
+
+func (s *GameState) StarvationBonus (c Coord) float64 {
+	if s.weights.StarveWeight == 0 || !s.weights.Enabled("starve") { return 0 }
+
+	cell := &s.grid[c.X][c.Y]
+	saved := cell.content
+	cell.content = BodyCell(0).content
+	lost := 0
+	for _,snake := range s.snakes[1:] {
+		if snake.teammate { continue }
+		cells := snake.space - s.ReachableSpace(snake.head)
+		if snake.shrinking { cells *= 2 }
+		lost += cells
+	}
+	cell.content = saved
+	return s.weights.StarveWeight * float64(lost)
+}
+
 // ----------------------------------------------------------------
 // Initialize GameState
 //
@@ -353,6 +936,10 @@ func (s *GameState) MapSpace (c Coord, space int) int {
 func (s *GameState) Initialize (g Game, t int, b Board, y Snake) {
 	s.ID = g.ID
 	s.turn = t
+	s.ruleset = g.Ruleset.Name
+	context := GetContext(y.ID)
+	tenant := context.Tenant()
+	s.weights = tenant.Config.WeightsFor(s.ruleset)
 
 	s.h = b.Height
 	s.w = b.Width
@@ -364,34 +951,67 @@ func (s *GameState) Initialize (g Game, t int, b Board, y Snake) {
 
 	myHead := y.Body[0]
 
-	foodLastTurn := make(map[Coord]bool)
-	gameContext.RLock()
-	context := gameContext.m[y.ID]
-	gameContext.RUnlock()
+	foodLastTurn := NewBitset(s.w*s.h)
+	if context.weights != nil { s.weights = *context.weights }
+	s.cautious = s.weights.Cautious
+	s.HearShouts(b, y.ID)
 	for _,food := range context.food {
-		foodLastTurn[food] = true
+		if i := s.Index(food); i >= 0 { foodLastTurn.Set(i) }
 	}
 
+	// Cells seen while deduping, cleared again after each snake
+	seen := NewBitset(s.w*s.h)
+
+	// Segments can be stacked, the whole body on one cell at the start
+	// and the tail twice over just after eating.  The grid has one
+	// entry per cell, while the occupancy and vacate times say how many
+	// segments are on it and how long until they have all moved off.
+	// Segment i of a snake of length n moves off after n-i moves.
+	s.occupancy = make([]uint8, s.w*s.h)
+	s.vacate = make([]int, s.w*s.h)
+
 	s.snakes = make ([]SnakeState, 0, len(b.Snakes))
 
 	for _,snake := range b.Snakes {
 		var this SnakeState
 		this.ID = snake.ID
+		this.length = len(snake.Body)
 
 		this.segments = make([]Coord,0,len(snake.Body))
-		smap := make(map[Coord]bool)
-		for _,segment := range snake.Body {
-			if _,ok := smap[segment]; ok { continue }
-			smap[segment] = true
+		// Segments off the board can only come from a bad request
+		for sx,segment := range snake.Body {
+			i := s.Index(segment)
+			if i < 0 { continue }
+			if s.occupancy[i] < 255 { s.occupancy[i]++ }
+			if vacate := this.length - sx; vacate > s.vacate[i] { s.vacate[i] = vacate }
+			if seen.Has(i) { continue }
+			seen.Set(i)
 			this.segments = append(this.segments,segment)
 		}
-		this.length = len(this.segments)
+		for _,segment := range this.segments { seen.Unset(s.Index(segment)) }
+		if len(this.segments) == 0 { continue }
 
 		this.head = this.segments[0]
 		this.dist = ManDist(this.head,myHead)
-		this.growing = (t < 2 || foodLastTurn[this.head])
+		this.tail = this.segments[len(this.segments)-1]
+
+		// A stacked tail stays put next move.  Otherwise, if the head is
+		// where food was last turn, the engine may not have grown the
+		// body yet, so every segment stays a move longer.
+		i := s.Index(this.head)
+		this.growing = s.Occupancy(this.tail) > 1
+		if !this.growing && i >= 0 && foodLastTurn.Has(i) {
+			this.growing = true
+			for _,segment := range this.segments {
+				if i := s.Index(segment); i >= 0 { s.vacate[i]++ }
+			}
+		}
 
-		this.tail = this.segments[this.length-1]
+		this.health = snake.Health
+		this.teammate = snake.ID != y.ID && s.weights.Truce && tenant.Config.Teammate(snake.Name)
+		this.slow = SlowSnake(y.ID, snake.ID, g.Timeout) || (snake.ID != y.ID && KnownSlow(tenant.Opponents, snake.Name))
+		if this.slow { s.debug.Printf("Snake %s is slow to respond\n", snake.Name) }
+		this.foodShare = FoodShare(tenant.Opponents, snake.Name)
 
 		s.snakes = append(s.snakes,this)
 	}
@@ -421,10 +1041,10 @@ func (s *GameState) Initialize (g Game, t int, b Board, y Snake) {
 
 	s.food = make ([]FoodState, 0, len(b.Food))
 
-	fmap := make(map[Coord]bool)
 	for _,food := range b.Food {
-		if _,ok := fmap[food]; ok { continue }
-		fmap[food] = true
+		i := s.Index(food)
+		if i < 0 || seen.Has(i) { continue }
+		seen.Set(i)
 
 		s.grid[food.X][food.Y] = FoodCell()
 
@@ -451,7 +1071,94 @@ func (s *GameState) Initialize (g Game, t int, b Board, y Snake) {
 		s.debug.Printf("Food at: (%d,%d), dist=%d\n", food.pos.X,food.pos.Y,food.dist)
 	}
 
+	for _,hazard := range b.Hazards {
+		if s.Index(hazard) < 0 { continue }
+		s.grid[hazard.X][hazard.Y].hazard = true
+	}
+	if len(b.Hazards) > 0 {
+		for i := range s.food { s.food[i].hazardRun = s.HazardRun(s.food[i].pos) }
+	}
+
+	if s.weights.Enabled("triage") { s.TriageFood() }
+	s.LeaveFoodToTeammates()
+	s.FindRefuge()
+}
+
+// ----------------------------------------------------------------
+// Food Triage
+//
+// When several snakes are starving, each starving opponent has to
+// eat the food nearest to it or die.  We leave those foods to them
+// when they will get there no later than us, and route ourselves
+// to food they can't contest.
+// ----------------------------------------------------------------
+
+func (s *GameState) TriageFood () {
+	nstarving := 0
+	for _,snake := range s.snakes {
+		if snake.health <= s.weights.StarvingHealth { nstarving++ }
+	}
+	if nstarving < 2 || len(s.food) == 0 { return }
+
+	for _,snake := range s.snakes[1:] {
+		if snake.health > s.weights.StarvingHealth { continue }
+
+		nearest := 0
+		for fx,food := range s.food {
+			if ManDist(snake.head,food.pos) < ManDist(snake.head,s.food[nearest].pos) {
+				nearest = fx
+			}
+		}
+
+		food := &s.food[nearest]
+		dist := ManDist(snake.head,food.pos)
+		if dist <= snake.health && dist <= food.dist {
+			food.claimed = true
+			s.debug.Printf("Food at: (%d,%d) is claimed by starving snake at [H](%d,%d)\n",
+						   food.pos.X,food.pos.Y,snake.head.X,snake.head.Y)
+		}
+	}
+}
+
+// ----------------------------------------------------------------
+// MoveType
+//
+// What we know about each of the moves open to us, and the move
+// we decided on.
+// ----------------------------------------------------------------
+
+type MoveType struct {
+	dir 			string
+	c 				Coord
+	nlonger 		int			// how many larger snakes threaten?
+	nequal			int			// how many of those are as long as us?
+	alternate   	int			// how many alternatives do larger snakes have?
+	nshorter		int			// how many shorter snakes are vulnerable?
+	threat			float64		// chance of a head to head we are risking
+	contested		float64		// share of our next moves a longer snake could meet us on
+	forcing			int			// how many snakes are left only losing replies?
+	space 			int			// what space is this move connected to?
+	smallSpace		bool		// is the space too small for us to safely enter?
+	discarded		bool		// has this move been discarded already?
+	squeezed		bool		// will this move squeeze us against a wall?
+	corridor		CorridorState	// is this move into a one-wide corridor?
+	closerToLonger	int			// Number of longer snakes that will be closer if we
+								// pick this move
+	closerToShorter	int			// Number of shorter snakes that will be closer if we
+							    // pick this move
+	value			float64		// score in the final pass over the moves, if reached
+}
 
+type MoveDecision struct {
+	Move	string
+	Reason	Reason			// which rule picked the move
+	Moves	[]MoveType
+	Elapsed	time.Duration
+	Phases	PhaseTimings
+	state	*GameState
+	Tier	string			// how hard we thought about it
+	Confidence	float64		// how sure we are of the move, from 0 to 1
+	CutShort	bool		// the deadline stopped our thinking
 }
 
 // ----------------------------------------------------------------
@@ -461,69 +1168,73 @@ func (s *GameState) Initialize (g Game, t int, b Board, y Snake) {
 // ----------------------------------------------------------------
 
 func FindMove (g Game, t int, b Board, y Snake) string {
+	return Think(g,t,b,y).Move
+}
+
+func Decide (g Game, t int, b Board, y Snake) MoveDecision {
+	return DecideWithin(g, t, b, y, nil)
+}
+
+// Decide, cutting the work short if cancel expires
+func DecideWithin (g Game, t int, b Board, y Snake, cancel *Cancel) MoveDecision {
 	start := time.Now()
+	var moves []MoveType
 
 	var s GameState
+	s.cancel = cancel
+	s.lap = start
 	s.debug = NewLogger(y.ID, "DEBUG")
 	s.info = NewLogger(y.ID, "INFO")
 
 	s.info.Printf("-------------------------------------------------------\n")
 	s.info.Printf("Move turn=%d\n", t)
 
-	Result := func(dir string) string {
+	Result := func(dir string, reason Reason) MoveDecision {
+		s.Lap(&s.phases.Search)
 		elapsed := time.Since(start)
-		s.info.Printf("Move result=%s, elapsed=%dms\n", dir, elapsed.Milliseconds())
-		return dir
+		s.info.Printf("Move result=%s reason=%s, %v\n", dir, reason, s.phases)
+		return MoveDecision { Move: dir, Reason: reason, Moves: moves, Elapsed: elapsed, Phases: s.phases, state: &s }
 	}
 
-	Left  := func() string { return Result("left")  }
-	Right := func() string { return Result("right") }
-	Up    := func() string { return Result("up")    }
-	Down  := func() string { return Result("down")  }
+	Left  := func(reason Reason) MoveDecision { return Result("left",reason)  }
+	Right := func(reason Reason) MoveDecision { return Result("right",reason) }
+	Up    := func(reason Reason) MoveDecision { return Result("up",reason)    }
+	Down  := func(reason Reason) MoveDecision { return Result("down",reason)  }
 
 	s.Initialize(g,t,b,y)
+	s.Lap(&s.phases.Initialize)
+
+	s.MapSnakeSpaces()
+	if s.CertainWin() { s.Convert() }
 
 	myHead := s.snakes[0].head
 	myTail := s.snakes[0].tail
 	myLength := s.snakes[0].length
+
+	lastHeads := GetContext(y.ID).heads
 	//myHealth := y.Health
 
 	//s.debug.Printf("My head:(%d,%d), length:%d, health:%d\n",myHead.X,myHead.Y,myLength,myHealth)
 
-	if t == 0 {
+	if t == 0 && len(s.food) > 0 {
 		// Special case, we can move in any direction, so just move toward the closest food
 		cf := s.food[0].pos
 		s.debug.Printf("Turn=0 special case, head=(%d,%d), cf=(%d,%d)\n",myHead.X,myHead.Y,cf.X,cf.Y)
 		switch {
-			case cf.X < myHead.X: return Left()
-			case cf.X > myHead.X: return Right()
-			case cf.Y < myHead.Y: return Up()
-			default: return Down()
+			case cf.X < myHead.X: return Left(ReasonOpening)
+			case cf.X > myHead.X: return Right(ReasonOpening)
+			case cf.Y < myHead.Y: return Up(ReasonOpening)
+			default: return Down(ReasonOpening)
 		}
 	}
 
  	// Now, there are up to three possible directions we can move, since our own body
 	// will block at least one direction
-	type MoveType struct {
-		dir 			string
-		c 				Coord
-		nlonger 		int			// how many larger snakes threaten?
-		alternate   	int			// how many alternatives do larger snakes have?
-		nshorter		int			// how many shorter snakes are vulnerable?
-		space 			int			// what space is this move connected to?
-		smallSpace		bool		// is the space too small for us to safely enter?
-		discarded		bool		// has this move been discarded already?
-		squeezed		bool		// will this move squeeze us against a wall?
-		closerToLonger	int			// Number of longer snakes that will be closer if we
-									// pick this move
-		closerToShorter	int			// Number of shorter snakes that will be closer if we
-								    // pick this move
-	}
-	moves := make([]MoveType,0,4)
+	moves = make([]MoveType,0,4)
 
 	s.VisitNeighbours (myHead, func (neighbour Coord, dir string) {
 		if s.IsBody(neighbour) || s.IsHead(neighbour) || 
-		   (s.IsTail(neighbour) && s.snakes[s.SnakeNo(neighbour)].growing) {
+		   (s.IsTail(neighbour) && !s.VacatedNext(neighbour)) {
 			//s.debug.Printf("Direction %s blocked by snake\n", dir)
 		} else {
 			s.debug.Printf("Add to possible moves: %s=(%d,%d)[%d]\n", dir,
@@ -536,6 +1247,11 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 		}
 	})
 
+	if len(moves) == 0 {
+		s.debug.Printf("Suicide!\n")
+		return Left(ReasonSuicide)
+	}
+
 	/*
 	nopen := len(moves)
 
@@ -584,16 +1300,11 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 	}
 	*/
 
-	// Map spaces anchored at each valid adjacent cell
+	// Map the space we would have after each valid move
 	nspaces := 0
 	for index,move := range moves {
-		if (s.grid[move.c.X][move.c.Y].space > 0) { 
-			moves[index].space = int(s.grid[move.c.X][move.c.Y].space)
-			continue 
-		} else {
-			nspaces++
-			moves[index].space = nspaces
-		}
+		nspaces++
+		moves[index].space = nspaces
 
 		s.spaces[nspaces].size = s.MapSpace(move.c,nspaces)
 		//s.debug.Printf("Space %d, direction %s, size %d\n", nspaces, move.dir,
@@ -615,14 +1326,29 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 	// by that much.
 	//
 	// For other spaces, we should not enter if the size of the space is smaller than our 
-	// length.  This is conservative since the boundign snakes will be moving so other 
-	// heuristics are possible here.
+	// length, plus the food in it that we will grow by.  This is conservative since the 
+	// bounding snakes will be moving so other heuristics are possible here.
+
+	// Follow any corridors leading away from our head.  Dead ends we can't
+	// escape are as bad as small spaces, and corridors that can be plugged 
+	// will squeeze us.
+	for index,move := range moves {
+		if !s.weights.Enabled("corridors") { break }
+		corridor := s.TraceCorridor(myHead,move.c)
+		if corridor.length == 0 { continue }
+
+		s.debug.Printf("Direction %s is a corridor, length=%d, exit=(%d,%d), deadEnd=%t, escape=%t, plugged=%t\n",
+					   move.dir,corridor.length,corridor.exit.X,corridor.exit.Y,
+					   corridor.deadEnd,corridor.escape,corridor.plugged)
+		moves[index].corridor = corridor
+		if corridor.plugged { moves[index].squeezed = true }
+	}
 
 	allSmallSpaces := true
 	for index,move := range moves {
 		if (move.nlonger > 0) { continue }
 
-		space := s.grid[move.c.X][move.c.Y].space	
+		space := move.space
 		/*	
 		if s.spaces[space].self {
 			if s.spaces[space].size < myLength/2 - s.spaces[space].nfood {
@@ -631,8 +1357,11 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 				nopen--
 				continue
 			}	
-		} else */ if s.spaces[space].size < myLength {
-			//s.debug.Printf("Avoid %s because it is a space that is too small\n", move.dir)
+		} else */ if s.spaces[space].size < s.RequiredSpace(space) ||
+					  (move.corridor.deadEnd && !move.corridor.escape) || s.HazardFatal(move.c) {
+			if size, required := s.spaces[space].size, s.RequiredSpace(space); size < required && size >= required - s.spaces[space].nfood {
+				s.debug.Printf("Avoid %s because we would outgrow its space eating the food in it\n", move.dir)
+			}
 			moves[index].smallSpace = true
 			continue
 		}
@@ -671,17 +1400,27 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 	// If these moves have an adjacent head from a shorter snake, move to take it out
 	// unless we are in critical health
 
+	s.Lap(&s.phases.Spaces)
+	s.MapStandOffs(moves)
+	leader := s.Leader()
 	allSmallSpacesOrLongerSnakes := true
 	for index,move := range moves {
 		moves[index].nlonger = 0
+		moves[index].nequal = 0
 		moves[index].nshorter = 0
+		tradeLeader := false
 
-		s.VisitNeighbours (move.c, func (neighbour Coord, dir string) {
+		var buf, nextBuf [4]Coord
+		for _,neighbour := range s.Neighbours(move.c, &buf) {
 			if s.IsHead(neighbour) && neighbour != myHead {
-				if s.snakes[s.SnakeNo(neighbour)].length >= myLength {
+				if snake := s.SnakeNo(neighbour); s.snakes[snake].length >= myLength {
 					moves[index].nlonger++
+					if s.snakes[snake].length == myLength {
+						moves[index].nequal++
+						if snake == leader { tradeLeader = true }
+					}
 					// count other moves available to this snake
-					s.VisitNeighbours (neighbour, func (nextNeighbour Coord, dir string) {
+					for _,nextNeighbour := range s.Neighbours(neighbour, &nextBuf) {
 						if nextNeighbour != move.c && 
 						   (!s.IsBody(nextNeighbour) && !s.IsHead(nextNeighbour)) {
 							moves[index].alternate++
@@ -689,18 +1428,45 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 								moves[index].alternate += 4
 							}
 						}
-					})
-				} else {
+					}
+				} else if !s.snakes[snake].teammate {
 					moves[index].nshorter++
 				}
 			}
-		})
+		}
+
+		// Trade ourselves for the leader if that's our policy
+		if tradeLeader && moves[index].nlonger == 1 && s.weights.Ties == "leader" && len(s.snakes) > 2 {
+			s.debug.Printf("Direction %s risks a tie with the leader\n", move.dir)
+			moves[index].nlonger = 0
+			moves[index].nequal = 0
+		}
+
+		// Risk a head to head that is unlikely, at a cost
+		if moves[index].nlonger > 0 {
+			if threat := s.Threat(move.c); threat <= s.weights.ThreatTolerance && !s.Dominated(move.c) {
+				s.debug.Printf("Direction %s risks a longer snake with chance %.2f\n", move.dir, threat)
+				moves[index].threat = threat
+				moves[index].nlonger = 0
+				moves[index].nequal = 0
+			}
+		}
+
+		// Look a move further ahead, for longer snakes that could meet us
+		// wherever we go next
+		if s.weights.Enabled("two-step") {
+			moves[index].contested = s.TwoStepThreat(move.c)
+			if moves[index].contested == 1 {
+				s.debug.Printf("Direction %s leaves every next move open to a longer snake\n", move.dir)
+			}
+		}
 
 		if moves[index].nlonger == 0 && !move.smallSpace { allSmallSpacesOrLongerSnakes = false } 
 	}
 
 	// Check if moves will squeeze us against a wall
-	if myHead.X == 0 || myHead.X == s.w-1 || myHead.Y == 0 || myHead.Y == s.h-1 {
+	if (myHead.X == 0 || myHead.X == s.w-1 || myHead.Y == 0 || myHead.Y == s.h-1) &&
+	   s.weights.Enabled("squeeze") {
 		for index,move := range moves {
 			if move.smallSpace || move.nlonger > 0 {
 				// don't bother checking
@@ -791,7 +1557,7 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 
 	// If we are in good health and we are not the smallest snake, then we try to avoid
 	// larger snakes and move closer to shorter ones
-	goodHealth := y.Health > 2* (s.food[len(s.food)-1].dist)
+	goodHealth := len(s.food) > 0 && y.Health > 2* (s.food[len(s.food)-1].dist)
 	smallestSnake := true
 	largestSnake := true
 	for _,snake := range s.snakes {
@@ -803,9 +1569,37 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 	// turn this off completely for now .. not working great
 	goodHealth = false
 
+	// Once we are the largest snake and well fed there is no need to chase food,
+	// so we coil up instead to keep the board open around us, until our health
+	// projection says it's time to go
+	pursueFood := !largestSnake || y.Health <= s.weights.SatedHealth || !s.weights.Enabled("compact") ||
+				  s.MustEat()
+
+	// If we are alongside a snake that is hugging the wall, keep it pinned there
+	trapDir := ""
+	if s.weights.Enabled("edge-trap") { trapDir = s.EdgeTrap(lastHeads) }
+
+	s.Lap(&s.phases.Threats)
+
+	// Strongly prefer a safe move that leaves an opponent only losing replies
+	if s.weights.Enabled("forcing") {
+		forcing := -1
+		for index,move := range moves {
+			if move.smallSpace || move.nlonger > 0 || move.squeezed || move.threat > 0 || move.contested == 1 { continue }
+			moves[index].forcing = s.ForcedErrors(move.c)
+			if moves[index].forcing > 0 && (forcing < 0 || moves[index].forcing > moves[forcing].forcing) { forcing = index }
+		}
+		if forcing >= 0 {
+			s.debug.Printf("Select %s because it forces an error from %d snakes\n", moves[forcing].dir, moves[forcing].forcing)
+			return Result(moves[forcing].dir, ReasonForcing)
+		}
+	}
+
+	s.ScoreExternally(g, t, b, y, moves)
+
 	// Choose the best move 
 	best := -1
-	bestVal := 0
+	bestVal := 0.0
 	s.debug.Printf("Decide on best move\n")
 	for index,move := range moves {
 		// Don't get trapped in small spaces, unless its our only move
@@ -833,10 +1627,10 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 						}
 					}
 					s.debug.Printf("All our choices are self-enclosed small spaces to chosoe %s, the space closest to our tail\n", moves[smallest].dir)
-					return Result(moves[smallest].dir)
+					return Result(moves[smallest].dir, ReasonSelfEnclosed)
 				} else {
 					s.debug.Printf("All our choices are small spaces, so choose direction %s which is th elargest of them\n",moves[largest].dir)
-					return Result(moves[largest].dir)	
+					return Result(moves[largest].dir, ReasonLargestSmallSpace)
 				}
 			}
 
@@ -852,34 +1646,48 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 			if allSmallSpacesOrLongerSnakes {
 				// Choose the one most likely to avoid a collision,
 				// i.e. nlonger is smallest and among equal number of longer snakes
-				// there are greater alternatives
+				// there are greater alternatives.  Unless we avoid ties at all
+				// costs, a tie beats losing to a longer snake.
+				strict := func(mv MoveType) int {
+					if s.weights.Ties == "avoid" { return 0 }
+					return mv.nlonger - mv.nequal
+				}
 				best := -1
 				for mx,mv := range moves {
 					if mv.smallSpace { continue }
 
 					if best < 0 ||
-					   mv.nlonger < moves[best].nlonger ||
-					   (mv.nlonger == moves[best].nlonger && mv.alternate > moves[best].alternate) {
+					   strict(mv) < strict(moves[best]) ||
+					   (strict(mv) == strict(moves[best]) && mv.nlonger < moves[best].nlonger) ||
+					   (strict(mv) == strict(moves[best]) && mv.nlonger == moves[best].nlonger &&
+						s.StandOffShare(mv.c) > s.StandOffShare(moves[best].c)) ||
+					   (strict(mv) == strict(moves[best]) && mv.nlonger == moves[best].nlonger &&
+						s.StandOffShare(mv.c) == s.StandOffShare(moves[best].c) && mv.alternate > moves[best].alternate) {
 						best = mx
 					}
 				}
 
 				s.debug.Printf("All our choices are threatened by longer snakes, so choose direction %s which where the longer snakes have more alternatives\n",moves[best].dir)
-				return Result(moves[best].dir)
+				return Result(moves[best].dir, ReasonLeastThreatened)
 			}
 
 			move.discarded = true
 			continue 
 		}
 
-		if s.IsFood(move.c) { 
+		if s.IsFood(move.c) && move.threat == 0 && move.contested < 1 { 
 			s.debug.Printf("Select %s because there is a food disc there\n", move.dir)
-			return Result(move.dir) 
+			return Result(move.dir, ReasonFood)
 		}
 
-		if move.nshorter > 0 && t > 50 && largestSnake {
+		if move.nshorter > 0 && move.threat == 0 && move.contested < 1 && (t > 50 || s.CorneredNear(move.c)) && largestSnake && s.weights.Enabled("attack") {
 			s.debug.Printf("Select %s because we have the opportunity to eat a shorter snake\n", move.dir)
-			return Result(move.dir)
+			return Result(move.dir, ReasonAttack)
+		}
+
+		if move.dir == trapDir && !move.squeezed && move.threat == 0 && move.contested < 1 {
+			s.debug.Printf("Select %s to pin a snake against the wall\n", move.dir)
+			return Result(move.dir, ReasonEdgeTrap)
 		}
 
 		// Don't head into a squeeze unless its the only move
@@ -892,7 +1700,7 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 			}
 			if nmoves == 1 {
 				s.debug.Printf("Heading into a squeeze in direction %s but only choice\n",move.dir)
-				return Result(move.dir)
+				return Result(move.dir, ReasonSqueeze)
 			}
 			move.discarded = true
 			continue
@@ -901,54 +1709,77 @@ func FindMove (g Game, t int, b Board, y Snake) string {
 		// If we've got enough health, then prefer to move away from longer snakes and 
 		// toward closer snakes
 		if (goodHealth) {
-			if best < 0 ||
-			   move.closerToLonger < moves[best].closerToLonger ||
-			   (move.closerToLonger == moves[best].closerToLonger && 
+			risk, bestRisk := s.ThreatCost(move), 0.0
+			if best >= 0 { bestRisk = s.ThreatCost(moves[best]) }
+			if best < 0 || risk < bestRisk ||
+			   (risk == bestRisk && move.closerToLonger < moves[best].closerToLonger) ||
+			   (risk == bestRisk && move.closerToLonger == moves[best].closerToLonger && 
 				move.closerToShorter > moves[best].closerToShorter) {
 					best = index
 			}
+		} else if !pursueFood {
+			val := float64(s.Compactness(move.c, myHead)) - 
+				   s.CenterPenalty(move.c) - s.RefugePenalty(move.c) - s.PressurePenalty(move.c) +
+				   s.StarvationBonus(move.c) - s.HazardCost(move.c) - s.ThreatCost(move) +
+				   s.ExternalScore(move)
+			moves[index].value = val
+			if best < 0 || val > bestVal {
+				best = index
+				bestVal = val
+			}
 		} else {
-			dist := s.h + s.w
-			for _,food := range s.food {
-				mdist := ManDist(move.c,food.pos)
-				if mdist < food.dist && food.closerSnakes == 0 {
-					dist = mdist		
-					break;
-				}
-			}	
-
-			if dist == s.h + s.w {
-				for _,food := range s.food {
-					mdist := ManDist(move.c,food.pos)
-					if mdist < food.dist {
-						dist = mdist		
-						break;
-					}
-				}	
+			dist := s.FoodDistance(move.c)
+			val := -float64(dist) - s.CenterPenalty(move.c) - s.RefugePenalty(move.c) - s.PressurePenalty(move.c) +
+				   s.StarvationBonus(move.c) - s.HazardCost(move.c) - s.ThreatCost(move) +
+				   s.ExternalScore(move)
+			moves[index].value = val
+			if best < 0 || val > bestVal { 
+				best = index
+				bestVal = val
 			}
+		}
+	}
 
-			if best < 0 || dist < bestVal { 
+	if best < 0 {
+		// Every move left open squeezes us, so take the first of them
+		for index,move := range moves {
+			if move.nlonger == 0 && !move.smallSpace {
 				best = index
-				bestVal = dist
+				break
 			}
 		}
+		if best < 0 { best = 0 }
+		s.debug.Printf("Select %s because every open move squeezes us\n", moves[best].dir)
+		return Result(moves[best].dir, ReasonSqueezed)
 	}
 
+	reason := ReasonFoodProgress
 	if (goodHealth) {
 		s.debug.Printf("Select %s because it moves us away from lomger snakes and/or closer to shorter snakes\n", moves[best].dir)
+		reason = ReasonAvoidLonger
+	} else if !pursueFood {
+		s.debug.Printf("Select %s because it keeps our body compact\n", moves[best].dir)
+		reason = ReasonCompact
 	} else {
 		s.debug.Printf("Select %s because it makes the best progress toward food\n", moves[best].dir)
 	}
 
-	return Result(moves[best].dir)
+	return Result(moves[best].dir, reason)
 }
 
 func UpdateContext (id string, s []Snake, f []Coord) {
 	gameContext.Lock()
-	gameContext.m[id].heads = make(map[string]Coord)
+	defer gameContext.Unlock()
+
+	// We may be asked to move in a game we never saw start
+	context, ok := gameContext.m[id]
+	if !ok { return }
+
+	context.heads = nil
 	for _,snake := range s {
-		gameContext.m[id].heads[snake.ID] = snake.Body[0]
+		if len(snake.Body) > 0 { context.heads = append(context.heads, SnakeHead { snake.ID, snake.Body[0] }) }
 	}
+	sort.Slice(context.heads, func(i, j int) bool { return context.heads[i].ID < context.heads[j].ID })
 	fvec := make([]Coord,0,len(f))
 	fmap := make(map[Coord]bool)
 	for _,food := range f {
@@ -956,89 +1787,243 @@ func UpdateContext (id string, s []Snake, f []Coord) {
 		fmap[food] = true
 		fvec = append(fvec,food)
 	}
-	gameContext.m[id].food = fvec
-	gameContext.Unlock()
+	context.food = fvec
 }
 
 // HandleMove is called for each turn of each game.
 // Valid responses are "up", "down", "left", or "right".
 func HandleMove(w http.ResponseWriter, r *http.Request) {
+	arrived := time.Now()
 	request := MoveRequest{}
-	json.NewDecoder(r.Body).Decode(&request)
+	if err := DecodeRequest("/move", r, &request); err != nil || len(request.You.ID) == 0 {
+		http.Error(w, "Expected a move request", http.StatusBadRequest)
+		return
+	}
+	PlayMove(request, arrived, time.Now(), func (move, shout string) { WriteMoveResponse(w, move, shout) })
+}
 
-	direction := FindMove (request.Game, request.Turn, request.Board, request.You)
+// Decide our move within the game's timeout, counted from when the
+// request arrived, and answer with it through respond
+func PlayMove (request MoveRequest, arrived, decoded time.Time, respond func (move, shout string)) {
+	// Nothing to think about if we have already been eliminated
+	if !OnBoard(request.Board, request.You) {
+		move := HarmlessMove(request.You)
+		fmt.Printf("INFO(%s): Not on the board at turn %d, moving %s\n", GetContext(request.You.ID).color,
+				   request.Turn, move)
+		respond(move, "")
+		return
+	}
 
-	response := MoveResponse { direction, "" }
+	RecordLatencies(request.You.ID, request.Game, request.Turn, request.Board.Snakes)
+	RecordFoodChances(request.You.ID, request.Game, request.Turn, request.Board.Snakes)
+	// Think in the background, so that the watchdog can answer with a
+	// safe move if we overrun
+	deadline := arrived.Add(MoveBudget(request.Game))
+	decisions := make(chan MoveDecision, 1)
+	go func() {
+		if !AcquireMoveWorker(deadline) {
+			decisions <- MoveDecision{}
+			return
+		}
+		defer ReleaseMoveWorker()
+		// A request we can't make sense of mustn't take the server
+		// down with it, so leave the move to the watchdog's fallback
+		defer func() {
+			if r := recover(); r != nil {
+				fmt.Printf("ERROR(%s): Panic on turn %d: %v\n%s", GetContext(request.You.ID).color,
+						   request.Turn, r, debug.Stack())
+				decisions <- MoveDecision{}
+			}
+		}()
+		if time.Now().After(deadline) {
+			decisions <- MoveDecision{}
+			return
+		}
+		gcBefore := NumGC()
+		decision := ThinkWithin (request.Game, request.Turn, request.Board, request.You, time.Until(deadline))
+		RecordMoveGC(gcBefore, decision)
+		decisions <- decision
+	}()
+
+	var decision MoveDecision
+	watchdog := time.NewTimer(time.Until(deadline) + watchdogGrace)
+	select {
+	case decision = <-decisions:
+		watchdog.Stop()
+	case <-watchdog.C:
+	}
+	if len(decision.Move) == 0 {
+		decision = MoveDecision { Move: SafeMove(request.Board, request.You), Reason: ReasonWatchdog }
+		fmt.Printf("INFO(%s): Out of time on turn %d, moving %s\n", GetContext(request.You.ID).color,
+				   request.Turn, decision.Move)
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	encoding := time.Now()
+	respond(decision.Move, Shout(request.You.ID, decision))
+	decision.Phases.Decode = decoded.Sub(arrived)
+	decision.Phases.Encode = time.Since(encoding)
+	RecordPhases(decision.Phases)
+	if decision.state != nil {
+		decision.state.info.Printf("Move timings %v total=%dus\n", decision.Phases, decision.Phases.Total().Microseconds())
+	}
 
 	UpdateContext(request.You.ID, request.Board.Snakes, request.Board.Food)
+	RecordFrame(request.You.ID, request.Game, request.Turn, request.Board, decision.Move, decision.Reason)
+	RecordAudit(request.You.ID, request.Game, request.Turn, request.Board, decision)
+	RecordFeatures(request.You.ID, decision)
+	RecordMetrics(request.You.ID, decision)
+	LogLearnedState(request.You.ID, request.Turn)
 }
 
-// HandleStart is called at the start of each game your Battlesnake is playing.
-// The StartRequest object contains information about the game that's about to start.
+// Colors we cycle through for each new game, which also tag our logs
+var snakeColors = []struct {
+	name	string
+	hexcode	string
+} {
+	{ "red",	"#cc0000" },	
+	{ "blue",	"#0000cc" },
+	{ "green",	"#006600" },
+	{ "tan",	"#996633" },
+	{ "pink",	"#ff66ff" },
+	{ "violet",	"#cc0099" },
+}  
+
 var colorPicker uint32
-func HandleStart(w http.ResponseWriter, r *http.Request) {
-	request := StartRequest{}
-	json.NewDecoder(r.Body).Decode(&request)
-
-	var colors = []struct {
-		name	string
-		hexcode	string
-	} {
-		{ "red",	"#cc0000" },	
-		{ "blue",	"#0000cc" },
-		{ "green",	"#006600" },
-		{ "tan",	"#996633" },
-		{ "pink",	"#ff66ff" },
-		{ "violet",	"#cc0099" },
-	}  
-	
-	cx := atomic.AddUint32 (&colorPicker, 1) % (uint32)(len(colors))
 
-	response := StartResponse{
-		Color:    colors[cx].hexcode,
-		HeadType: "evil",
-		TailType: "skinny",
-	}
+// Create the context for a new game played by our snake
+func NewContext (id string, b Board) int {
+	cx := int(atomic.AddUint32 (&colorPicker, 1) % (uint32)(len(snakeColors)))
 
 	gameContext.Lock()
-	id := request.You.ID
 	gameContext.m[id] = new(ContextType)
-	gameContext.m[id].color = colors[cx].name
+	gameContext.m[id].color = snakeColors[cx].name
+	gameContext.m[id].spaces = make(map[string][]int)
 	gameContext.Unlock()
 
-	UpdateContext(request.You.ID, request.Board.Snakes, request.Board.Food)
+	UpdateContext(id, b.Snakes, b.Food)
+	return cx
+}
 
-	fmt.Printf("INFO(%s): Start\n", colors[cx].name)
-	
+// HandleStart is called at the start of each game your Battlesnake is playing.
+// The StartRequest object contains information about the game that's about to start.
+func HandleStart(w http.ResponseWriter, r *http.Request) {
+	request := StartRequest{}
+	if err := DecodeRequest("/start", r, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := StartGame(request, RequestTenant(r), RequestPreset(r))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// Set up for a new game for a tenant, playing as the preset if one
+// is given
+func StartGame (request StartRequest, tenant *Tenant, preset string) StartResponse {
+	CountRuleset(request.Game.Ruleset.Name)
+
+	cx := NewContext(request.You.ID, request.Board)
+	gameContext.Lock()
+	gameContext.m[request.You.ID].source = request.Game.Source
+	if tenant != ourTenant { gameContext.m[request.You.ID].tenant = tenant }
+	gameContext.Unlock()
+	c := tenant.Config
+	if len(preset) == 0 { preset = c.GamePreset(request.Game, request.Board, request.You.ID) }
+	if len(preset) > 0 {
+		weights, err := c.PresetWeights(preset, request.Game.Ruleset.Name)
+		if err == nil {
+			gameContext.Lock()
+			gameContext.m[request.You.ID].weights = &weights
+			gameContext.Unlock()
+			fmt.Printf("INFO(%s): Playing as %s\n", snakeColors[cx].name, preset)
+		} else {
+			fmt.Printf("INFO(%s): %v\n", snakeColors[cx].name, err)
+		}
+	}
+	AssignExperiments(request.You.ID, c, request.Game)
+	LogLearnedState(request.You.ID, request.Turn)
+	WarmUp(request.Board.Width, request.Board.Height, len(request.Board.Snakes))
+
+	response := StartResponse{
+		Color:    snakeColors[cx].hexcode,
+		HeadType: "evil",
+		TailType: "skinny",
+	}
+
+	fmt.Printf("INFO(%s): Start source=%s\n", snakeColors[cx].name, request.Game.Source)
+	if c, ok := request.You.Customization(); ok {
+		fmt.Printf("INFO(%s): Appearing as color=%s head=%s tail=%s\n", snakeColors[cx].name, c.Color, c.Head, c.Tail)
+	}
+	return response
+}
+
 // HandleEnd is called when a game your Battlesnake was playing has ended.
 // It's purely for informational purposes, no response required.
 func HandleEnd(w http.ResponseWriter, r *http.Request) {
 	request := EndRequest{}
-	json.NewDecoder(r.Body).Decode(&request)
+	if err := DecodeRequest("/end", r, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	EndGame(request)
+}
+
+// Record and forget a game that is over
+func EndGame (request EndRequest) {
+	RecordFrame(request.You.ID, request.Game, request.Turn, request.Board, "", "")
+	SaveRecord(request.You.ID)
+	SaveMetrics(request.You.ID)
+	RecordOpponentStats(request.You.ID)
+	RecordExperimentOutcome(request.You.ID)
+	if record, _, ok := GameOutcome(request.You.ID); ok { go ReportGame(record, TenantOf(request.You.ID)) }
 
 	gameContext.Lock()
 	delete(gameContext.m,request.You.ID)
 	gameContext.Unlock()
+	ForgetQuirks(request.You.ID)
+	ForgetLearnedState(request.You.ID)
 	
 	// Nothing to respond with here
 	fmt.Print("END\n")
 }
 
 func main() {
+	if runtime.GOOS == "js" {
+		ServeBrowser()
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if len(port) == 0 {
 		port = "8080"
 	}
 
-	gameContext.m = make(map[string]*ContextType)
+	if len(os.Args) > 1 && os.Args[1] == "--validate-config" {
+		os.Exit(ValidateConfigCommand(os.Args[2:]))
+	}
 
+	if len(os.Args) > 1 {
+		os.Exit(RunCommand(os.Args[1], os.Args[2:]))
+	}
+
+	if err := LoadConfig(); err != nil {
+		log.Fatal("Unable to load config: ", err)
+	}
+	ResetGameContexts()
+	RecoverGames()
+	WarmUp(11, 11, 4)
+	StartMoveWorkers()
+	StartCompaction()
+	Routes()
+	StartGRPC()
+	WarnTrust()
+
+	fmt.Printf("Starting Battlesnake Server at http://0.0.0.0:%s...\n", port)
+	log.Fatal(http.ListenAndServe(":"+port, Guard(http.DefaultServeMux)))
+}
+
+// Serve the snake and everything alongside it
+func Routes () {
 	http.HandleFunc("/", func (w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "Spacey Snake is alive!")
 	})
@@ -1047,10 +2032,18 @@ func main() {
 		fmt.Fprint(w, "One ping only please.")
 	})	
 
-	http.HandleFunc("/start", HandleStart)
-	http.HandleFunc("/move", HandleMove)
-	http.HandleFunc("/end", HandleEnd)
-
-	fmt.Printf("Starting Battlesnake Server at http://0.0.0.0:%s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	http.HandleFunc("/start", EngineOnly(HandleStart))
+	http.HandleFunc("/move", EngineOnly(HandleMove))
+	http.HandleFunc("/end", EngineOnly(HandleEnd))
+	http.HandleFunc("/games/", ViewerOnly(HandleGames))
+	http.HandleFunc("/analyze", AdminOnly(HandleAnalyze))
+	http.HandleFunc("/analyze/batch", AdminOnly(HandleAnalyzeBatch))
+	http.HandleFunc("/analyze/compare", AdminOnly(HandleCompare))
+	http.HandleFunc("/metrics", AdminOnly(HandleMetrics))
+	http.HandleFunc("/healthz", HandleHealthz)
+	http.HandleFunc("/readyz", HandleReadyz)
+	http.HandleFunc("/version", HandleVersion)
+	http.HandleFunc("/selftest", AdminOnly(HandleSelfTest))
+	HandlePresets()
+	HandleTenants()
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ----------------------------------------------------------------
+// Board Maps
+//
+// Per cell views of a position, for checking that our heuristics
+// see the board the way we think they do.  Each map is indexed
+// [y][x] so it can be drawn as a heatmap directly.
+//
+//   danger    how many heads of snakes at least as long as us can
+//             move into the cell next turn
+//   voronoi   the snake that can reach the cell first, -1 if there
+//             is a tie or nobody can reach it
+//   regions   the connected region of free cells the cell belongs
+//             to, -1 for cells occupied by snakes
+//
+// Snakes are numbered as in the game state, with ours as 0.
+// ----------------------------------------------------------------
+
+type BoardMaps struct {
+	Turn	int			`json:"turn"`
+	Width	int			`json:"width"`
+	Height	int			`json:"height"`
+	Snakes	[]string	`json:"snakes"`
+	Danger	[][]int		`json:"danger"`
+	Voronoi	[][]int		`json:"voronoi"`
+	Regions	[][]int		`json:"regions"`
+}
+
+// Build the game state for a position without deciding on a move
+func NewGameState (g Game, t int, b Board, y Snake) *GameState {
+	s := new(GameState)
+	s.debug = NewLogger(y.ID, "DEBUG")
+	s.info = NewLogger(y.ID, "INFO")
+	s.Initialize(g,t,b,y)
+	return s
+}
+
+func (s *GameState) NewMap (fill int) [][]int {
+	m := make([][]int, s.h)
+	for y := range m {
+		m[y] = make([]int, s.w)
+		for x := range m[y] { m[y][x] = fill }
+	}
+	return m
+}
+
+func (s *GameState) DangerMap () [][]int {
+	danger := s.NewMap(0)
+	myLength := s.snakes[0].length
+	for _,snake := range s.snakes[1:] {
+		if snake.length < myLength { continue }
+		s.VisitNeighbours (snake.head, func (neighbour Coord, dir string) {
+			danger[neighbour.Y][neighbour.X]++
+		})
+	}
+	return danger
+}
+
+// Breadth first search from every head at once
+func (s *GameState) VoronoiMap () [][]int {
+	var key uint64
+	if len(s.snakes) > 0 {
+		key = s.PositionHash()
+		if owner, ok := CachedVoronoi(s.snakes[0].ID, key); ok { return owner }
+	}
+
+	owner := s.NewMap(-1)
+	dist := s.NewMap(-1)
+
+	frontier := make([]Coord, 0, len(s.snakes))
+	for sx,snake := range s.snakes {
+		owner[snake.head.Y][snake.head.X] = sx
+		dist[snake.head.Y][snake.head.X] = 0
+		frontier = append(frontier, snake.head)
+	}
+
+	for len(frontier) > 0 && !s.cancel.Expired() {
+		var next []Coord
+		for _,p := range frontier {
+			from := owner[p.Y][p.X]
+			if from < 0 { continue }
+			s.VisitNeighbours (p, func (neighbour Coord, dir string) {
+				if !s.IsFree(neighbour) { return }
+				d := dist[neighbour.Y][neighbour.X]
+				switch {
+				case d < 0:
+					dist[neighbour.Y][neighbour.X] = dist[p.Y][p.X] + 1
+					owner[neighbour.Y][neighbour.X] = from
+					next = append(next, neighbour)
+				case d == dist[p.Y][p.X] + 1 && owner[neighbour.Y][neighbour.X] != from:
+					owner[neighbour.Y][neighbour.X] = -1
+				}
+			})
+		}
+		frontier = next
+	}
+
+	// Heads belong to their snakes, but no other occupied cell is owned
+	for x := 0; x < s.w; x++ {
+		for y := 0; y < s.h; y++ {
+			if !s.IsFree(Coord{ x, y }) && !s.IsHead(Coord{ x, y }) { owner[y][x] = -1 }
+		}
+	}
+
+	// A search cut short is only good for this move
+	if len(s.snakes) > 0 && !s.cancel.Expired() { CacheVoronoi(s.snakes[0].ID, key, owner) }
+	return owner
+}
+
+func (s *GameState) RegionMap () [][]int {
+	region := s.NewMap(-1)
+	nregions := 0
+	for x := 0; x < s.w; x++ {
+		for y := 0; y < s.h; y++ {
+			c := Coord{ x, y }
+			if region[y][x] >= 0 || !s.IsFree(c) { continue }
+
+			stack := []Coord{ c }
+			region[y][x] = nregions
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				s.VisitNeighbours (p, func (neighbour Coord, dir string) {
+					if region[neighbour.Y][neighbour.X] >= 0 || !s.IsFree(neighbour) { return }
+					region[neighbour.Y][neighbour.X] = nregions
+					stack = append(stack, neighbour)
+				})
+			}
+			nregions++
+		}
+	}
+	return region
+}
+
+func (s *GameState) Maps () BoardMaps {
+	maps := BoardMaps {
+		Turn:		s.turn,
+		Width:		s.w,
+		Height:		s.h,
+		Danger:		s.DangerMap(),
+		Voronoi:	s.VoronoiMap(),
+		Regions:	s.RegionMap(),
+	}
+	for _,snake := range s.snakes { maps.Snakes = append(maps.Snakes, snake.ID) }
+	return maps
+}
+
+// HandleAnalyze takes the same request as /move and returns the
+// maps of the position instead of a move
+func HandleAnalyze (w http.ResponseWriter, r *http.Request) {
+	request := MoveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.You.Body) == 0 {
+		http.Error(w, "Expected a move request", http.StatusBadRequest)
+		return
+	}
+
+	s := NewGameState(request.Game, request.Turn, request.Board, request.You)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Maps())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ----------------------------------------------------------------
+// Turn Metrics
+//
+// A summary of our position and decision on each turn, written out
+// as CSV with one row per turn so games can be explored in a 
+// spreadsheet or notebook.
+// ----------------------------------------------------------------
+
+type TurnMetrics struct {
+	Game			string
+	Turn			int
+	Health			int
+	Length			int
+	Space			int			// cells we can reach from our head
+	VoronoiShare	float64		// fraction of free cells we reach first
+	FoodDist		int			// distance to the nearest food, -1 if none
+	Reason			string		// the rule that picked our move
+	Move			string
+	Latency			int64		// microseconds spent deciding
+}
+
+var metricsHeader = []string {
+	"game", "turn", "health", "length", "space", "voronoi_share",
+	"food_dist", "reason", "move", "latency_us",
+}
+
+func (d MoveDecision) Metrics () TurnMetrics {
+	s := d.state
+	me := s.snakes[0]
+	m := TurnMetrics {
+		Game:		s.ID,
+		Turn:		s.turn,
+		Health:		me.health,
+		Length:		me.length,
+		Space:		me.space,
+		FoodDist:	-1,
+		Reason:		string(d.Reason),
+		Move:		d.Move,
+		Latency:	d.Elapsed.Microseconds(),
+	}
+	if len(s.food) > 0 { m.FoodDist = s.food[0].dist }
+
+	owned, free := 0, 0
+	for _,row := range s.VoronoiMap() {
+		for _,owner := range row {
+			if owner >= 0 { free++ }
+			if owner == 0 { owned++ }
+		}
+	}
+	if free > 0 { m.VoronoiShare = float64(owned) / float64(free) }
+	return m
+}
+
+func (m TurnMetrics) Row () []string {
+	return []string {
+		m.Game,
+		strconv.Itoa(m.Turn),
+		strconv.Itoa(m.Health),
+		strconv.Itoa(m.Length),
+		strconv.Itoa(m.Space),
+		strconv.FormatFloat(m.VoronoiShare, 'f', 3, 64),
+		strconv.Itoa(m.FoodDist),
+		m.Reason,
+		m.Move,
+		strconv.FormatInt(m.Latency, 10),
+	}
+}
+
+func WriteMetrics (path string, metrics []TurnMetrics) error {
+	f, err := os.Create(path)
+	if err != nil { return err }
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(metricsHeader)
+	for _,m := range metrics { w.Write(m.Row()) }
+	w.Flush()
+	return w.Error()
+}
+
+// ----------------------------------------------------------------
+// Recording metrics of live games
+//
+// Written next to the game record when RECORD_DIR is set.
+// ----------------------------------------------------------------
+
+func RecordMetrics (id string, d MoveDecision) {
+	if len(TenantOf(id).RecordDir()) == 0 || d.state == nil || len(d.state.snakes) == 0 { return }
+	m := d.Metrics()
+
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok { return }
+
+	// The engine may ask for the same turn twice, so keep the latest
+	if n := len(context.metrics); n > 0 && context.metrics[n-1].Turn == m.Turn {
+		context.metrics = context.metrics[:n-1]
+	}
+	context.metrics = append(context.metrics, m)
+}
+
+func SaveMetrics (id string) {
+	context := GetContext(id)
+	if len(context.metrics) == 0 { return }
+
+	path := filepath.Join(context.Tenant().RecordDir(), filepath.Base(context.metrics[0].Game) + ".csv")
+	if err := WriteMetrics(path, context.metrics); err != nil {
+		fmt.Printf("ERROR: Unable to write metrics: %v\n", err)
+	}
+}
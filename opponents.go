@@ -0,0 +1,133 @@
+package main
+
+import "sync"
+
+// ----------------------------------------------------------------
+// Opponent Models
+//
+// What we learn about an opponent in one game is worth knowing in
+// the next, and when several of our personalities are hosted by the
+// same server, in their games too.  Opponents are known by name,
+// since IDs change from game to game.  We learn how often a snake is
+// slow to respond, so that a snake we have seen struggle elsewhere
+// is pressured from the first turn of a new game rather than only
+// once it has been slow in this one, and how often a snake with food
+// beside its head eats it, which tells us where it is likely to move.
+//
+// Models are kept behind OpponentStore so that they could be shared
+// between servers.  The one in memory is shared by every game this
+// process plays, but for those of its tenants, who each have their
+// own.  A turn is only counted once, however many of our snakes are
+// in the game.
+// ----------------------------------------------------------------
+
+type OpponentModel struct {
+	Moves		int			// moves we have seen the snake make
+	SlowMoves	int			// moves that came close to the timeout
+	FoodChances	int			// moves made with food beside the snake's head
+	FoodTaken	int			// those that ate it
+	game		string		// the last game and turn observed
+	turn		int
+	foodGame	string		// the last game and turn observed for food
+	foodTurn	int
+}
+
+type OpponentStore interface {
+	Observe (name string, game string, turn int, slow bool)
+	ObserveFood (name string, game string, turn int, ate bool)
+	Model (name string) (OpponentModel, bool)
+}
+
+// Moves we must have seen before judging a snake, and the fraction
+// of them that must have been slow for it to count as slow
+const opponentMinMoves = 20
+const opponentSlowShare = 0.5
+
+// How often we expect a snake we don't know to eat food beside its head
+const defaultFoodShare = 0.6
+
+type memoryOpponents struct {
+	sync.Mutex
+	m map[string]*OpponentModel
+}
+
+func NewMemoryOpponents () OpponentStore {
+	return &memoryOpponents { m: make(map[string]*OpponentModel) }
+}
+
+var opponents = NewMemoryOpponents()
+
+func (o *memoryOpponents) Observe (name string, game string, turn int, slow bool) {
+	o.Lock()
+	defer o.Unlock()
+	model, ok := o.m[name]
+	if !ok {
+		model = new(OpponentModel)
+		o.m[name] = model
+	}
+	if model.game == game && model.turn == turn { return }
+	model.game, model.turn = game, turn
+	model.Moves++
+	if slow { model.SlowMoves++ }
+}
+
+func (o *memoryOpponents) ObserveFood (name string, game string, turn int, ate bool) {
+	o.Lock()
+	defer o.Unlock()
+	model, ok := o.m[name]
+	if !ok {
+		model = new(OpponentModel)
+		o.m[name] = model
+	}
+	if model.foodGame == game && model.foodTurn == turn { return }
+	model.foodGame, model.foodTurn = game, turn
+	model.FoodChances++
+	if ate { model.FoodTaken++ }
+}
+
+func (o *memoryOpponents) Model (name string) (OpponentModel, bool) {
+	o.Lock()
+	defer o.Unlock()
+	model, ok := o.m[name]
+	if !ok { return OpponentModel{}, false }
+	return *model, true
+}
+
+// Have we seen a snake be slow often enough, in any of our games?
+func KnownSlow (opponents OpponentStore, name string) bool {
+	model, ok := opponents.Model(name)
+	if !ok || model.Moves < opponentMinMoves { return false }
+	return float64(model.SlowMoves) >= opponentSlowShare * float64(model.Moves)
+}
+
+// How often a snake eats food beside its head, as far as we know
+func FoodShare (opponents OpponentStore, name string) float64 {
+	model, ok := opponents.Model(name)
+	if !ok || model.FoodChances < opponentMinMoves { return defaultFoodShare }
+	return float64(model.FoodTaken) / float64(model.FoodChances)
+}
+
+// Note which opponents had food beside their heads last turn, and
+// whether they ate it, before the context moves on to this turn
+func RecordFoodChances (id string, g Game, turn int, snakes []Snake) {
+	context := GetContext(id)
+	gameContext.RLock()
+	heads, food := context.heads, context.food
+	gameContext.RUnlock()
+
+	for _,snake := range snakes {
+		if snake.ID == id || len(snake.Body) == 0 { continue }
+		last, ok := LastHead(heads, snake.ID)
+		if !ok || ManDist(last, snake.Body[0]) != 1 { continue }
+		chance := false
+		for _,c := range food {
+			if ManDist(last, c) == 1 { chance = true }
+		}
+		if !chance { continue }
+		ate := false
+		for _,c := range food {
+			if c == snake.Body[0] { ate = true }
+		}
+		TenantOf(id).Opponents.ObserveFood(snake.Name, g.ID, turn, ate)
+	}
+}
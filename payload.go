@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Payloads
+//
+// On 19x19 and 25x25 boards with eight snakes every request is tens
+// of kilobytes of JSON, and decoding it comes out of our time to
+// think.  Request bodies are read into pooled buffers sized from
+// Content-Length, may be gzipped, and we keep track of how large
+// they are and how long they take to decode.
+//
+// A body is read whole rather than decoded as it streams in, since
+// the bytes are needed again for the requests we check for schema
+// drift and for those whose field types we have to convert.  Either
+// way the whole request is in memory before we can think about it.
+// ----------------------------------------------------------------
+
+// Bytes we make room for up front, or keep a buffer of in the pool,
+// whatever a request claims
+const largeBody = 1 << 20
+
+var bufferPool = sync.Pool {
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// Read a request body into a buffer from the pool, which the caller
+// must give back with ReleaseBuffer
+func ReadRequestBody (r *http.Request) (*bytes.Buffer, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	var body io.Reader = r.Body
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			ReleaseBuffer(buf)
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+		if max := config.Protection.MaxBody; max > 0 { body = io.LimitReader(gz, max + 1) }
+	} else if r.ContentLength > 0 {
+		// The length is the client's word, so don't trust it too far
+		size := r.ContentLength
+		limit := config.Protection.MaxBody
+		if limit <= 0 || limit > largeBody { limit = largeBody }
+		if size > limit { size = limit }
+		buf.Grow(int(size))
+	}
+
+	if _, err := buf.ReadFrom(body); err != nil {
+		ReleaseBuffer(buf)
+		return nil, err
+	}
+	if max := config.Protection.MaxBody; max > 0 && int64(buf.Len()) > max {
+		ReleaseBuffer(buf)
+		return nil, fmt.Errorf("request body over %d bytes", max)
+	}
+	return buf, nil
+}
+
+func ReleaseBuffer (buf *bytes.Buffer) {
+	// Don't hold on to buffers from unusually large requests
+	if buf.Cap() > largeBody { return }
+	bufferPool.Put(buf)
+}
+
+type PayloadStats struct {
+	requests	int
+	bytes		int
+	maxBytes	int
+	decoding	time.Duration
+}
+
+var payloadStats struct {
+	sync.Mutex
+	m map[string]*PayloadStats
+}
+
+func CountPayload (endpoint string, size int, decoding time.Duration) {
+	payloadStats.Lock()
+	defer payloadStats.Unlock()
+	if payloadStats.m == nil { payloadStats.m = make(map[string]*PayloadStats) }
+
+	stats, ok := payloadStats.m[endpoint]
+	if !ok {
+		stats = new(PayloadStats)
+		payloadStats.m[endpoint] = stats
+	}
+	stats.requests++
+	stats.bytes += size
+	if size > stats.maxBytes { stats.maxBytes = size }
+	stats.decoding += decoding
+}
+
+func WritePayloadMetrics (w http.ResponseWriter) {
+	payloadStats.Lock()
+	defer payloadStats.Unlock()
+
+	var endpoints []string
+	for endpoint := range payloadStats.m { endpoints = append(endpoints, endpoint) }
+	sort.Strings(endpoints)
+
+	metric := func (name, kind, help string, value func(*PayloadStats) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _,endpoint := range endpoints {
+			fmt.Fprintf(w, "%s{endpoint=\"%s\"} %g\n", name, EscapeLabel(endpoint), value(payloadStats.m[endpoint]))
+		}
+	}
+
+	metric("spacey_request_bytes_total", "counter", "Bytes of JSON received, after decompression.",
+		func (s *PayloadStats) float64 { return float64(s.bytes) })
+	metric("spacey_requests_total", "counter", "Requests received.",
+		func (s *PayloadStats) float64 { return float64(s.requests) })
+	metric("spacey_request_bytes_max", "gauge", "Largest request received.",
+		func (s *PayloadStats) float64 { return float64(s.maxBytes) })
+	metric("spacey_decode_seconds_total", "counter", "Time spent decoding requests.",
+		func (s *PayloadStats) float64 { return s.decoding.Seconds() })
+}
+
+// ----------------------------------------------------------------
+// Responses
+//
+// There are only four moves, so their responses are marshaled once
+// up front, and a shout, when we have one, is spliced in through a
+// pooled buffer.
+// ----------------------------------------------------------------
+
+var moveResponses = make(map[string][]byte)
+
+func init () {
+	for _,dir := range []string { "up", "down", "left", "right" } {
+		data, _ := json.Marshal(MoveResponse { Move: dir })
+		moveResponses[dir] = append(data, '\n')
+	}
+}
+
+// The whole response goes out as soon as it is written, with its
+// length, so the engine has the move while we go on with the turn's
+// bookkeeping
+func WriteMoveResponse (w http.ResponseWriter, move string, shout string) {
+	response, ok := moveResponses[move]
+	if ok && len(shout) == 0 {
+		sendResponse(w, response)
+		return
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if !ok {
+		json.NewEncoder(buf).Encode(MoveResponse { move, shout })
+	} else {
+		quoted, _ := json.Marshal(shout)
+		buf.Write(response[:len(response)-2])		// without the closing brace and newline
+		buf.WriteString(`,"shout":`)
+		buf.Write(quoted)
+		buf.WriteString("}\n")
+	}
+	sendResponse(w, buf.Bytes())
+	ReleaseBuffer(buf)
+}
+
+func sendResponse (w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+	if f, ok := w.(http.Flusher); ok { f.Flush() }
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Phase Timings
+//
+// One elapsed time per move can't tell us where the time goes, so
+// each move is broken down into phases:
+//
+//   decode      reading and decoding the request
+//   initialize  building the grid and snake states
+//   spaces      flood fills from every head and every candidate move
+//   threats     head to head threats, squeezes and snake distances
+//   search      weighing the moves, and any rollouts
+//   encode      writing the response
+//
+// The breakdown is logged with every move and the totals exported
+// from /metrics.
+// ----------------------------------------------------------------
+
+type PhaseTimings struct {
+	Decode		time.Duration
+	Initialize	time.Duration
+	Spaces		time.Duration
+	Threats		time.Duration
+	Search		time.Duration
+	Encode		time.Duration
+}
+
+func (p PhaseTimings) Total () time.Duration {
+	return p.Decode + p.Initialize + p.Spaces + p.Threats + p.Search + p.Encode
+}
+
+func (p PhaseTimings) each (f func(name string, d time.Duration)) {
+	f("decode", p.Decode)
+	f("initialize", p.Initialize)
+	f("spaces", p.Spaces)
+	f("threats", p.Threats)
+	f("search", p.Search)
+	f("encode", p.Encode)
+}
+
+// Phases in microseconds, leaving out any that didn't run
+func (p PhaseTimings) String () string {
+	var parts []string
+	p.each(func(name string, d time.Duration) {
+		if d > 0 { parts = append(parts, fmt.Sprintf("%s=%dus", name, d.Microseconds())) }
+	})
+	return strings.Join(parts, " ")
+}
+
+// Add the time since the last lap to a phase
+func (s *GameState) Lap (phase *time.Duration) {
+	now := time.Now()
+	*phase += now.Sub(s.lap)
+	s.lap = now
+}
+
+var phaseStats struct {
+	sync.Mutex
+	moves	int64
+	totals	PhaseTimings
+}
+
+func RecordPhases (p PhaseTimings) {
+	phaseStats.Lock()
+	defer phaseStats.Unlock()
+	phaseStats.moves++
+	t := &phaseStats.totals
+	t.Decode += p.Decode
+	t.Initialize += p.Initialize
+	t.Spaces += p.Spaces
+	t.Threats += p.Threats
+	t.Search += p.Search
+	t.Encode += p.Encode
+}
+
+func WritePhaseMetrics (w http.ResponseWriter) {
+	phaseStats.Lock()
+	defer phaseStats.Unlock()
+
+	fmt.Fprintf(w, "# HELP spacey_moves_timed_total Moves broken down into phases.\n")
+	fmt.Fprintf(w, "# TYPE spacey_moves_timed_total counter\nspacey_moves_timed_total %d\n", phaseStats.moves)
+	fmt.Fprintf(w, "# HELP spacey_move_phase_seconds_total Time spent in each phase of deciding on moves.\n")
+	fmt.Fprintf(w, "# TYPE spacey_move_phase_seconds_total counter\n")
+	phaseStats.totals.each(func(name string, d time.Duration) {
+		fmt.Fprintf(w, "spacey_move_phase_seconds_total{phase=\"%s\"} %g\n", name, d.Seconds())
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Playing Against Ourselves
+//
+// A game in the terminal between you, steering with the arrow keys
+// or w/a/s/d, and copies of our strategies, given as for tournaments:
+//
+//   spacey-snake play default hunter
+//
+// Each turn waits for your key, and q quits.  With -cli, the game is
+// run by the official Battlesnake CLI instead, if it is installed,
+// playing against this server with the CLI drawing the board, so new
+// behaviour can be tried out against the real rules engine:
+//
+//   spacey-snake play -cli -port 8090 default cautious
+//
+// The CLI can only reach the snakes the server serves, so there the
+// strategies have to be "default" or the names of presets.
+// ----------------------------------------------------------------
+
+const humanID = "you"
+
+// Put the terminal into raw mode, so keys arrive as they are pressed,
+// returning how to put it back
+func RawTerminal () (func(), error) {
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		return cmd.Output()
+	}
+	state, err := stty("-g")
+	if err != nil { return nil, fmt.Errorf("unable to set up the terminal: %v", err) }
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, fmt.Errorf("unable to set up the terminal: %v", err)
+	}
+	return func() { stty(strings.TrimSpace(string(state))) }, nil
+}
+
+var keyMoves = map[byte]string {
+	'w': "up", 'a': "left", 's': "down", 'd': "right",
+	'k': "up", 'h': "left", 'j': "down", 'l': "right",
+	'A': "up", 'D': "left", 'B': "down", 'C': "right",		// the ends of arrow key sequences
+}
+
+// Send each move key pressed, or "quit"
+func ReadKeys (keys chan<- string) {
+	buf := make([]byte, 1)
+	for {
+		if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+			keys <- "quit"
+			return
+		}
+		if buf[0] == 'q' || buf[0] == 3 {
+			keys <- "quit"
+			return
+		}
+		if move, ok := keyMoves[buf[0]]; ok { keys <- move }
+	}
+}
+
+func PlayCommand (args []string) int {
+	flags := flag.NewFlagSet("play", flag.ExitOnError)
+	size := flags.Int("size", 11, "width and height of the board")
+	seed := flags.Int64("seed", 0, "random seed, from the clock by default")
+	cli := flags.Bool("cli", false, "run the game with the official Battlesnake CLI")
+	port := flags.Int("port", 8090, "port to serve our snakes on for the CLI")
+	flags.Parse(args)
+
+	specs := flags.Args()
+	if len(specs) == 0 { specs = []string { "default" } }
+	if len(specs) > 7 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake play [-size n] [-seed n] [-cli] [-port n] [strategy...], up to 7 strategies\n")
+		return 2
+	}
+
+	// Copies of the same strategy get numbered
+	ids := []string { humanID }
+	players := make(map[string]Participant)
+	for i,spec := range specs {
+		player, err := LoadParticipant(spec, "standard")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		id := player.Name
+		if _, ok := players[id]; ok || id == humanID { id = fmt.Sprintf("%s-%d", id, i+1) }
+		players[id] = player
+		ids = append(ids, id)
+	}
+
+	restore, err := RawTerminal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer restore()
+	keys := make(chan string, 16)
+	go ReadKeys(keys)
+
+	engineLogging = false
+	if *cli { return PlayCLI(specs, ids, *size, *port, keys) }
+
+	if *seed == 0 { *seed = time.Now().UnixNano() }
+	rng := rand.New(rand.NewSource(*seed))
+	g, b := NewRandomGame("play", "standard", *size, *size, ids, rng)
+	sim := NewSimulation(g, 0, b)
+	for _,id := range ParticipantIDs(players) {
+		player := players[id]
+		if player.Weights == nil { continue }
+		NewContext(id, sim.Board)
+		weights := *player.Weights
+		GetContext(id).weights = &weights
+	}
+
+	moves := make(map[string]string)
+	for !sim.Over() {
+		if _, ok := sim.Snake(humanID); !ok { break }
+		fmt.Print(ClearScreen + RenderBoardANSI(sim.Board, moves))
+		fmt.Printf("Turn %d: move with the arrow keys or w/a/s/d, q to quit\n", sim.Turn)
+
+		move := <-keys
+		if move == "quit" { return 0 }
+		moves = map[string]string { humanID: move }
+		for _,snake := range sim.Board.Snakes {
+			if player, ok := players[snake.ID]; ok { moves[snake.ID] = player.Move(sim, snake, rng) }
+		}
+		sim.Step(moves)
+		sim.SpawnFood(rng, 1, 0.15)
+	}
+
+	fmt.Print(ClearScreen + RenderBoardANSI(sim.Board, moves))
+	for _,e := range sim.Eliminated {
+		fmt.Printf("Turn %d: %s eliminated by %s %s\n", e.Turn, e.ID, e.Cause, e.By)
+	}
+	if _, ok := sim.Snake(humanID); ok {
+		fmt.Printf("You survived to turn %d\n", sim.Turn)
+	} else {
+		fmt.Printf("You did not survive\n")
+	}
+	return 0
+}
+
+// Serve our snakes and a snake steered from the keyboard, and have
+// the CLI play them against each other
+func PlayCLI (specs []string, ids []string, size int, port int, keys <-chan string) int {
+	path, err := exec.LookPath("battlesnake")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The Battlesnake CLI isn't installed, play without -cli to use our own rules\n")
+		return 1
+	}
+	for _,spec := range specs {
+		if _, ok := config.presetJSON(spec); !ok && spec != "default" {
+			fmt.Fprintf(os.Stderr, "%s: the CLI can only play \"default\" or a preset\n", spec)
+			return 2
+		}
+	}
+
+	quit := make(chan bool, 1)
+	http.HandleFunc("/human/", func (w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/move") {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"apiversion":"1","color":"#ff0000"}`)
+			return
+		}
+		// Take the latest key pressed, waiting for one if there isn't any
+		move := <-keys
+		for len(keys) > 0 { move = <-keys }
+		if move == "quit" {
+			quit <- true
+			move = "up"
+		}
+		WriteMoveResponse(w, move, "")
+	})
+	StartMoveWorkers()
+	Routes()
+	address := fmt.Sprintf("127.0.0.1:%d", port)
+	go func() {
+		if err := http.ListenAndServe(address, nil); err != nil { fmt.Fprintf(os.Stderr, "%v\n", err) }
+	}()
+
+	args := []string { "play", "--width", fmt.Sprint(size), "--height", fmt.Sprint(size),
+					   "--timeout", "3600000", "--viewmap",
+					   "--name", humanID, "--url", "http://" + address + "/human" }
+	for i,spec := range specs {
+		url := "http://" + address
+		if spec != "default" { url += "/" + spec }
+		args = append(args, "--name", ids[i+1], "--url", url)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-quit:
+		cmd.Process.Kill()
+		<-done
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Presets
+//
+// Named play styles, laid over the weights of the ruleset being
+// played so that one deployment can enter different leagues with
+// quite different snakes:
+//
+//   greedy     eats whenever it can and never coils up
+//   cautious   leaves other snakes alone and wants plenty of room
+//   hunter     goes after shorter snakes and holds the center
+//   troll      eats everything, starving snakes' food included, and
+//              crowds anyone slow to respond
+//
+// A game takes its preset from the snake's URL, either as the first
+// part of the path or a query parameter:
+//
+//   https://example.com/hunter
+//   https://example.com/?preset=hunter
+//
+// or else from the first of the config's "strategies" that matches
+// the game, falling back to "preset" in the config.  Like profiles,
+// presets only list the weights they change, and the config can add
+// presets or replace these under "presets".  Rules a preset disables
+// are added to those the profile disables.
+// ----------------------------------------------------------------
+
+var builtinPresets = map[string]string {
+	"greedy":	`{ "satedHealth": 100, "centerWeight": 0, "disable": [ "compact" ] }`,
+	"cautious":	`{ "satedHealth": 60, "cautious": true, "threatTolerance": 0, "disable": [ "attack", "edge-trap", "pressure" ] }`,
+	"hunter":	`{ "satedHealth": 35, "centerWeight": 1.0, "centerFadeTurn": 300, "pressureWeight": 1.5 }`,
+	"troll":	`{ "satedHealth": 100, "pressureWeight": 2.0, "disable": [ "triage" ] }`,
+}
+
+// The names of every preset, built in or from the config
+func PresetNames () []string { return config.PresetNames() }
+
+func (c Config) PresetNames () []string {
+	var names []string
+	for name := range builtinPresets { names = append(names, name) }
+	for name := range c.Presets {
+		if _, ok := builtinPresets[name]; !ok { names = append(names, name) }
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c Config) presetJSON (name string) (json.RawMessage, bool) {
+	if preset, ok := c.Presets[name]; ok { return preset, true }
+	if preset, ok := builtinPresets[name]; ok { return json.RawMessage(preset), true }
+	return nil, false
+}
+
+// The weights for a ruleset with a preset laid over them
+func PresetWeights (name string, ruleset string) (Weights, error) {
+	return config.PresetWeights(name, ruleset)
+}
+
+func (c Config) PresetWeights (name string, ruleset string) (Weights, error) {
+	weights := c.WeightsFor(ruleset)
+	preset, ok := c.presetJSON(name)
+	if !ok { return weights, fmt.Errorf("unknown preset %s", name) }
+
+	disabled := weights.Disable
+	weights.Disable = nil
+	if err := json.Unmarshal(preset, &weights); err != nil { return weights, fmt.Errorf("preset %s: %v", name, err) }
+	if err := weights.Check(); err != nil { return weights, fmt.Errorf("preset %s: %v", name, err) }
+	weights.Disable = append(append([]string(nil), disabled...), weights.Disable...)
+	return weights, nil
+}
+
+// The preset a request asks for, if any, after the tenant in its path
+func RequestPreset (r *http.Request) string {
+	if preset := r.URL.Query().Get("preset"); len(preset) > 0 { return preset }
+	tenant := RequestTenant(r)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if tenant != ourTenant { parts = parts[1:] }
+	if len(parts) > 1 {
+		if _, ok := tenant.Config.presetJSON(parts[0]); ok { return parts[0] }
+	}
+	return ""
+}
+
+// ----------------------------------------------------------------
+// Strategies
+//
+// Rules in the config for choosing a preset per game at /start,
+// tried in order.  Every condition a rule gives must hold: the
+// ruleset, where the game comes from ("tournament", "league",
+// "arena", "challenge" or "custom"), the board size as "WxH", the
+// number of snakes, and a pattern that some opponent's name must
+// match.
+//
+//   "strategies": [
+//     { "source": "league", "preset": "hunter" },
+//     { "opponent": "(?i)hungry", "preset": "troll" },
+//     { "ruleset": "standard", "size": "19x19", "preset": "cautious" },
+//     { "minSnakes": 5, "preset": "greedy" }
+//   ]
+// ----------------------------------------------------------------
+
+// Where games come from, as the engine gives it
+var gameSources = []string { "tournament", "league", "arena", "challenge", "custom" }
+
+func knownSource (source string) bool {
+	for _,s := range gameSources {
+		if s == source { return true }
+	}
+	return false
+}
+
+type StrategyRule struct {
+	Ruleset		string	`json:"ruleset"`
+	Source		string	`json:"source"`
+	Size		string	`json:"size"`
+	MinSnakes	int		`json:"minSnakes"`
+	MaxSnakes	int		`json:"maxSnakes"`
+	Opponent	string	`json:"opponent"`
+	Preset		string	`json:"preset"`
+	re			*regexp.Regexp
+}
+
+func (rule StrategyRule) Matches (g Game, b Board, you string) bool {
+	if len(rule.Ruleset) > 0 && rule.Ruleset != g.Ruleset.Name { return false }
+	if len(rule.Source) > 0 && rule.Source != g.Source { return false }
+	if len(rule.Size) > 0 && rule.Size != fmt.Sprintf("%dx%d", b.Width, b.Height) { return false }
+	if rule.MinSnakes > 0 && len(b.Snakes) < rule.MinSnakes { return false }
+	if rule.MaxSnakes > 0 && len(b.Snakes) > rule.MaxSnakes { return false }
+	if rule.re != nil {
+		matched := false
+		for _,snake := range b.Snakes {
+			if snake.ID != you && rule.re.MatchString(snake.Name) { matched = true }
+		}
+		if !matched { return false }
+	}
+	return true
+}
+
+// The preset the config chooses for a game, if any
+func (c Config) GamePreset (g Game, b Board, you string) string {
+	for _,rule := range c.Strategies {
+		if rule.Matches(g, b, you) { return rule.Preset }
+	}
+	return c.Preset
+}
+
+// Serve the snake under the path of each preset
+func HandlePresets () {
+	for _,name := range PresetNames() {
+		http.HandleFunc("/" + name + "/start", EngineOnly(HandleStart))
+		http.HandleFunc("/" + name + "/move", EngineOnly(HandleMove))
+		http.HandleFunc("/" + name + "/end", EngineOnly(HandleEnd))
+	}
+}
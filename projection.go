@@ -0,0 +1,65 @@
+package main
+
+// ----------------------------------------------------------------
+// Health Projection
+//
+// Our health over the next projectionTurns turns, turn by turn,
+// counting a point a turn, the damage of any hazard we are in until
+// we can get out, and the food we are heading for if we get there
+// first.  Projected without eating, it tells us how many turns we
+// have before we starve.  Once a food run takes nearly all of those,
+// we set off for the food however well fed we seem, so that we start
+// as late as is safe but never later.
+// ----------------------------------------------------------------
+
+const projectionTurns = 15
+
+// Turns to spare between reaching food and starving
+const starvationMargin = 3
+
+// The food we would head for, if any
+func (s *GameState) PlannedFood () (FoodState, bool) {
+	for _,food := range s.food {
+		if food.closerSnakes == 0 && !food.claimed && s.FoodWorthIt(food) { return food, true }
+	}
+	return FoodState{}, false
+}
+
+// Our projected health after each of the next projectionTurns turns
+func (s *GameState) ProjectHealth (eat bool) [projectionTurns]int {
+	health := s.snakes[0].health
+	hazard := 0
+	if run := s.HazardRun(s.snakes[0].head); run > 1 { hazard = run - 1 }
+
+	food, planned := s.PlannedFood()
+	planned = planned && eat
+
+	var projection [projectionTurns]int
+	for turn := 1; turn <= projectionTurns; turn++ {
+		health--
+		inFood := planned && food.hazardRun > 0 && turn > food.dist - food.hazardRun && turn < food.dist + food.hazardRun
+		if turn <= hazard || inFood { health -= hazardDamage }
+		if planned && turn == food.dist && health > 0 { health = 100 }
+		if health < 0 { health = 0 }
+		projection[turn-1] = health
+	}
+	return projection
+}
+
+// Turns until we starve if we don't eat, or more than projectionTurns
+// if that is beyond the projection
+func (s *GameState) TurnsToStarvation () int {
+	for turn,health := range s.ProjectHealth(false) {
+		if health <= 0 { return turn+1 }
+	}
+	return projectionTurns+1
+}
+
+// Is it time to set off for food?
+func (s *GameState) MustEat () bool {
+	food, ok := s.PlannedFood()
+	if !ok { return false }
+	starve := s.TurnsToStarvation()
+	s.debug.Printf("Health projection %v, starving in %d turns, food %d away\n", s.ProjectHealth(true), starve, food.dist)
+	return starve <= food.dist + starvationMargin
+}
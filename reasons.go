@@ -0,0 +1,59 @@
+package main
+
+// ----------------------------------------------------------------
+// Reasons
+//
+// Every move is made for one of a fixed set of reasons, logged with
+// the move and recorded for each turn of a game, so that the archive
+// can tell us how often we move for food, for space, because of a
+// threat and so on.  Each reason belongs to one such category.
+// ----------------------------------------------------------------
+
+type Reason string
+
+const (
+	ReasonOpening			Reason = "opening"				// the first move, toward the nearest food
+	ReasonSuicide			Reason = "suicide"				// there was no move that doesn't kill us
+	ReasonSelfEnclosed		Reason = "self-enclosed"		// every space is closed off by our own body
+	ReasonLargestSmallSpace	Reason = "largest-small-space"	// every space is too small, so the largest
+	ReasonLeastThreatened	Reason = "least-threatened"		// every move is threatened, so the least
+	ReasonFood				Reason = "food"					// food right next to us
+	ReasonAttack			Reason = "attack"				// a shorter snake's head to take
+	ReasonEdgeTrap			Reason = "edge-trap"			// keeping a snake pinned to the wall
+	ReasonForcing			Reason = "forcing"				// leaving a snake only losing replies
+	ReasonSqueeze			Reason = "squeeze"				// the only move, though it squeezes us
+	ReasonSqueezed			Reason = "squeezed"				// every open move squeezes us
+	ReasonFoodProgress		Reason = "food-progress"		// the best progress toward food
+	ReasonAvoidLonger		Reason = "avoid-longer"			// away from longer snakes
+	ReasonCompact			Reason = "compact"				// keeping our body coiled
+	ReasonRollout			Reason = "rollout"				// rollouts overruled the heuristics
+	ReasonInfluence			Reason = "influence"			// the safe move owning the most cells
+	ReasonWatchdog			Reason = "watchdog"				// we ran out of time
+	ReasonCaution			Reason = "caution"				// unsure in a game that matters, into the most space
+)
+
+var reasonCategories = map[Reason]string {
+	ReasonOpening:				"food",
+	ReasonFood:					"food",
+	ReasonFoodProgress:			"food",
+	ReasonSelfEnclosed:			"space",
+	ReasonLargestSmallSpace:	"space",
+	ReasonCompact:				"space",
+	ReasonInfluence:			"space",
+	ReasonCaution:				"space",
+	ReasonLeastThreatened:		"threat",
+	ReasonSqueeze:				"threat",
+	ReasonSqueezed:				"threat",
+	ReasonAvoidLonger:			"threat",
+	ReasonAttack:				"aggression",
+	ReasonEdgeTrap:				"aggression",
+	ReasonForcing:				"aggression",
+	ReasonRollout:				"search",
+	ReasonSuicide:				"fallback",
+	ReasonWatchdog:				"fallback",
+}
+
+func (r Reason) Category () string {
+	if category, ok := reasonCategories[r]; ok { return category }
+	return "unknown"
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Game Records
+//
+// Games are recorded as a list of frames in the same JSON format
+// the Battlesnake engine serves from /games/{id}/frames, so that the
+// board viewer can play them back.  Snakes stay in every frame once
+// they have been eliminated, marked with how they died.
+//
+// Live games are recorded when RECORD_DIR is set, one file per game
+// written at /end, and the server then serves them to admins, or to
+// the viewer if the protection has "publicGames" set:
+//
+//   https://board.battlesnake.com/?engine=http://localhost:8080&game=<id>
+// ----------------------------------------------------------------
+
+type FrameCoord struct {
+	X	int
+	Y	int
+}
+
+type FrameDeath struct {
+	Cause			string
+	Turn			int
+	EliminatedBy	string
+}
+
+type FrameSnake struct {
+	ID			string
+	Name		string
+	Body		[]FrameCoord
+	Health		int
+	Death		*FrameDeath
+	Color		string
+	HeadType	string
+	TailType	string
+	Latency		string
+	Shout		string
+	Squad		string
+}
+
+type Frame struct {
+	Turn	int
+	Snakes	[]FrameSnake
+	Food	[]FrameCoord
+	Hazards	[]FrameCoord
+}
+
+type RecordedGame struct {
+	ID				string
+	Status			string
+	Width			int
+	Height			int
+	Ruleset			map[string]string
+	SnakeTimeout	int
+	Source			string		`json:",omitempty"`
+}
+
+type GameRecord struct {
+	Schema		int				`json:",omitempty"`		// version of the record format
+	Game	RecordedGame
+	You			string			`json:",omitempty"`		// which snake is ours
+	Build		*BuildInfo		`json:",omitempty"`		// the code and config that played it
+	Frames		[]Frame
+	Features	[]TurnFeatures	`json:",omitempty"`		// what we weighed up on each turn
+	Moves		map[int]string	`json:",omitempty"`		// our move on each turn
+	Reasons		map[int]Reason	`json:",omitempty"`		// why we made our move on each turn
+	Disagreements	[]Disagreement	`json:",omitempty"`	// turns the rollouts preferred another move
+	Blunders	[]Blunder		`json:",omitempty"`		// the worst of our moves, if we lost
+	Compacted	bool			`json:",omitempty"`		// only some frames are kept
+	Audits		map[int]DecisionAudit	`json:",omitempty"`	// what each of our decisions was made from
+	Experiments	map[string]bool	`json:",omitempty"`		// whether we played in each experiment that could run
+}
+
+func NewGameRecord (g Game, b Board) *GameRecord {
+	build := Build()
+	return &GameRecord {
+		Schema: recordSchema,
+		Game: RecordedGame {
+			ID:				g.ID,
+			Status:			"running",
+			Width:			b.Width,
+			Height:			b.Height,
+			Ruleset:		map[string]string { "name": g.Ruleset.Name },
+			SnakeTimeout:	500,
+			Source:			g.Source,
+		},
+		Build: &build,
+	}
+}
+
+func FrameCoords (coords []Coord) []FrameCoord {
+	fc := make([]FrameCoord, len(coords))
+	for i,c := range coords { fc[i] = FrameCoord { c.X, c.Y } }
+	return fc
+}
+
+// Add a frame for the board at turn t.  Snakes missing from the board
+// have been eliminated, and we take the cause from deaths if we know it.
+func (r *GameRecord) AddFrame (t int, b Board, deaths []Elimination) {
+	// The engine may ask for the same turn twice, so keep the latest
+	if n := len(r.Frames); n > 0 && r.Frames[n-1].Turn == t {
+		r.Frames = r.Frames[:n-1]
+	}
+
+	frame := Frame {
+		Turn:		t,
+		Food:		FrameCoords(b.Food),
+		Hazards:	FrameCoords(b.Hazards),
+	}
+
+	// Snakes keep the colour they were given in the first frame
+	colors := make(map[string]string)
+	if len(r.Frames) > 0 {
+		for _,snake := range r.Frames[0].Snakes { colors[snake.ID] = snake.Color }
+	}
+
+	alive := make(map[string]bool)
+	for sx,snake := range b.Snakes {
+		alive[snake.ID] = true
+		color, ok := colors[snake.ID]
+		if !ok { color = snakeColors[sx % len(snakeColors)].hexcode }
+		frame.Snakes = append(frame.Snakes, FrameSnake {
+			ID:		snake.ID,
+			Name:	snake.Name,
+			Body:	FrameCoords(snake.Body),
+			Health:	snake.Health,
+			Color:	color,
+			Shout:	snake.Shout,
+			Latency:	snake.Latency,
+		})
+	}
+
+	if n := len(r.Frames); n > 0 {
+		for _,snake := range r.Frames[n-1].Snakes {
+			if alive[snake.ID] { continue }
+			if snake.Death == nil {
+				snake.Death = &FrameDeath { Cause: "eliminated", Turn: t }
+				for _,e := range deaths {
+					if e.ID == snake.ID {
+						snake.Death = &FrameDeath { e.Cause, e.Turn, e.By }
+					}
+				}
+			}
+			frame.Snakes = append(frame.Snakes, snake)
+		}
+	}
+
+	r.Frames = append(r.Frames, frame)
+}
+
+func (r *GameRecord) Write (path string) error {
+	r.Game.Status = "complete"
+	data, err := json.Marshal(r)
+	if err != nil { return err }
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func ReadGameRecord (path string) (*GameRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil { return nil, err }
+
+	r := new(GameRecord)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := r.Migrate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+// ----------------------------------------------------------------
+// Record Versions
+//
+// Records written by older binaries are brought up to date as they
+// are read, so that the replay and regression tools only ever see
+// the current format.  Each migration takes a record from one
+// version to the next:
+//
+//   0  records from before they were versioned, which may lack the
+//      ruleset name
+//   1  the current format
+//
+// Feature vectors may be shorter than FeatureNames, since features
+// are only ever added at the end, and are padded with zeros whatever
+// the version.  Records from newer binaries are refused.
+// ----------------------------------------------------------------
+
+const recordSchema = 1
+
+var recordMigrations = []func(r *GameRecord) {
+	0: func(r *GameRecord) {
+		if r.Game.Ruleset == nil { r.Game.Ruleset = make(map[string]string) }
+		if len(r.Game.Ruleset["name"]) == 0 { r.Game.Ruleset["name"] = "standard" }
+	},
+}
+
+func (r *GameRecord) Migrate () error {
+	if r.Schema > recordSchema {
+		return fmt.Errorf("recorded with schema %d, but we only understand up to %d", r.Schema, recordSchema)
+	}
+	for ; r.Schema < recordSchema; r.Schema++ { recordMigrations[r.Schema](r) }
+
+	for _,turn := range r.Features {
+		for mx,move := range turn.Moves {
+			for len(move.Features) < len(FeatureNames) { move.Features = append(move.Features, 0) }
+			turn.Moves[mx] = move
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------
+// Recording live games
+// ----------------------------------------------------------------
+
+func RecordDir () string {
+	return os.Getenv("RECORD_DIR")
+}
+
+func RecordPath (gameID string) string {
+	return filepath.Join(RecordDir(), filepath.Base(gameID) + ".json")
+}
+
+// Record the board at turn t, along with the move we are making, if any.
+// We keep the record of every game so we know how it ended, but only 
+// write it out if RECORD_DIR is set.
+func RecordFrame (id string, g Game, t int, b Board, move string, reason Reason) {
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok { return }
+	if context.record == nil {
+		context.record = NewGameRecord(g, b)
+		context.record.You = id
+		context.record.Experiments = context.experiments
+	}
+
+	var deaths []Elimination
+	if n := len(context.record.Frames); n > 0 {
+		deaths = InferDeaths(context.record.Frames[n-1], t, b, id, context.lastMove)
+	}
+	context.record.AddFrame(t, b, deaths)
+	context.lastMove = move
+	if len(move) > 0 {
+		if context.record.Moves == nil { context.record.Moves = make(map[int]string) }
+		context.record.Moves[t] = move
+	}
+	if len(reason) > 0 {
+		if context.record.Reasons == nil { context.record.Reasons = make(map[int]Reason) }
+		context.record.Reasons[t] = reason
+	}
+}
+
+// Summarize the outcome of the game so far
+func GameOutcome (id string) (*GameRecord, GameSummary, bool) {
+	gameContext.RLock()
+	defer gameContext.RUnlock()
+	context, ok := gameContext.m[id]
+	if !ok || context.record == nil { return nil, GameSummary{}, false }
+	return context.record, Summarize(context.record), true
+}
+
+func SaveRecord (id string) {
+	gameContext.RLock()
+	context, ok := gameContext.m[id]
+	gameContext.RUnlock()
+	if !ok || context.record == nil { return }
+	tenant := context.Tenant()
+	if len(tenant.RecordDir()) == 0 { return }
+
+	if err := context.record.Write(tenant.RecordPath(context.record.Game.ID)); err != nil {
+		fmt.Printf("ERROR: Unable to record game: %v\n", err)
+	}
+}
+
+// ----------------------------------------------------------------
+// Serving recorded games to the board viewer
+// ----------------------------------------------------------------
+
+func HandleGames (w http.ResponseWriter, r *http.Request) {
+	tenant := RequestTenant(r)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if tenant != ourTenant { parts = parts[1:] }
+	if len(tenant.RecordDir()) == 0 || len(parts) < 2 || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	record, err := ReadGameRecord(tenant.RecordPath(parts[1]))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if len(parts) == 2 {
+		var last *Frame
+		if n := len(record.Frames); n > 0 { last = &record.Frames[n-1] }
+		json.NewEncoder(w).Encode(struct {
+			Game		RecordedGame
+			LastFrame	*Frame
+		} { record.Game, last })
+		return
+	}
+
+	frames := record.Frames
+	if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && offset > 0 {
+		if offset > len(frames) { offset = len(frames) }
+		frames = frames[offset:]
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(frames) {
+		frames = frames[:limit]
+	}
+	json.NewEncoder(w).Encode(struct {
+		Count	int
+		Frames	[]Frame
+	} { len(frames), frames })
+}
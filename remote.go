@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// ----------------------------------------------------------------
+// Remote Snakes
+//
+// Any Battlesnake server can take part in our simulated games, by
+// giving its URL as a participant, so we can measure ourselves
+// against open source snakes running locally:
+//
+//   spacey-snake tournament -games 50 default http://localhost:8000
+//
+// The server is sent /start, /move and /end as an engine would, with
+// the boards from our simulator turned to the official API's y axis,
+// which runs up the board, as one of our own servers tells from the
+// request.  A move that fails or takes longer than
+// the game's timeout keeps the snake going the way it was, as the
+// engine does, and is counted against it.  A grpc:// URL is played
+// over gRPC instead.
+// ----------------------------------------------------------------
+
+const remoteTimeout = 500
+
+type RemoteSnake struct {
+	URL			*url.URL
+	client		*http.Client
+	conn		*grpc.ClientConn	// for a grpc:// URL
+	failures	int64		// moves that failed or timed out
+}
+
+func NewRemoteSnake (spec string) (*RemoteSnake, error) {
+	u, err := url.Parse(spec)
+	if err != nil { return nil, err }
+	remote := &RemoteSnake {
+		URL:	u,
+		client:	&http.Client { Timeout: remoteTimeout * time.Millisecond },
+	}
+	if IsGRPC(spec) {
+		if remote.conn, err = DialGRPC(u.Host); err != nil { return nil, err }
+	}
+	return remote, nil
+}
+
+// The URL of one of the server's endpoints
+func (remote *RemoteSnake) Endpoint (endpoint string) string {
+	u := *remote.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + endpoint
+	return u.String()
+}
+
+func IsRemote (spec string) bool {
+	return strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") || IsGRPC(spec)
+}
+
+// A request as the engine would send it, with the fields our own
+// simulator leaves out filled in
+func RemoteRequest (g Game, t int, b Board, you Snake) MoveRequest {
+	g.Timeout = remoteTimeout
+	b = CopyBoard(b)
+	you.Body = append([]Coord(nil), you.Body...)
+	Quirks { FlipY: true }.Apply(&b, &you)
+	for i := range b.Snakes {
+		snake := &b.Snakes[i]
+		if len(snake.Body) > 0 { snake.Head = &snake.Body[0] }
+		snake.Length = len(snake.Body)
+		if snake.ID == you.ID { you = *snake }
+	}
+	return MoveRequest { g, t, b, you }
+}
+
+// Send a request to one of the server's endpoints, decoding its
+// answer into reply unless that is nil
+func (remote *RemoteSnake) call (endpoint string, request MoveRequest, reply interface{}) error {
+	if remote.conn != nil {
+		if reply == nil { reply = &struct{}{} }
+		return InvokeGRPC(remote.conn, endpoint, remote.URL.Query(), remoteTimeout * time.Millisecond, &request, reply)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil { return err }
+	r, err := http.NewRequest(http.MethodPost, remote.Endpoint(endpoint), bytes.NewReader(body))
+	if err != nil { return err }
+	r.Header.Set("Content-Type", "application/json")
+	if len(engineSecret) > 0 { r.Header.Set("X-Engine-Secret", engineSecret) }
+	response, err := remote.client.Do(r)
+	if err != nil { return err }
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK { return fmt.Errorf("%s returned %s", remote.Endpoint(endpoint), response.Status) }
+	if reply == nil { return nil }
+	return json.NewDecoder(response.Body).Decode(reply)
+}
+
+func (remote *RemoteSnake) Start (g Game, t int, b Board, you Snake) error {
+	return remote.call("/start", RemoteRequest(g, t, b, you), nil)
+}
+
+func (remote *RemoteSnake) Move (g Game, t int, b Board, you Snake) string {
+	var move MoveResponse
+	err := remote.call("/move", RemoteRequest(g, t, b, you), &move)
+	if err == nil {
+		switch move.Move {
+		case "up", "down", "left", "right":	return move.Move
+		}
+		err = fmt.Errorf("%s answered %q", remote.Endpoint("/move"), move.Move)
+	}
+	if atomic.AddInt64(&remote.failures, 1) == 1 { fmt.Fprintf(os.Stderr, "%v\n", err) }
+	return CurrentDirection(you)
+}
+
+func (remote *RemoteSnake) Failures () int64 { return atomic.LoadInt64(&remote.failures) }
+
+func (remote *RemoteSnake) End (g Game, t int, b Board, you Snake) {
+	remote.call("/end", RemoteRequest(g, t, b, you), nil)
+}
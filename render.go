@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Board Rendering
+//
+// Draw a board as text, one character per cell with y increasing
+// down the screen to match the coordinates we play in.  Each snake
+// gets a letter, upper case for its head and lower case for the 
+// rest of its body.  Food is '*' and empty hazard cells are '~'.
+// ----------------------------------------------------------------
+
+func RenderBoard (b Board) string {
+	cells := make([][]byte, b.Height)
+	for y := range cells {
+		cells[y] = []byte(strings.Repeat(".", b.Width))
+	}
+	inside := func (c Coord) bool {
+		return c.X >= 0 && c.X < b.Width && c.Y >= 0 && c.Y < b.Height
+	}
+
+	for _,hazard := range b.Hazards {
+		if inside(hazard) { cells[hazard.Y][hazard.X] = '~' }
+	}
+	for _,food := range b.Food {
+		if inside(food) { cells[food.Y][food.X] = '*' }
+	}
+	for sx,snake := range b.Snakes {
+		for i := len(snake.Body)-1; i >= 0; i-- {
+			segment := snake.Body[i]
+			if !inside(segment) { continue }
+			if i == 0 {
+				cells[segment.Y][segment.X] = byte('A' + sx % 26)
+			} else {
+				cells[segment.Y][segment.X] = byte('a' + sx % 26)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for _,row := range cells {
+		sb.Write(row)
+		sb.WriteByte('\n')
+	}
+	for sx,snake := range b.Snakes {
+		fmt.Fprintf(&sb, "%c: %s health=%d length=%d\n", 'A' + sx % 26, 
+					snake.ID, snake.Health, len(snake.Body))
+	}
+	return sb.String()
+}
+
+// ----------------------------------------------------------------
+// ANSI Rendering
+//
+// The same board drawn in colour for terminals, two characters per
+// cell so the board comes out roughly square.  Heads are drawn as
+// an arrow in the direction the snake is about to move, if known,
+// and tails as a dot.  Hazards are shaded.  Writing ClearScreen 
+// before each board redraws it in place.
+// ----------------------------------------------------------------
+
+const ClearScreen = "\033[H\033[2J"
+
+const (
+	ansiReset	= "\033[0m"
+	ansiHazard	= "\033[48;5;238m"
+	ansiFood	= "\033[1;31m"
+	ansiEmpty	= "\033[2m"
+)
+
+var ansiSnakeColors = []string {
+	"\033[1;32m", "\033[1;34m", "\033[1;33m", "\033[1;35m", "\033[1;36m", "\033[1;37m",
+}
+
+var moveArrows = map[string]string {
+	"left": "<", "right": ">", "up": "^", "down": "v",
+}
+
+func RenderBoardANSI (b Board, moves map[string]string) string {
+	type cell struct {
+		text	string
+		color	string
+	}
+	cells := make([][]cell, b.Height)
+	for y := range cells {
+		cells[y] = make([]cell, b.Width)
+		for x := range cells[y] { cells[y][x] = cell { ".", ansiEmpty } }
+	}
+	hazards := make(map[Coord]bool)
+	for _,hazard := range b.Hazards { hazards[hazard] = true }
+	inside := func (c Coord) bool {
+		return c.X >= 0 && c.X < b.Width && c.Y >= 0 && c.Y < b.Height
+	}
+
+	for _,food := range b.Food {
+		if inside(food) { cells[food.Y][food.X] = cell { "*", ansiFood } }
+	}
+	for sx,snake := range b.Snakes {
+		color := ansiSnakeColors[sx % len(ansiSnakeColors)]
+		for i := len(snake.Body)-1; i >= 0; i-- {
+			segment := snake.Body[i]
+			if !inside(segment) { continue }
+			text := "o"
+			switch {
+			case i == 0:
+				text = "@"
+				if arrow, ok := moveArrows[moves[snake.ID]]; ok { text = arrow }
+			case i == len(snake.Body)-1:
+				text = "."
+			}
+			cells[segment.Y][segment.X] = cell { text, color }
+		}
+	}
+
+	var sb strings.Builder
+	for y,row := range cells {
+		for x,c := range row {
+			if hazards[Coord{ x, y }] { sb.WriteString(ansiHazard) }
+			sb.WriteString(c.color)
+			sb.WriteString(c.text)
+			sb.WriteString(" ")
+			sb.WriteString(ansiReset)
+		}
+		sb.WriteByte('\n')
+	}
+	for sx,snake := range b.Snakes {
+		color := ansiSnakeColors[sx % len(ansiSnakeColors)]
+		fmt.Fprintf(&sb, "%s%s%s health=%d length=%d %s\n", color, snake.ID, ansiReset,
+					snake.Health, len(snake.Body), moves[snake.ID])
+	}
+	return sb.String()
+}
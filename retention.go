@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Retention
+//
+// A season of recorded games adds up, and most of them are casual
+// wins we will never look at again.  The config says how long to
+// keep those in full, and how much of them to keep after that:
+//
+//   "retention": { "days": 30, "every": 10 }
+//
+// Past that age, the record of a win outside a tournament or league
+// keeps only every 10th frame, along with the first and last, and
+// loses what we weighed up on each turn, the disagreements and the
+// metrics beside it.  Its moves, reasons and outcome are kept, so it
+// still counts in reports.  Losses, draws and tournament games are
+// always kept in full.  Without "days", nothing is compacted.
+//
+// The server compacts the archive once a day, and
+//
+//   spacey-snake games compact [-n]
+//
+// does it straight away, or with -n lists what it would compact.
+// ----------------------------------------------------------------
+
+type Retention struct {
+	Days	int		`json:"days"`		// how long to keep casual wins in full
+	Every	int		`json:"every"`		// and which frames to keep after that
+}
+
+// Sources whose games are always kept in full
+var retainedSources = map[string]bool { "tournament": true, "league": true }
+
+const compactionInterval = 24 * time.Hour
+
+// Should a record played at the given time be compacted now?
+func (retention Retention) Expired (r *GameRecord, played time.Time) bool {
+	if retention.Days <= 0 || r.Compacted || retainedSources[r.Game.Source] { return false }
+	if Summarize(r).Result != "win" { return false }
+	return time.Since(played) > time.Duration(retention.Days) * 24 * time.Hour
+}
+
+// Keep only every nth frame, and the first and last, and drop what
+// we weighed up on each turn
+func (r *GameRecord) Compact (every int) {
+	if every < 1 { every = 1 }
+	var frames []Frame
+	for fx,frame := range r.Frames {
+		if fx % every == 0 || fx == len(r.Frames)-1 { frames = append(frames, frame) }
+	}
+	r.Frames = frames
+	r.Features = nil
+	r.Disagreements = nil
+	r.Audits = nil
+	r.Compacted = true
+}
+
+// Compact every expired record in an archive, returning the IDs of
+// the games compacted
+func CompactArchive (dir string, retention Retention, dryRun bool) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil { return nil, err }
+
+	var compacted []string
+	for _,path := range paths {
+		info, err := os.Stat(path)
+		if err != nil { return compacted, err }
+		r, err := ReadArchivedGame(path)
+		if err != nil { continue }		// not ours to compact
+		if !retention.Expired(r, info.ModTime()) { continue }
+		compacted = append(compacted, r.Game.ID)
+		if dryRun { continue }
+
+		// The record keeps its time, which is when the game was played
+		r.Compact(retention.Every)
+		if err := r.Write(path); err != nil { return compacted, err }
+		if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil { return compacted, err }
+		metrics := strings.TrimSuffix(path, ".json") + ".csv"
+		if err := os.Remove(metrics); err != nil && !os.IsNotExist(err) { return compacted, err }
+	}
+	return compacted, nil
+}
+
+// Compact each tenant's archive now and then for as long as the
+// server runs
+func StartCompaction () {
+	for _,t := range Tenants() { t.StartCompaction() }
+}
+
+func (t *Tenant) StartCompaction () {
+	retention := t.Config.Retention
+	dir := t.RecordDir()
+	if retention.Days <= 0 || len(dir) == 0 { return }
+	go func() {
+		for {
+			compacted, err := CompactArchive(dir, retention, false)
+			if err != nil {
+				fmt.Printf("ERROR: Unable to compact archive: %v\n", err)
+			} else if len(compacted) > 0 {
+				fmt.Printf("INFO: Compacted %d recorded games\n", len(compacted))
+			}
+			time.Sleep(compactionInterval)
+		}
+	}()
+}
+
+func CompactCommand (dir string, args []string) int {
+	flags := flag.NewFlagSet("games compact", flag.ExitOnError)
+	dryRun := flags.Bool("n", false, "list the games that would be compacted")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake games compact [-n]\n")
+		return 2
+	}
+	if config.Retention.Days <= 0 {
+		fmt.Fprintf(os.Stderr, "No retention is configured\n")
+		return 1
+	}
+
+	compacted, err := CompactArchive(dir, config.Retention, *dryRun)
+	for _,id := range compacted { fmt.Println(id) }
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
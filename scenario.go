@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Scenarios
+//
+// A scenario is a position to play forward in the simulator, with
+// an optional script for the opponents.  It is a JSON file like:
+//
+//   {
+//     "name": "pinned on the left wall",
+//     "ruleset": "standard",
+//     "width": 11, "height": 11,
+//     "turn": 20,
+//     "you": "me",
+//     "snakes": [ { "id": "me", "health": 90, "body": [ ... ] }, ... ],
+//     "food": [ { "x": 5, "y": 5 } ],
+//     "moves": { "them": [ "up", "up", "left" ] },
+//     "spawns": { "3": [ { "x": 1, "y": 1 } ] },
+//     "turns": 10,
+//     "expect": { "survive": true, "eliminated": [ "them" ], "moves": [ "up" ], "avoid": [ "left" ] }
+//   }
+//
+// Scripted snakes follow their list of moves, one per turn, and
+// keep going straight once it runs out.  Every other snake, ours
+// included, is moved by the engine.  Food is only spawned where the
+// script says, keyed by the number of turns into the scenario.
+//
+// The optional expectations say what counts as passing: whether we
+// must survive, which snakes must be eliminated along the way, and
+// which first moves are acceptable or not.  Without them, a scenario
+// passes if we survive it.
+//
+// A scenario is checked as it is loaded: every snake needs an ID of
+// its own and a body of connected cells on the board, food, hazards
+// and spawns have to be on the board, and the script and the
+// expectations can only name snakes in the scenario and moves there
+// are.
+// ----------------------------------------------------------------
+
+var scenarioMoves = map[string]bool { "up": true, "down": true, "left": true, "right": true }
+
+type Scenario struct {
+	Name	string				`json:"name"`
+	Ruleset	string				`json:"ruleset"`
+	Width	int					`json:"width"`
+	Height	int					`json:"height"`
+	Turn	int					`json:"turn"`
+	You		string				`json:"you"`
+	Snakes	[]Snake				`json:"snakes"`
+	Food	[]Coord				`json:"food"`
+	Hazards	[]Coord				`json:"hazards"`
+	Moves	map[string][]string	`json:"moves"`
+	Spawns	map[int][]Coord		`json:"spawns"`
+	Turns	int					`json:"turns"`
+	Expect	*ScenarioExpect		`json:"expect,omitempty"`
+}
+
+type ScenarioExpect struct {
+	Survive		bool		`json:"survive"`
+	Eliminated	[]string	`json:"eliminated"`
+	Moves		[]string	`json:"moves"`
+	Avoid		[]string	`json:"avoid,omitempty"`
+}
+
+func LoadScenario (path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil { return nil, err }
+
+	// Scenarios are written by hand, so catch misspelt fields
+	sc := new(Scenario)
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(sc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if sc.Width == 0 { sc.Width = 11 }
+	if sc.Height == 0 { sc.Height = 11 }
+	if sc.Turns == 0 { sc.Turns = 1 }
+	if len(sc.You) == 0 && len(sc.Snakes) > 0 { sc.You = sc.Snakes[0].ID }
+	for i := range sc.Snakes {
+		if len(sc.Snakes[i].Name) == 0 { sc.Snakes[i].Name = sc.Snakes[i].ID }
+	}
+	if err := sc.Validate(); err != nil { return nil, fmt.Errorf("%s: %v", path, err) }
+	return sc, nil
+}
+
+// Can the scenario be played as it is written?
+func (sc *Scenario) Validate () error {
+	if sc.Width <= 0 || sc.Height <= 0 { return fmt.Errorf("board is %dx%d", sc.Width, sc.Height) }
+	if len(sc.Snakes) == 0 { return fmt.Errorf("no snakes") }
+	onBoard := func (c Coord) bool { return c.X >= 0 && c.X < sc.Width && c.Y >= 0 && c.Y < sc.Height }
+
+	ids := make(map[string]bool)
+	for _,snake := range sc.Snakes {
+		if len(snake.ID) == 0 { return fmt.Errorf("snake without an id") }
+		if ids[snake.ID] { return fmt.Errorf("two snakes with id %s", snake.ID) }
+		ids[snake.ID] = true
+		if len(snake.Body) == 0 { return fmt.Errorf("snake %s has no body", snake.ID) }
+		for i,c := range snake.Body {
+			if !onBoard(c) { return fmt.Errorf("snake %s is off the board at %v", snake.ID, c) }
+			if i > 0 && ManDist(c, snake.Body[i-1]) > 1 { return fmt.Errorf("snake %s has a gap in its body at %v", snake.ID, c) }
+		}
+	}
+	if !ids[sc.You] { return fmt.Errorf("no snake %s to play as", sc.You) }
+
+	cells := append(append([]Coord(nil), sc.Food...), sc.Hazards...)
+	for _,spawn := range sc.Spawns { cells = append(cells, spawn...) }
+	for _,c := range cells {
+		if !onBoard(c) { return fmt.Errorf("food or hazard off the board at %v", c) }
+	}
+
+	for id,moves := range sc.Moves {
+		if !ids[id] { return fmt.Errorf("moves for unknown snake %s", id) }
+		for _,move := range moves {
+			if !scenarioMoves[move] { return fmt.Errorf("snake %s has an unknown move %q", id, move) }
+		}
+	}
+	if expect := sc.Expect; expect != nil {
+		for _,id := range expect.Eliminated {
+			if !ids[id] { return fmt.Errorf("unknown snake %s expected to be eliminated", id) }
+		}
+		for _,move := range append(append([]string(nil), expect.Moves...), expect.Avoid...) {
+			if !scenarioMoves[move] { return fmt.Errorf("unknown move %q expected", move) }
+		}
+	}
+	return nil
+}
+
+// Start a simulation of the scenario, creating a game context for
+// every snake the engine moves
+func (sc *Scenario) Start () *Simulation {
+	g := Game { ID: "scenario", Ruleset: Ruleset { Name: sc.Ruleset } }
+	b := Board {
+		Height:		sc.Height,
+		Width:		sc.Width,
+		Food:		sc.Food,
+		Hazards:	sc.Hazards,
+		Snakes:		sc.Snakes,
+	}
+	sim := NewSimulation(g, sc.Turn, b)
+
+	for _,snake := range sim.Board.Snakes {
+		if !sc.Scripted(snake.ID) { NewContext(snake.ID, sim.Board) }
+	}
+	return sim
+}
+
+// Is our snake still alive, with opponents left to play against?
+func (sc *Scenario) Running (sim *Simulation) bool {
+	if _, ok := sim.Snake(sc.You); !ok { return false }
+	return len(sc.Snakes) == 1 || !sim.Over()
+}
+
+func (sc *Scenario) Scripted (id string) bool {
+	_, ok := sc.Moves[id]
+	return ok
+}
+
+// Decide the moves of every snake for the current turn, along with 
+// the engine's decisions for the snakes it moves
+func (sc *Scenario) NextMoves (sim *Simulation) (map[string]string, map[string]MoveDecision) {
+	step := sim.Turn - sc.Turn
+	moves := make(map[string]string)
+	decisions := make(map[string]MoveDecision)
+	for _,snake := range sim.Board.Snakes {
+		if script, ok := sc.Moves[snake.ID]; ok {
+			if step < len(script) { moves[snake.ID] = script[step] }
+			continue
+		}
+		decision := Think(sim.Game, sim.Turn, sim.Board, snake)
+		moves[snake.ID] = decision.Move
+		decisions[snake.ID] = decision
+		UpdateContext(snake.ID, sim.Board.Snakes, sim.Board.Food)
+	}
+	return moves, decisions
+}
+
+// Play one turn of the scenario with the given moves
+func (sc *Scenario) Apply (sim *Simulation, moves map[string]string) {
+	sim.Step(moves)
+	sim.Board.Food = append(sim.Board.Food, sc.Spawns[sim.Turn - sc.Turn]...)
+}
+
+// Summarize a turn on one line
+func FormatMoves (ids []string, moves map[string]string) string {
+	var parts []string
+	for _,id := range ids {
+		parts = append(parts, fmt.Sprintf("%s=%s", id, moves[id]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func SnakeIDs (sim *Simulation) []string {
+	ids := make([]string, 0, len(sim.Board.Snakes))
+	for _,snake := range sim.Board.Snakes { ids = append(ids, snake.ID) }
+	return ids
+}
+
+type ScenarioOptions struct {
+	Watch	bool				// draw the board each turn instead of the decision trace
+	Delay	time.Duration		// pause between turns when watching
+	Frames	string				// file to write the game to for the board viewer
+	CSV		string				// file to write our metrics for each turn to
+}
+
+// Play out a scenario, printing the engine's decision trace for
+// each turn, and check it met its expectations
+func RunScenario (sc *Scenario, opts ScenarioOptions) error {
+	sim := sc.Start()
+	fmt.Printf("Scenario: %s\n", sc.Name)
+	if opts.Watch { engineLogging = false }
+	record := NewGameRecord(sim.Game, sim.Board)
+	record.You = sc.You
+	record.AddFrame(sim.Turn, sim.Board, nil)
+	var metrics []TurnMetrics
+
+	firstMove := ""
+	for step := 0; step < sc.Turns && sc.Running(sim); step++ {
+		ids := SnakeIDs(sim)
+		moves, decisions := sc.NextMoves(sim)
+		if step == 0 { firstMove = moves[sc.You] }
+		if decision, ok := decisions[sc.You]; ok {
+			metrics = append(metrics, decision.Metrics())
+			record.AddFeatures(decision.Features())
+		}
+		if opts.Watch {
+			fmt.Print(ClearScreen + RenderBoardANSI(sim.Board, moves))
+			time.Sleep(opts.Delay)
+		}
+		sc.Apply(sim, moves)
+		record.AddFrame(sim.Turn, sim.Board, sim.Eliminated)
+
+		fmt.Printf("Turn %d: %s\n", sim.Turn-1, FormatMoves(ids, moves))
+		for _,e := range sim.Eliminated {
+			if e.Turn != sim.Turn { continue }
+			fmt.Printf("Turn %d: %s eliminated by %s %s\n", e.Turn, e.ID, e.Cause, e.By)
+		}
+	}
+
+	if len(opts.Frames) > 0 {
+		if err := record.Write(opts.Frames); err != nil {
+			fmt.Printf("ERROR: Unable to write frames: %v\n", err)
+		}
+	}
+
+	if len(opts.CSV) > 0 {
+		if err := WriteMetrics(opts.CSV, metrics); err != nil {
+			fmt.Printf("ERROR: Unable to write metrics: %v\n", err)
+		}
+	}
+
+	if _, ok := sim.Snake(sc.You); ok {
+		fmt.Printf("Scenario: %s survived to turn %d\n", sc.You, sim.Turn)
+	} else {
+		fmt.Printf("Scenario: %s did not survive\n", sc.You)
+	}
+	if err := sc.Check(sim, firstMove); err != nil {
+		fmt.Printf("Scenario: failed, %v\n", err)
+		return err
+	}
+	fmt.Printf("Scenario: passed\n")
+	return nil
+}
+
+// Did a finished simulation of the scenario meet its expectations?
+// firstMove is the move we made on the first turn.
+func (sc *Scenario) Check (sim *Simulation, firstMove string) error {
+	expect := sc.Expect
+	if expect == nil { expect = &ScenarioExpect { Survive: true } }
+
+	if _, alive := sim.Snake(sc.You); expect.Survive && !alive {
+		return fmt.Errorf("%s did not survive", sc.You)
+	}
+	for _,id := range expect.Eliminated {
+		if _, alive := sim.Snake(id); alive { return fmt.Errorf("%s was not eliminated", id) }
+	}
+	if len(expect.Moves) > 0 {
+		ok := false
+		for _,move := range expect.Moves {
+			if move == firstMove { ok = true }
+		}
+		if !ok { return fmt.Errorf("first move was %s, expected one of %s", firstMove, strings.Join(expect.Moves, ",")) }
+	}
+	for _,move := range expect.Avoid {
+		if move == firstMove { return fmt.Errorf("first move was %s, which it must not be", firstMove) }
+	}
+	return nil
+}
+
+// A copy of the scenario with every snake ID prefixed, so that it can
+// be played alongside live games without sharing their contexts
+func (sc *Scenario) Renamed (prefix string) *Scenario {
+	c := *sc
+	c.You = prefix + sc.You
+	c.Snakes = make([]Snake, len(sc.Snakes))
+	for i,snake := range sc.Snakes {
+		c.Snakes[i] = snake
+		c.Snakes[i].ID = prefix + snake.ID
+	}
+	c.Moves = make(map[string][]string)
+	for id,moves := range sc.Moves { c.Moves[prefix + id] = moves }
+	if sc.Expect != nil {
+		expect := *sc.Expect
+		expect.Eliminated = nil
+		for _,id := range sc.Expect.Eliminated { expect.Eliminated = append(expect.Eliminated, prefix + id) }
+		c.Expect = &expect
+	}
+	return &c
+}
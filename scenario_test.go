@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Scenarios that can't be played are turned away as they are loaded
+func TestValidateScenario (t *testing.T) {
+	valid := func () *Scenario {
+		return &Scenario {
+			Width: 5, Height: 5, You: "me",
+			Snakes: []Snake {
+				{ ID: "me", Health: 90, Body: []Coord{ { 1, 1 }, { 1, 2 } } },
+				{ ID: "them", Health: 90, Body: []Coord{ { 3, 3 }, { 3, 4 } } },
+			},
+			Food: []Coord{ { 0, 0 } },
+			Moves: map[string][]string { "them": { "up", "left" } },
+		}
+	}
+	tests := []struct {
+		name	string
+		change	func (sc *Scenario)
+		err		string
+	}{
+		{ "valid", func (sc *Scenario) {}, "" },
+		{ "no snakes", func (sc *Scenario) { sc.Snakes = nil }, "no snakes" },
+		{ "no body", func (sc *Scenario) { sc.Snakes[1].Body = nil }, "no body" },
+		{ "off the board", func (sc *Scenario) { sc.Snakes[1].Body[1].Y = 5 }, "off the board" },
+		{ "gap", func (sc *Scenario) { sc.Snakes[0].Body[1] = Coord{ 1, 3 } }, "gap" },
+		{ "same id", func (sc *Scenario) { sc.Snakes[1].ID = "me" }, "two snakes" },
+		{ "unknown you", func (sc *Scenario) { sc.You = "nobody" }, "play as" },
+		{ "food off the board", func (sc *Scenario) { sc.Food[0].X = -1 }, "off the board" },
+		{ "spawn off the board", func (sc *Scenario) { sc.Spawns = map[int][]Coord { 2: { { 7, 7 } } } }, "off the board" },
+		{ "unknown move", func (sc *Scenario) { sc.Moves["them"][1] = "nowhere" }, "unknown move" },
+		{ "script for nobody", func (sc *Scenario) { sc.Moves["nobody"] = []string{ "up" } }, "unknown snake" },
+		{ "expected move", func (sc *Scenario) { sc.Expect = &ScenarioExpect { Moves: []string{ "north" } } }, "unknown move" },
+	}
+	for _,test := range tests {
+		sc := valid()
+		test.change(sc)
+		err := sc.Validate()
+		switch {
+		case len(test.err) == 0 && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, expected an error about %q", test.name, err, test.err)
+		}
+	}
+}
+
+// A fixture plays forward turn by turn as its script says
+func TestStepScenario (t *testing.T) {
+	engineLogging = false
+	ResetGameContexts()
+	sc, err := LoadScenario("fixtures/edge-trap.json")
+	if err != nil { t.Fatal(err) }
+	sim := sc.Start()
+
+	firstMove := ""
+	for step := 0; step < sc.Turns && sc.Running(sim); step++ {
+		them, alive := sim.Snake("them")
+		moves, _ := sc.NextMoves(sim)
+		if step == 0 { firstMove = moves[sc.You] }
+		sc.Apply(sim, moves)
+
+		if sim.Turn != sc.Turn + step + 1 { t.Fatalf("turn %d after %d steps from %d", sim.Turn, step + 1, sc.Turn) }
+		if now, ok := sim.Snake("them"); alive && ok && step < len(sc.Moves["them"]) && now.Body[0] != Step(them.Body[0], sc.Moves["them"][step]) {
+			t.Errorf("step %d: them at %v, not where its script took it", step, now.Body[0])
+		}
+	}
+	if err := sc.Check(sim, firstMove); err != nil { t.Error(err) }
+}
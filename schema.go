@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Schema Drift
+//
+// The engine API changes from time to time, and a field we fail to
+// parse or don't know about usually means misplay rather than an
+// error.  So every request is also checked against the fields our
+// types expect, and we count, and log the first time we see them:
+//
+//   decode failures   requests we couldn't parse at all
+//   unknown fields    fields the engine sent that we don't read
+//   missing fields    fields we read that the engine didn't send
+//   coerced fields    fields sent with the wrong type, which we convert
+//   unknown rulesets  rulesets we have no idea how to play
+//
+// Checking for drift costs as much again as decoding, so it is done
+// for every /start and /end but only for the first /move of a game
+// and one in driftInterval after that, at random.  Counts are
+// exported from /metrics.
+// ----------------------------------------------------------------
+
+var knownRulesets = map[string]bool {
+	"standard": true, "solo": true, "royale": true, "squad": true,
+	"constrictor": true, "wrapped": true,
+}
+
+var schemaStats struct {
+	sync.Mutex
+	failures	map[string]int			// by endpoint
+	drift		map[[3]string]int		// by kind, endpoint and field
+	rulesets	map[string]int			// unknown rulesets by name
+	checked		map[string]bool			// games whose moves we have checked, by our snake's ID
+}
+
+func CountDrift (kind string, endpoint string, field string) {
+	schemaStats.Lock()
+	defer schemaStats.Unlock()
+	if schemaStats.drift == nil { schemaStats.drift = make(map[[3]string]int) }
+
+	key := [3]string { kind, endpoint, field }
+	if schemaStats.drift[key] == 0 {
+		fmt.Printf("WARN: schema drift endpoint=%s kind=%s field=%s\n", endpoint, kind, field)
+	}
+	schemaStats.drift[key]++
+}
+
+func CountDecodeFailure (endpoint string, err error) {
+	schemaStats.Lock()
+	defer schemaStats.Unlock()
+	if schemaStats.failures == nil { schemaStats.failures = make(map[string]int) }
+	schemaStats.failures[endpoint]++
+	fmt.Printf("WARN: decode failure endpoint=%s error=%q\n", endpoint, err.Error())
+}
+
+func CountRuleset (name string) {
+	if knownRulesets[name] { return }
+	if _, ok := config.Profiles[name]; ok { return }
+
+	schemaStats.Lock()
+	defer schemaStats.Unlock()
+	if schemaStats.rulesets == nil { schemaStats.rulesets = make(map[string]int) }
+	if schemaStats.rulesets[name] == 0 {
+		fmt.Printf("WARN: unknown ruleset name=%q\n", name)
+	}
+	schemaStats.rulesets[name]++
+}
+
+// Compare decoded JSON with the type we decode it into, reporting the
+// fields that only one of them has
+func SchemaDrift (raw interface{}, t reflect.Type, path string, report func(kind, field string)) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		SchemaDrift(raw, t.Elem(), path, report)
+
+	case reflect.Slice:
+		values, ok := raw.([]interface{})
+		if !ok { return }
+		for _,value := range values { SchemaDrift(value, t.Elem(), path, report) }
+
+	case reflect.Struct:
+		object, ok := raw.(map[string]interface{})
+		if !ok { return }
+
+		known := make(map[string]bool)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := strings.Split(field.Tag.Get("json"), ",")
+			name := tag[0]
+			if name == "-" || len(field.PkgPath) > 0 { continue }
+			if len(name) == 0 { name = field.Name }
+			known[name] = true
+
+			value, ok := object[name]
+			if !ok {
+				omitempty := len(tag) > 1 && tag[1] == "omitempty"
+				if !omitempty { report("missing", path + name) }
+				continue
+			}
+			SchemaDrift(value, field.Type, path + name + ".", report)
+		}
+
+		for name := range object {
+			if !known[name] { report("unknown", path + name) }
+		}
+	}
+}
+
+// Moves of a game for each one we check for drift, after the first
+const driftInterval = 50
+
+// Should a request be checked for drift?
+func CheckingDrift (v interface{}) bool {
+	schemaStats.Lock()
+	defer schemaStats.Unlock()
+	switch request := v.(type) {
+	case *MoveRequest:
+		if schemaStats.checked == nil { schemaStats.checked = make(map[string]bool) }
+		if !schemaStats.checked[request.You.ID] {
+			schemaStats.checked[request.You.ID] = true
+			return true
+		}
+		return rand.Intn(driftInterval) == 0
+	case *StartRequest:
+		delete(schemaStats.checked, request.You.ID)
+	case *EndRequest:
+		delete(schemaStats.checked, request.You.ID)
+	}
+	return true
+}
+
+// A request body, with the generic decoding of it that the drift
+// check, strict decoding and type conversion all look at done once,
+// when first needed
+type RequestBody struct {
+	data	[]byte
+	raw		interface{}
+	err		error
+	decoded	bool
+}
+
+func (body *RequestBody) Raw () (interface{}, error) {
+	if !body.decoded {
+		body.err = json.Unmarshal(body.data, &body.raw)
+		body.decoded = true
+	}
+	return body.raw, body.err
+}
+
+// Decode a request from the engine into v, counting anything that
+// doesn't fit
+func DecodeRequest (endpoint string, r *http.Request, v interface{}) error {
+	start := time.Now()
+	buf, err := ReadRequestBody(r)
+	if err != nil {
+		CountDecodeFailure(endpoint, err)
+		return err
+	}
+	defer ReleaseBuffer(buf)
+
+	body := &RequestBody { data: buf.Bytes() }
+	if strictDecoding { err = body.DecodeStrict(v) } else { err = json.Unmarshal(body.data, v) }
+	if _, ok := err.(*json.UnmarshalTypeError); ok && !strictDecoding { err = body.DecodeCoerced(endpoint, v) }
+	if err != nil {
+		CountDecodeFailure(endpoint, err)
+		return err
+	}
+	CountPayload(endpoint, len(body.data), time.Since(start))
+
+	if CheckingDrift(v) {
+		raw, _ := body.Raw()
+		SchemaDrift(raw, reflect.TypeOf(v), "", func (kind, field string) {
+			CountDrift(kind, endpoint, field)
+		})
+	}
+
+	PrepareRequest(v, func (request *StartRequest) Quirks { return RequestQuirks(r, request) })
+	return nil
+}
+
+// Turn a decoded request to our axes and fill in what it leaves out,
+// taking the quirks of a new game, or one we have none for, from
+// quirksOf
+func PrepareRequest (v interface{}, quirksOf func (request *StartRequest) Quirks) {
+	switch request := v.(type) {
+	case *StartRequest:
+		ForgetQuirks(request.You.ID)
+		RequestGameQuirks(request, quirksOf).Apply(&request.Board, &request.You)
+		ApplyDefaults(&request.Game, &request.Board, &request.You)
+	case *MoveRequest:
+		RequestGameQuirks((*StartRequest)(request), quirksOf).Apply(&request.Board, &request.You)
+		ApplyDefaults(&request.Game, &request.Board, &request.You)
+	case *EndRequest:
+		RequestGameQuirks((*StartRequest)(request), quirksOf).Apply(&request.Board, &request.You)
+		ApplyDefaults(&request.Game, &request.Board, &request.You)
+	}
+}
+
+// ----------------------------------------------------------------
+// Strict and Lenient Decoding
+//
+// Live games are decoded leniently: whatever is missing that we can
+// do without gets a default, so a change to the API degrades our
+// play rather than stopping it.  With STRICT_DECODING=1, as when
+// checking captured requests in CI, unknown fields and missing
+// required fields are errors instead, which keeps our wire types
+// honest about what the engine actually sends.  Required fields are
+// the ones without omitempty.
+//
+//   STRICT_DECODING=1 spacey-snake validate requests/*.json
+// ----------------------------------------------------------------
+
+var strictDecoding = os.Getenv("STRICT_DECODING") == "1"
+
+func DecodeStrict (data []byte, v interface{}) error {
+	return (&RequestBody { data: data }).DecodeStrict(v)
+}
+
+func (body *RequestBody) DecodeStrict (v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(body.data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil { return err }
+
+	raw, _ := body.Raw()
+	var missing []string
+	seen := make(map[string]bool)
+	SchemaDrift(raw, reflect.TypeOf(v), "", func (kind, field string) {
+		if kind != "missing" || seen[field] { return }
+		seen[field] = true
+		missing = append(missing, field)
+	})
+	if len(missing) > 0 { return fmt.Errorf("missing required fields: %s", strings.Join(missing, ", ")) }
+	return nil
+}
+
+func ApplyDefaults (g *Game, b *Board, you *Snake) {
+	if len(g.Ruleset.Name) == 0 { g.Ruleset.Name = "standard" }
+	if g.Timeout <= 0 { g.Timeout = defaultTimeout }
+	if b.Food == nil { b.Food = []Coord{} }
+	if b.Hazards == nil { b.Hazards = []Coord{} }
+
+	// Snakes without a body or health are dead, whatever the engine says
+	alive := b.Snakes[:0]
+	for _,snake := range b.Snakes {
+		if len(snake.Body) > 0 && snake.Health > 0 { alive = append(alive, snake) }
+	}
+	b.Snakes = alive
+
+	for i := range b.Snakes {
+		snake := &b.Snakes[i]
+		if len(snake.Name) == 0 { snake.Name = snake.ID }
+		if len(you.Body) == 0 && snake.ID == you.ID { *you = *snake }
+	}
+}
+
+// Check captured requests, strictly if STRICT_DECODING is set
+func ValidateCommand (args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake validate request.json...\n")
+		return 2
+	}
+
+	status := 0
+	for _,path := range args {
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			var request MoveRequest
+			if strictDecoding { err = DecodeStrict(data, &request) } else { err = json.Unmarshal(data, &request) }
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func WriteSchemaMetrics (w http.ResponseWriter) {
+	schemaStats.Lock()
+	defer schemaStats.Unlock()
+
+	fmt.Fprintf(w, "# HELP spacey_decode_failures_total Requests we could not decode.\n")
+	fmt.Fprintf(w, "# TYPE spacey_decode_failures_total counter\n")
+	var endpoints []string
+	for endpoint := range schemaStats.failures { endpoints = append(endpoints, endpoint) }
+	sort.Strings(endpoints)
+	for _,endpoint := range endpoints {
+		fmt.Fprintf(w, "spacey_decode_failures_total{endpoint=\"%s\"} %d\n", EscapeLabel(endpoint),
+					schemaStats.failures[endpoint])
+	}
+
+	var keys [][3]string
+	for key := range schemaStats.drift { keys = append(keys, key) }
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], " ") < strings.Join(keys[j][:], " ")
+	})
+	for _,kind := range []string { "unknown", "missing", "coerced" } {
+		name := "spacey_" + kind + "_fields_total"
+		fmt.Fprintf(w, "# HELP %s Requests with %s fields.\n# TYPE %s counter\n", name, kind, name)
+		for _,key := range keys {
+			if key[0] != kind { continue }
+			fmt.Fprintf(w, "%s{endpoint=\"%s\",field=\"%s\"} %d\n", name, EscapeLabel(key[1]),
+						EscapeLabel(key[2]), schemaStats.drift[key])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP spacey_unknown_rulesets_total Games with a ruleset we don't know.\n")
+	fmt.Fprintf(w, "# TYPE spacey_unknown_rulesets_total counter\n")
+	var rulesets []string
+	for name := range schemaStats.rulesets { rulesets = append(rulesets, name) }
+	sort.Strings(rulesets)
+	for _,name := range rulesets {
+		fmt.Fprintf(w, "spacey_unknown_rulesets_total{ruleset=\"%s\"} %d\n", EscapeLabel(name),
+					schemaStats.rulesets[name])
+	}
+}
+
+// Are we still on the board?  The engine may keep asking for moves
+// after we have been eliminated, and a bad request may put our head
+// off the board altogether.
+func OnBoard (b Board, you Snake) bool {
+	if len(you.Body) == 0 || you.Health <= 0 { return false }
+	head := you.Body[0]
+	if head.X < 0 || head.X >= b.Width || head.Y < 0 || head.Y >= b.Height { return false }
+	for _,snake := range b.Snakes {
+		if snake.ID == you.ID { return true }
+	}
+	return false
+}
+
+// The move we make when we aren't on the board: the way we were
+// going, or up if we can't tell
+func HarmlessMove (you Snake) string {
+	if len(you.Body) == 0 { return "up" }
+	return CurrentDirection(you)
+}
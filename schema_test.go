@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStrict (t *testing.T) {
+	// Every field our types have, as the engine sends them
+	you := Snake { ID: "you", Name: "you", Health: 90, Body: []Coord{ { 1, 1 }, { 1, 2 } } }
+	full, err := json.Marshal(MoveRequest {
+		Game:	Game { ID: "game", Ruleset: Ruleset { Name: "standard", Version: "v1" } },
+		Turn:	3,
+		Board:	Board { Height: 11, Width: 11, Food: []Coord{}, Hazards: []Coord{}, Snakes: []Snake{ you } },
+		You:	you,
+	})
+	if err != nil { t.Fatal(err) }
+
+	tests := []struct {
+		name	string
+		change	func (request map[string]interface{})
+		err		string
+	}{
+		{ "complete", func (request map[string]interface{}) {}, "" },
+		{ "unknown field", func (request map[string]interface{}) { request["extra"] = 1 }, "unknown field" },
+		{ "missing turn", func (request map[string]interface{}) { delete(request, "turn") }, "missing required fields: turn" },
+		{ "missing nested", func (request map[string]interface{}) {
+			delete(request["board"].(map[string]interface{}), "hazards")
+		}, "board.hazards" },
+		{ "optional left out", func (request map[string]interface{}) {
+			delete(request["game"].(map[string]interface{}), "timeout")
+		}, "" },
+		{ "wrong type", func (request map[string]interface{}) { request["turn"] = "3" }, "cannot unmarshal" },
+	}
+	for _,test := range tests {
+		var request map[string]interface{}
+		json.Unmarshal(full, &request)
+		test.change(request)
+		data, _ := json.Marshal(request)
+
+		err := DecodeStrict(data, &MoveRequest{})
+		switch {
+		case len(test.err) == 0 && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, expected an error about %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+// ----------------------------------------------------------------
+// Search States
+//
+// The simulator copies the board on every step, which is fine for
+// playing games out but far too slow for searching thousands of
+// positions a turn.  A SearchState plays the same rules in place
+// and keeps a stack of what each step changed, so that the search
+// can make a move, look at the position and unmake it again without
+// allocating:
+//
+//   st := NewSearchState(b, t, depth)
+//   st.Apply(moves)
+//   ...
+//   st.Undo()
+//
+// Moves are given per snake, in the order of the board's snakes.
+// Eliminated snakes stay in place, marked dead, so indexes don't
+// change as the search goes deeper.
+// ----------------------------------------------------------------
+
+type SearchSnake struct {
+	ID		string
+	Health	int
+	Body	[]Coord
+	Alive	bool
+}
+
+type SearchState struct {
+	Width, Height	int
+	Turn			int
+	Snakes			[]SearchSnake
+	food			Bitset
+	hazards			Bitset
+
+	undoSnakes		[]snakeUndo		// per snake changes of every step, in order
+	undoFood		[]int			// cells eaten in every step, in order
+	frames			[]undoFrame		// where each step's changes start
+}
+
+type snakeUndo struct {
+	tail	Coord		// the tail cell the snake moved off
+	health	int
+	moved	bool		// was the snake alive to move?
+	grew	bool
+	died	bool
+}
+
+type undoFrame struct {
+	food	int
+}
+
+// Set up a search from a board, with room to search depth steps
+// ahead without allocating
+func NewSearchState (b Board, t int, depth int) *SearchState {
+	st := &SearchState {
+		Width:	b.Width,
+		Height:	b.Height,
+		Turn:	t,
+		food:	NewBitset(b.Width * b.Height),
+		hazards: NewBitset(b.Width * b.Height),
+	}
+	for _,food := range b.Food {
+		if i := st.Index(food); i >= 0 { st.food.Set(i) }
+	}
+	for _,hazard := range b.Hazards {
+		if i := st.Index(hazard); i >= 0 { st.hazards.Set(i) }
+	}
+
+	st.Snakes = make([]SearchSnake, len(b.Snakes))
+	for i,snake := range b.Snakes {
+		body := make([]Coord, len(snake.Body), len(snake.Body) + depth)
+		copy(body, snake.Body)
+		st.Snakes[i] = SearchSnake { ID: snake.ID, Health: snake.Health, Body: body, Alive: true }
+	}
+
+	st.undoSnakes = make([]snakeUndo, 0, depth * len(b.Snakes))
+	st.undoFood = make([]int, 0, depth)
+	st.frames = make([]undoFrame, 0, depth)
+	return st
+}
+
+func (st *SearchState) Index (c Coord) int {
+	if c.X < 0 || c.X >= st.Width || c.Y < 0 || c.Y >= st.Height { return -1 }
+	return c.Y*st.Width + c.X
+}
+
+func (st *SearchState) IsFood (c Coord) bool {
+	i := st.Index(c)
+	return i >= 0 && st.food.Has(i)
+}
+
+// How many steps can be undone
+func (st *SearchState) Depth () int {
+	return len(st.frames)
+}
+
+// Step forward one turn by the same rules as the simulator.  Dead
+// snakes ignore their moves.
+func (st *SearchState) Apply (moves []string) {
+	st.frames = append(st.frames, undoFrame { food: len(st.undoFood) })
+
+	// Move
+	for i := range st.Snakes {
+		snake := &st.Snakes[i]
+		n := len(snake.Body)
+		st.undoSnakes = append(st.undoSnakes, snakeUndo {
+			tail:	snake.Body[n-1],
+			health:	snake.Health,
+			moved:	snake.Alive,
+		})
+		if !snake.Alive { continue }
+
+		head := Step(snake.Body[0], moves[i])
+		copy(snake.Body[1:], snake.Body[:n-1])
+		snake.Body[0] = head
+		snake.Health--
+		if i := st.Index(head); i >= 0 && st.hazards.Has(i) { snake.Health -= hazardDamage }
+	}
+	undo := st.undoSnakes[len(st.undoSnakes)-len(st.Snakes):]
+
+	// Feed
+	for i := range st.Snakes {
+		snake := &st.Snakes[i]
+		if !snake.Alive || snake.Health <= 0 { continue }
+		c := st.Index(snake.Body[0])
+		if c < 0 || !st.food.Has(c) { continue }
+
+		snake.Health = 100
+		snake.Body = append(snake.Body, snake.Body[len(snake.Body)-1])
+		undo[i].grew = true
+	}
+	for i := range st.Snakes {
+		snake := &st.Snakes[i]
+		if !undo[i].grew { continue }
+		if c := st.Index(snake.Body[0]); st.food.Has(c) {
+			st.food.Unset(c)
+			st.undoFood = append(st.undoFood, c)
+		}
+	}
+
+	// Eliminate, deciding on every snake before removing any
+	st.Turn++
+	for i := range st.Snakes {
+		if st.Snakes[i].Alive && st.Eliminated(i) { undo[i].died = true }
+	}
+	for i := range st.Snakes {
+		if undo[i].died { st.Snakes[i].Alive = false }
+	}
+}
+
+// Would a snake be eliminated in the position as it stands?
+func (st *SearchState) Eliminated (i int) bool {
+	snake := st.Snakes[i]
+	head := snake.Body[0]
+	if snake.Health <= 0 || st.Index(head) < 0 { return true }
+
+	for j,other := range st.Snakes {
+		if !other.Alive { continue }
+		for _,segment := range other.Body[1:] {
+			if segment == head { return true }
+		}
+		if j != i && other.Body[0] == head && len(other.Body) >= len(snake.Body) { return true }
+	}
+	return false
+}
+
+// Take back the last step
+func (st *SearchState) Undo () {
+	n := len(st.frames)
+	if n == 0 { return }
+	frame := st.frames[n-1]
+	st.frames = st.frames[:n-1]
+
+	for _,c := range st.undoFood[frame.food:] { st.food.Set(c) }
+	st.undoFood = st.undoFood[:frame.food]
+
+	undo := st.undoSnakes[len(st.undoSnakes)-len(st.Snakes):]
+	st.undoSnakes = st.undoSnakes[:len(st.undoSnakes)-len(st.Snakes)]
+	for i := range st.Snakes {
+		snake := &st.Snakes[i]
+		if !undo[i].moved { continue }
+		snake.Alive = true
+		snake.Health = undo[i].health
+		if undo[i].grew { snake.Body = snake.Body[:len(snake.Body)-1] }
+
+		n := len(snake.Body)
+		copy(snake.Body[:n-1], snake.Body[1:])
+		snake.Body[n-1] = undo[i].tail
+	}
+	st.Turn--
+}
+
+// The position as a board, leaving out dead snakes.  This allocates,
+// so it is for use outside of the search loop.
+func (st *SearchState) Board () Board {
+	b := Board { Width: st.Width, Height: st.Height, Food: []Coord{}, Hazards: []Coord{} }
+	for y := 0; y < st.Height; y++ {
+		for x := 0; x < st.Width; x++ {
+			i := y*st.Width + x
+			if st.food.Has(i) { b.Food = append(b.Food, Coord{ x, y }) }
+			if st.hazards.Has(i) { b.Hazards = append(b.Hazards, Coord{ x, y }) }
+		}
+	}
+	for _,snake := range st.Snakes {
+		if !snake.Alive { continue }
+		b.Snakes = append(b.Snakes, Snake {
+			ID:		snake.ID,
+			Name:	snake.ID,
+			Health:	snake.Health,
+			Body:	append([]Coord(nil), snake.Body...),
+		})
+	}
+	return b
+}
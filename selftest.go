@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Self Test
+//
+// POST /selftest plays every scenario in the fixtures directory
+// through the engine with the live configuration, and reports which
+// passed, so that a deployment can be smoke tested right before a
+// tournament:
+//
+//   curl -X POST -H "Authorization: Bearer $ADMIN_TOKEN" https://example.com/selftest
+//
+// The directory is "fixtures", or FIXTURES if set.  The fixtures
+// cover finding food, attacking a shorter snake, keeping out of a
+// squeeze, trapping a snake on the edge, a stand-off with a snake as
+// long as us and staying out of hazards.  Scenario snakes get IDs of
+// their own, so a self test can run alongside live games, and don't
+// log.  The response is 200 if every scenario passed and 500
+// otherwise.
+//
+// The whole battery has selfTestTimeout to run in.  A scenario still
+// playing then fails, and those not yet started fail without being
+// played, so a slow engine can't hold the request open.
+// ----------------------------------------------------------------
+
+type SelfTestResult struct {
+	File		string		`json:"file"`
+	Name		string		`json:"name"`
+	Passed		bool		`json:"passed"`
+	Detail		string		`json:"detail,omitempty"`
+	Turns		int			`json:"turns"`
+	ElapsedMs	int64		`json:"elapsedMs"`
+}
+
+type SelfTestReport struct {
+	Passed		bool				`json:"passed"`
+	Scenarios	[]SelfTestResult	`json:"scenarios"`
+}
+
+var selfTests uint32
+
+// Time the whole self test may take
+const selfTestTimeout = 30 * time.Second
+
+func FixturesDir () string {
+	if dir := os.Getenv("FIXTURES"); len(dir) > 0 { return dir }
+	return "fixtures"
+}
+
+// Play a scenario to the end, or until the deadline, without
+// printing anything
+func SelfTestScenario (sc *Scenario, deadline time.Time) SelfTestResult {
+	start := time.Now()
+	result := SelfTestResult { Name: sc.Name }
+	sc = sc.Renamed(fmt.Sprintf("selftest-%d-", atomic.AddUint32(&selfTests, 1)))
+
+	sim := sc.Start()
+	gameContext.Lock()
+	for _,snake := range sc.Snakes {
+		if context, ok := gameContext.m[snake.ID]; ok { context.quiet = true }
+	}
+	gameContext.Unlock()
+	defer func() {
+		gameContext.Lock()
+		for _,snake := range sc.Snakes { delete(gameContext.m, snake.ID) }
+		gameContext.Unlock()
+	}()
+
+	firstMove := ""
+	expired := false
+	for step := 0; step < sc.Turns && sc.Running(sim); step++ {
+		if time.Now().After(deadline) {
+			expired = true
+			break
+		}
+		moves, _ := sc.NextMoves(sim)
+		if step == 0 { firstMove = moves[sc.You] }
+		sc.Apply(sim, moves)
+	}
+
+	result.Turns = sim.Turn - sc.Turn
+	if expired {
+		result.Detail = fmt.Sprintf("out of time after %d turns", result.Turns)
+	} else if err := sc.Check(sim, firstMove); err != nil {
+		result.Detail = err.Error()
+	} else {
+		result.Passed = true
+	}
+	result.ElapsedMs = time.Since(start).Milliseconds()
+	return result
+}
+
+func SelfTest (dir string, deadline time.Time) (SelfTestReport, error) {
+	report := SelfTestReport { Passed: true, Scenarios: []SelfTestResult{} }
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil { return report, err }
+	if len(paths) == 0 { return report, fmt.Errorf("no scenarios in %s", dir) }
+
+	for _,path := range paths {
+		var result SelfTestResult
+		sc, err := LoadScenario(path)
+		switch {
+		case err != nil:
+			result = SelfTestResult { Detail: err.Error() }
+		case time.Now().After(deadline):
+			result = SelfTestResult { Name: sc.Name, Detail: "out of time before it started" }
+		default:
+			result = SelfTestScenario(sc, deadline)
+		}
+		result.File = filepath.Base(path)
+		if !result.Passed { report.Passed = false }
+		report.Scenarios = append(report.Scenarios, result)
+	}
+	return report, nil
+}
+
+func HandleSelfTest (w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST to run the self test", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := SelfTest(FixturesDir(), time.Now().Add(selfTestTimeout))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("INFO: Self test passed=%t\n", report.Passed)
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Passed { w.WriteHeader(http.StatusInternalServerError) }
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelfTest (t *testing.T) {
+	engineLogging = false
+	ResetGameContexts()
+	report, err := SelfTest("fixtures", time.Now().Add(selfTestTimeout))
+	if err != nil { t.Fatal(err) }
+	if len(report.Scenarios) < 6 { t.Errorf("%d scenarios in the battery", len(report.Scenarios)) }
+	for _,result := range report.Scenarios {
+		if !result.Passed { t.Errorf("%s: %s", result.File, result.Detail) }
+	}
+}
+
+// Out of time, every scenario fails without being played
+func TestSelfTestDeadline (t *testing.T) {
+	engineLogging = false
+	report, err := SelfTest("fixtures", time.Now().Add(-time.Second))
+	if err != nil { t.Fatal(err) }
+	if report.Passed { t.Error("passed with no time to play") }
+	for _,result := range report.Scenarios {
+		if result.Passed || result.Turns > 0 { t.Errorf("%s: played %d turns out of time", result.File, result.Turns) }
+	}
+}
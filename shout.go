@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Shouts
+//
+// What we shout each turn, if anything: an explanation of the move
+// in explain mode, otherwise a phrase from our personality's pack.
+// The tone follows how the game is going.  While we are losing we
+// keep quiet, and while we are leading we shout from the pack's
+// "leading" phrases if it has any.
+// ----------------------------------------------------------------
+
+// How the game is going for us: "leading" when we are the longest
+// snake or every opponent is sealed in, "losing" when we are the
+// shortest or our space is too small for us, otherwise "even"
+func (s *GameState) Tone () string {
+	me := s.snakes[0]
+	if len(s.snakes) < 2 { return "even" }
+	longest, shortest := true, true
+	for _,snake := range s.snakes[1:] {
+		if snake.length >= me.length { longest = false }
+		if snake.length <= me.length { shortest = false }
+	}
+	switch {
+	case s.converting || longest: return "leading"
+	case shortest || me.space < me.length: return "losing"
+	}
+	return "even"
+}
+
+func Shout (id string, d MoveDecision) string {
+	if TenantOf(id).Config.Explain { return d.Explain() }
+	if d.state != nil && len(d.state.reply) > 0 { return d.state.reply }
+	if d.state == nil || len(d.state.snakes) == 0 { return "" }
+	tone := d.state.Tone()
+	if tone == "losing" { return "" }
+
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok || d.state == nil { return "" }
+
+	pack, ok := phrasePacks[context.color]
+	if !ok { pack, ok = phrasePacks["default"] }
+	if !ok { return "" }
+
+	if context.shouted == nil { context.shouted = make(map[string]int) }
+	reason := string(d.Reason)
+	if _, ok := pack.Phrases[tone]; ok && tone == "leading" { reason = tone }
+	return pack.Pick(reason, d.state.turn, context.shouted)
+}
+
+// ----------------------------------------------------------------
+// Explanations
+//
+// In explain mode we shout a compact summary of each decision: the
+// rule that picked the move, the space behind each open move, how
+// far the move leaves us from food and which moves a longer snake
+// threatens, e.g.
+//
+//   food-progress space:left=34,up=12 food:d3 threat:up
+//
+// The engine limits shouts to 256 characters.
+// ----------------------------------------------------------------
+
+const maxShout = 256
+
+func (d MoveDecision) Explain () string {
+	parts := []string { string(d.Reason) }
+	s := d.state
+	if s == nil || len(s.snakes) == 0 { return string(d.Reason) }
+
+	var spaces, threats []string
+	for _,move := range d.Moves {
+		if move.space > 0 { spaces = append(spaces, fmt.Sprintf("%s=%d", move.dir, s.spaces[move.space].size)) }
+		if move.nlonger > 0 { threats = append(threats, move.dir) }
+	}
+	if len(spaces) > 0 { parts = append(parts, "space:" + strings.Join(spaces, ",")) }
+
+	next := Step(s.snakes[0].head, d.Move)
+	dist := -1
+	for _,food := range s.food {
+		if md := ManDist(next, food.pos); dist < 0 || md < dist { dist = md }
+	}
+	if dist >= 0 { parts = append(parts, fmt.Sprintf("food:d%d", dist)) }
+
+	if len(threats) > 0 { parts = append(parts, "threat:" + strings.Join(threats, ",")) }
+
+	shout := strings.Join(parts, " ")
+	if len(shout) > maxShout { shout = shout[:maxShout] }
+	return shout
+}
+
+// ----------------------------------------------------------------
+// Phrase Packs
+//
+// Each personality, named after the colour we play as, has its own
+// pack of phrases in <name>.json in the phrases directory, with a
+// default.json for personalities without one.  Translations go in
+// a subdirectory per language and replace the packs they name:
+//
+//   {
+//     "chance": 0.3,
+//     "cooldown": 10,
+//     "phrases": {
+//       "food":   [ { "text": "Nom nom", "weight": 2 }, { "text": "Snack time" } ],
+//       "attack": [ { "text": "Gotcha!" } ],
+//       "leading": [ { "text": "Too easy" } ],
+//       "any":    [ { "text": "Space is big" } ]
+//     }
+//   }
+//
+// Phrases are keyed by the reason for the move, falling back to
+// "any".  Each turn we shout with the given chance, picking among the
+// phrases by weight, and a phrase isn't repeated within cooldown
+// turns of its last use.
+// ----------------------------------------------------------------
+
+type Phrase struct {
+	Text	string	`json:"text"`
+	Weight	float64	`json:"weight"`
+}
+
+type PhrasePack struct {
+	Chance		float64				`json:"chance"`
+	Cooldown	int					`json:"cooldown"`
+	Phrases		map[string][]Phrase	`json:"phrases"`
+}
+
+var phrasePacks = map[string]*PhrasePack{}
+
+func ReadPhrasePacks (dir string, packs map[string]*PhrasePack) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil { return err }
+
+	for _,path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil { return err }
+
+		pack := &PhrasePack { Chance: 1 }
+		if err := json.Unmarshal(data, pack); err != nil { return fmt.Errorf("%s: %v", path, err) }
+		for _,phrases := range pack.Phrases {
+			for i := range phrases {
+				if phrases[i].Weight <= 0 { phrases[i].Weight = 1 }
+			}
+		}
+		packs[strings.TrimSuffix(filepath.Base(path), ".json")] = pack
+	}
+	return nil
+}
+
+func LoadPhrasePacks (dir string, language string) (map[string]*PhrasePack, error) {
+	packs := make(map[string]*PhrasePack)
+	if err := ReadPhrasePacks(dir, packs); err != nil { return nil, err }
+	if len(language) > 0 {
+		if err := ReadPhrasePacks(filepath.Join(dir, language), packs); err != nil { return nil, err }
+	}
+	return packs, nil
+}
+
+// Pick a phrase for a move made for the given reason, or nothing.
+// used holds the turn each phrase was last shouted on.
+func (p *PhrasePack) Pick (reason string, turn int, used map[string]int) string {
+	if rand.Float64() >= p.Chance { return "" }
+
+	phrases, ok := p.Phrases[reason]
+	if !ok { phrases = p.Phrases["any"] }
+
+	var ready []Phrase
+	total := 0.0
+	for _,phrase := range phrases {
+		if last, ok := used[phrase.Text]; ok && turn - last < p.Cooldown { continue }
+		ready = append(ready, phrase)
+		total += phrase.Weight
+	}
+
+	r := rand.Float64() * total
+	for _,phrase := range ready {
+		r -= phrase.Weight
+		if r < 0 {
+			used[phrase.Text] = turn
+			if len(phrase.Text) > maxShout { return phrase.Text[:maxShout] }
+			return phrase.Text
+		}
+	}
+	return ""
+}
+
+// ----------------------------------------------------------------
+// Shout Rules
+//
+// Opponents' shouts are matched against configured patterns, which
+// can shout a reply and nudge how we play for the turn: switch off
+// some rules, or be cautious, treating any space smaller than twice
+// our length as too small to enter.
+//
+//   "shoutRules": [
+//     { "pattern": "(?i)trap", "reply": "Not today", "cautious": true },
+//     { "pattern": "(?i)come at me", "disable": [ "attack" ] }
+//   ]
+// ----------------------------------------------------------------
+
+type ShoutRule struct {
+	Pattern		string		`json:"pattern"`
+	Reply		string		`json:"reply"`
+	Disable		[]string	`json:"disable"`
+	Cautious	bool		`json:"cautious"`
+	re			*regexp.Regexp
+}
+
+func (s *GameState) HearShouts (b Board, you string) {
+	for _,snake := range b.Snakes {
+		if snake.ID == you || len(snake.Shout) == 0 { continue }
+		for _,rule := range TenantOf(you).Config.ShoutRules {
+			if !rule.re.MatchString(snake.Shout) { continue }
+			s.debug.Printf("Heard %s shout \"%s\"\n", snake.Name, snake.Shout)
+			if len(rule.Reply) > 0 && len(s.reply) == 0 { s.reply = rule.Reply }
+			if len(rule.Disable) > 0 {
+				s.weights.Disable = append(append([]string(nil), s.weights.Disable...), rule.Disable...)
+			}
+			if rule.Cautious { s.cautious = true }
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+// ----------------------------------------------------------------
+// Simulator
+//
+// A local implementation of the game rules so that positions can
+// be played forward without an engine.  Each turn every snake moves,
+// loses health (more in hazards), eats, and is then eliminated if it
+// starved, left the board, hit a body or lost a head to head.
+// ----------------------------------------------------------------
+
+const hazardDamage = 14
+
+type Elimination struct {
+	ID		string
+	Turn	int
+	Cause	string		// starvation, wall, self, body or head-to-head
+	By		string		// the snake we collided with, if any
+}
+
+type Simulation struct {
+	Game		Game
+	Turn		int
+	Board		Board
+	Eliminated	[]Elimination
+}
+
+func NewSimulation (g Game, t int, b Board) *Simulation {
+	sim := &Simulation { Game: g, Turn: t }
+	sim.Board = CopyBoard(b)
+	return sim
+}
+
+func CopyBoard (b Board) Board {
+	c := b
+	c.Food = append([]Coord(nil), b.Food...)
+	c.Hazards = append([]Coord(nil), b.Hazards...)
+	c.Snakes = make([]Snake, len(b.Snakes))
+	for i,snake := range b.Snakes {
+		c.Snakes[i] = snake
+		c.Snakes[i].Body = append([]Coord(nil), snake.Body...)
+	}
+	return c
+}
+
+// Find a live snake by ID
+func (sim *Simulation) Snake (id string) (Snake, bool) {
+	for _,snake := range sim.Board.Snakes {
+		if snake.ID == id { return snake, true }
+	}
+	return Snake{}, false
+}
+
+// Step the game forward one turn.  Snakes without a move keep going
+// in the direction they were already going.
+func (sim *Simulation) Step (moves map[string]string) {
+	b := &sim.Board
+
+	// Move
+	for i := range b.Snakes {
+		snake := &b.Snakes[i]
+		dir, ok := moves[snake.ID]
+		if !ok { dir = CurrentDirection(*snake) }
+
+		head := Step(snake.Body[0], dir)
+		snake.Body = append([]Coord{ head }, snake.Body[:len(snake.Body)-1]...)
+		snake.Health--
+	}
+
+	// Hazards
+	hazards := make(map[Coord]bool)
+	for _,hazard := range b.Hazards { hazards[hazard] = true }
+	for i := range b.Snakes {
+		if hazards[b.Snakes[i].Body[0]] { b.Snakes[i].Health -= hazardDamage }
+	}
+
+	// Feed
+	eaten := make(map[Coord]bool)
+	for i := range b.Snakes {
+		snake := &b.Snakes[i]
+		for _,food := range b.Food {
+			if snake.Body[0] == food && snake.Health > 0 {
+				snake.Health = 100
+				snake.Body = append(snake.Body, snake.Body[len(snake.Body)-1])
+				eaten[food] = true
+				break		// the same cell may be listed twice, but it only feeds once
+			}
+		}
+	}
+	food := b.Food[:0]
+	for _,f := range b.Food {
+		if !eaten[f] { food = append(food,f) }
+	}
+	b.Food = food
+
+	// Eliminate
+	sim.Turn++
+	var dead []Elimination
+	for _,snake := range b.Snakes {
+		head := snake.Body[0]
+		switch {
+		case snake.Health <= 0:
+			dead = append(dead, Elimination { snake.ID, sim.Turn, "starvation", "" })
+			continue
+		case head.X < 0 || head.X >= b.Width || head.Y < 0 || head.Y >= b.Height:
+			dead = append(dead, Elimination { snake.ID, sim.Turn, "wall", "" })
+			continue
+		}
+
+		eliminated := false
+		for _,other := range b.Snakes {
+			for _,segment := range other.Body[1:] {
+				if segment != head { continue }
+				cause := "body"
+				if other.ID == snake.ID { cause = "self" }
+				dead = append(dead, Elimination { snake.ID, sim.Turn, cause, other.ID })
+				eliminated = true
+				break
+			}
+			if eliminated { break }
+		}
+		if eliminated { continue }
+
+		for _,other := range b.Snakes {
+			if other.ID != snake.ID && other.Body[0] == head && len(other.Body) >= len(snake.Body) {
+				dead = append(dead, Elimination { snake.ID, sim.Turn, "head-to-head", other.ID })
+				break
+			}
+		}
+	}
+
+	sim.Eliminated = append(sim.Eliminated, dead...)
+	alive := b.Snakes[:0]
+	for _,snake := range b.Snakes {
+		eliminated := false
+		for _,e := range dead {
+			if e.ID == snake.ID { eliminated = true }
+		}
+		if !eliminated { alive = append(alive,snake) }
+	}
+	b.Snakes = alive
+}
+
+// The direction a snake moved last turn, or up if it hasn't moved yet
+func CurrentDirection (snake Snake) string {
+	if len(snake.Body) < 2 || snake.Body[0] == snake.Body[1] { return "up" }
+	return Direction(snake.Body[1], snake.Body[0])
+}
+
+// Has the game ended, i.e. are there one or no snakes left?
+func (sim *Simulation) Over () bool {
+	return len(sim.Board.Snakes) <= 1
+}
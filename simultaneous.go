@@ -0,0 +1,161 @@
+package main
+
+// ----------------------------------------------------------------
+// Stand-offs
+//
+// Snakes move at the same time, so where our head and another's can
+// reach the same cell, neither of us moves knowing what the other
+// will do.  Treating it as if one moved first gets these stand-offs
+// wrong, so at the root we play each one as a small simultaneous
+// game: a payoff matrix over our moves and the other snake's, solved
+// for the mixed strategies neither of us can do better than.
+//
+// Meeting head to head scores 1 for the longer snake and -1 for the
+// shorter, and a tie -1 for both if we avoid ties, -0.5 otherwise.
+// Short of that, a move into one of our small spaces costs us 0.5
+// and a reply into a space too small for the other snake gains us
+// 0.5.  The game is taken as zero sum and solved by fictitious play,
+// which is plenty for a matrix of at most four by four.
+//
+// How often the other snake plays a cell in its strategy is the
+// chance we give it of meeting us there, in place of its opponent
+// model.  A move we hardly ever play in ours is never risked, however
+// unlikely the head to head, as the other snake may have no reason
+// not to go there, and when every move is threatened, how often we
+// play each move breaks ties between them.
+// ----------------------------------------------------------------
+
+// Rounds of fictitious play
+const standOffRounds = 500
+
+// How rarely we play a move in a stand-off for it to count as a move
+// not to play
+const standOffDominated = 0.05
+
+type StandOff struct {
+	snake		int
+	ours		[]Coord			// our moves, and how often we play each
+	mixOurs		[]float64
+	theirs		[]Coord			// the other snake's moves, and how often it plays each
+	mixTheirs	[]float64
+	value		float64			// what the stand-off is worth to us
+}
+
+// The stand-offs with every other snake whose head is two moves from ours
+func (s *GameState) MapStandOffs (moves []MoveType) {
+	s.standOffs = s.standOffs[:0]
+	if !s.weights.Enabled("simultaneous") { return }
+	for sx := 1; sx < len(s.snakes); sx++ {
+		snake := s.snakes[sx]
+		if snake.teammate || ManDist(snake.head, s.snakes[0].head) != 2 { continue }
+		if standOff, ok := s.StandOff(sx, moves); ok {
+			s.debug.Printf("Stand-off with snake at: [H](%d,%d) is worth %.2f to us\n",
+						   snake.head.X, snake.head.Y, standOff.value)
+			s.standOffs = append(s.standOffs, standOff)
+		}
+	}
+}
+
+func (s *GameState) StandOff (snake int, moves []MoveType) (StandOff, bool) {
+	standOff := StandOff { snake: snake }
+	for _,move := range moves { standOff.ours = append(standOff.ours, move.c) }
+	var buf [4]Coord
+	shared := false
+	for _,c := range s.Neighbours(s.snakes[snake].head, &buf) {
+		if !s.IsFree(c) { continue }
+		standOff.theirs = append(standOff.theirs, c)
+		if ManDist(c, s.snakes[0].head) == 1 { shared = true }
+	}
+	if !shared || len(standOff.ours) == 0 { return standOff, false }
+
+	payoff := make([][]float64, len(moves))
+	for a,move := range moves {
+		payoff[a] = make([]float64, len(standOff.theirs))
+		for b,c := range standOff.theirs { payoff[a][b] = s.StandOffPayoff(snake, move, c) }
+	}
+	standOff.mixOurs, standOff.mixTheirs, standOff.value = SolveZeroSum(payoff, standOffRounds)
+	return standOff, true
+}
+
+// What we gain when we make our move and the other snake moves to c
+func (s *GameState) StandOffPayoff (snake int, move MoveType, c Coord) float64 {
+	if move.c == c {
+		switch mine, theirs := s.snakes[0].length, s.snakes[snake].length; {
+		case mine > theirs:				return 1
+		case mine < theirs:				return -1
+		case s.weights.Ties == "avoid":	return -1
+		}
+		return -0.5
+	}
+
+	payoff := 0.0
+	if move.smallSpace { payoff -= 0.5 }
+
+	// Play our move on the grid to see the space the reply leaves it
+	me := s.snakes[0]
+	saved, savedHead := s.grid[move.c.X][move.c.Y], s.grid[me.head.X][me.head.Y]
+	s.grid[move.c.X][move.c.Y] = HeadCell(0)
+	s.grid[me.head.X][me.head.Y] = BodyCell(0)
+	if s.ReachableSpace(c) + 1 < s.snakes[snake].length { payoff += 0.5 }
+	s.grid[move.c.X][move.c.Y] = saved
+	s.grid[me.head.X][me.head.Y] = savedHead
+	return payoff
+}
+
+// The mixed strategies of a zero sum game, the row player's payoffs
+// given, found by fictitious play: each round, each player plays the
+// best reply to how often the other has played each move so far
+func SolveZeroSum (payoff [][]float64, rounds int) ([]float64, []float64, float64) {
+	nrows, ncols := len(payoff), len(payoff[0])
+	rowCounts, colCounts := make([]float64, nrows), make([]float64, ncols)
+	rowTotals, colTotals := make([]float64, nrows), make([]float64, ncols)	// against the other's plays so far
+
+	row, col := 0, 0
+	for round := 0; round < rounds; round++ {
+		rowCounts[row]++
+		colCounts[col]++
+		for a := range rowTotals { rowTotals[a] += payoff[a][col] }
+		for b := range colTotals { colTotals[b] += payoff[row][b] }
+		for a := range rowTotals {
+			if rowTotals[a] > rowTotals[row] { row = a }
+		}
+		for b := range colTotals {
+			if colTotals[b] < colTotals[col] { col = b }
+		}
+	}
+
+	value := 0.0
+	for a := range rowCounts {
+		rowCounts[a] /= float64(rounds)
+		for b := range colCounts { value += rowCounts[a] * payoff[a][b] * colCounts[b] / float64(rounds) }
+	}
+	for b := range colCounts { colCounts[b] /= float64(rounds) }
+	return rowCounts, colCounts, value
+}
+
+// The chance a stand-off gives of a snake moving to c
+func (s *GameState) StandOffChance (snake int, c Coord) (float64, bool) {
+	for _,standOff := range s.standOffs {
+		if standOff.snake != snake { continue }
+		for b,cell := range standOff.theirs {
+			if cell == c { return standOff.mixTheirs[b], true }
+		}
+	}
+	return 0, false
+}
+
+// Is a move one the stand-offs say we should not play?
+func (s *GameState) Dominated (c Coord) bool {
+	return len(s.standOffs) > 0 && s.StandOffShare(c) < standOffDominated
+}
+
+// How often we play a move across our stand-offs
+func (s *GameState) StandOffShare (c Coord) float64 {
+	share := 1.0
+	for _,standOff := range s.standOffs {
+		for a,cell := range standOff.ours {
+			if cell == c { share *= standOff.mixOurs[a] }
+		}
+	}
+	return share
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolveZeroSum (t *testing.T) {
+	tests := []struct {
+		name	string
+		payoff	[][]float64
+		rows	[]float64		// the row player's mix
+		cols	[]float64		// the column player's mix
+		value	float64
+	}{
+		{ "matching pennies", [][]float64{ { 1, -1 }, { -1, 1 } }, []float64{ 0.5, 0.5 }, []float64{ 0.5, 0.5 }, 0 },
+		{ "dominant row", [][]float64{ { 1, 1 }, { 0, 0 } }, []float64{ 1, 0 }, nil, 1 },
+		{ "saddle point", [][]float64{ { 3, 1 }, { 4, 2 } }, []float64{ 0, 1 }, []float64{ 0, 1 }, 2 },
+		{ "rock paper scissors", [][]float64{ { 0, -1, 1 }, { 1, 0, -1 }, { -1, 1, 0 } },
+		  []float64{ 1.0/3, 1.0/3, 1.0/3 }, []float64{ 1.0/3, 1.0/3, 1.0/3 }, 0 },
+		{ "one move each", [][]float64{ { 0.7 } }, []float64{ 1 }, []float64{ 1 }, 0.7 },
+	}
+	const tolerance = 0.05
+	near := func (a, b float64) bool { return math.Abs(a - b) <= tolerance }
+	for _,test := range tests {
+		rows, cols, value := SolveZeroSum(test.payoff, standOffRounds)
+		if !near(value, test.value) { t.Errorf("%s: value %.3f, expected %.3f", test.name, value, test.value) }
+		for i := range test.rows {
+			if !near(rows[i], test.rows[i]) { t.Errorf("%s: row mix %v, expected %v", test.name, rows, test.rows) }
+		}
+		for i := range test.cols {
+			if !near(cols[i], test.cols[i]) { t.Errorf("%s: column mix %v, expected %v", test.name, cols, test.cols) }
+		}
+	}
+}
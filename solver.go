@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Solver
+//
+// Puzzles in the style of mate in N: from a scenario's position, is
+// there a way to eliminate a target snake within N turns whatever
+// every other snake does, and without being eliminated ourselves?
+//
+//   spacey-snake solve -turns 4 -target them fixtures/edge-trap.json
+//
+// The search is exhaustive.  We choose our move without knowing
+// theirs, as in the game, and every other snake may make any move
+// but back into its neck.  No food is spawned.  The target defaults
+// to the only opponent, if there is just one.  Searching one turn
+// deeper at a time, the solver prints the first moves that force the
+// elimination soonest, or that none does within N turns, and what
+// the engine would play, which is how we check the main search isn't
+// missing wins it should see.
+// ----------------------------------------------------------------
+
+type Solver struct {
+	st			*SearchState
+	me, target	int
+	nodes		int
+}
+
+// The moves a snake might make, leaving out the one back into its neck
+func (sv *Solver) options (i int) []string {
+	body := sv.st.Snakes[i].Body
+	var moves []string
+	for _,o := range neighbourOffsets {
+		next := Coord{ body[0].X + o.dx, body[0].Y + o.dy }
+		if len(body) > 1 && next == body[1] && body[1] != body[0] { continue }
+		moves = append(moves, o.dir)
+	}
+	return moves
+}
+
+// Can we force the target's elimination within depth turns?
+func (sv *Solver) Forced (depth int) bool {
+	if depth == 0 { return false }
+	for _,move := range sv.options(sv.me) {
+		if sv.ForcedAfter(move, depth) { return true }
+	}
+	return false
+}
+
+// Does our move force it, whatever the others reply?
+func (sv *Solver) ForcedAfter (move string, depth int) bool {
+	moves := make([]string, len(sv.st.Snakes))
+	moves[sv.me] = move
+	return sv.replies(moves, 0, depth)
+}
+
+// Try every combination of moves for the other snakes from i on
+func (sv *Solver) replies (moves []string, i int, depth int) bool {
+	if i == len(sv.st.Snakes) {
+		sv.nodes++
+		sv.st.Apply(moves)
+		defer sv.st.Undo()
+		switch {
+		case !sv.st.Snakes[sv.me].Alive:		return false
+		case !sv.st.Snakes[sv.target].Alive:	return true
+		}
+		return sv.Forced(depth-1)
+	}
+	if i == sv.me || !sv.st.Snakes[i].Alive { return sv.replies(moves, i+1, depth) }
+	for _,move := range sv.options(i) {
+		moves[i] = move
+		if !sv.replies(moves, i+1, depth) { return false }
+	}
+	return true
+}
+
+func SolveCommand (args []string) int {
+	flags := flag.NewFlagSet("solve", flag.ExitOnError)
+	turns := flags.Int("turns", 4, "the most turns to search")
+	target := flags.String("target", "", "the snake to eliminate, the only opponent by default")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake solve [-turns n] [-target id] scenario.json\n")
+		return 2
+	}
+
+	sc, err := LoadScenario(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	b := Board { Width: sc.Width, Height: sc.Height, Food: sc.Food, Hazards: sc.Hazards, Snakes: sc.Snakes }
+	sv := &Solver { st: NewSearchState(b, sc.Turn, *turns), me: -1, target: -1 }
+	for i,snake := range sc.Snakes {
+		switch {
+		case snake.ID == sc.You:
+			sv.me = i
+		case snake.ID == *target, len(*target) == 0 && len(sc.Snakes) == 2:
+			sv.target = i
+		}
+	}
+	if sv.me < 0 || sv.target < 0 {
+		fmt.Fprintf(os.Stderr, "The scenario needs our snake and a target, given with -target if there is more than one opponent\n")
+		return 2
+	}
+
+	// What the engine would play, playing only the first turn
+	engineLogging = false
+	sim := sc.Start()
+	moves, _ := sc.NextMoves(sim)
+	engine := moves[sc.You]
+
+	start := time.Now()
+	targetID := sc.Snakes[sv.target].ID
+	for depth := 1; depth <= *turns; depth++ {
+		var forcing []string
+		for _,move := range sv.options(sv.me) {
+			if sv.ForcedAfter(move, depth) { forcing = append(forcing, move) }
+		}
+		if len(forcing) == 0 { continue }
+
+		found := false
+		for _,move := range forcing {
+			if move == engine { found = true }
+		}
+		fmt.Printf("%s forces the elimination of %s within %d turns, nodes=%d elapsed=%v\n",
+				   strings.Join(forcing, ","), targetID, depth, sv.nodes, time.Since(start))
+		if found {
+			fmt.Printf("The engine plays %s, which forces it\n", engine)
+		} else {
+			fmt.Printf("The engine plays %s, which doesn't force it\n", engine)
+		}
+		return 0
+	}
+	fmt.Printf("No forced elimination of %s within %d turns, nodes=%d elapsed=%v\n", targetID, *turns,
+			   sv.nodes, time.Since(start))
+	fmt.Printf("The engine plays %s\n", engine)
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ----------------------------------------------------------------
+// Opponent Stats
+//
+// How we fare against each opponent, by name, over every game since
+// the server started.  Exported in the Prometheus text format from
+// /metrics so trends against particular rivals can be graphed.  Each
+// tenant's are kept apart and labelled with the tenant.
+// ----------------------------------------------------------------
+
+type OpponentStats struct {
+	games	int
+	wins	int
+	turns	int		// total turns we survived in games against them
+	kills	int		// times we eliminated them
+}
+
+type OpponentStatsTable struct {
+	sync.Mutex
+	m map[string]*OpponentStats
+}
+
+var opponentStats OpponentStatsTable
+
+func RecordOpponentStats (id string) {
+	record, summary, ok := GameOutcome(id)
+	if !ok || len(record.Frames) == 0 { return }
+
+	survived := summary.Turns
+	last := record.Frames[len(record.Frames)-1]
+	for _,snake := range last.Snakes {
+		if snake.ID == id && snake.Death != nil { survived = snake.Death.Turn }
+	}
+
+	table := TenantOf(id).Stats
+	table.Lock()
+	defer table.Unlock()
+	if table.m == nil { table.m = make(map[string]*OpponentStats) }
+
+	for _,snake := range last.Snakes {
+		if snake.ID == id { continue }
+
+		stats, ok := table.m[snake.Name]
+		if !ok {
+			stats = new(OpponentStats)
+			table.m[snake.Name] = stats
+		}
+		stats.games++
+		if summary.Result == "win" { stats.wins++ }
+		stats.turns += survived
+		if snake.Death != nil && snake.Death.EliminatedBy == id { stats.kills++ }
+	}
+}
+
+func EscapeLabel (s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func HandleMetrics (w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metric := func (name, kind, help string, value func(*OpponentStats) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _,tenant := range Tenants() {
+			label := ""
+			if tenant != ourTenant { label = fmt.Sprintf("tenant=\"%s\",", EscapeLabel(tenant.Name)) }
+			table := tenant.Stats
+			table.Lock()
+			names := make([]string, 0, len(table.m))
+			for name := range table.m { names = append(names, name) }
+			sort.Strings(names)
+			for _,opponent := range names {
+				fmt.Fprintf(w, "%s{%sopponent=\"%s\"} %g\n", name, label, EscapeLabel(opponent),
+							value(table.m[opponent]))
+			}
+			table.Unlock()
+		}
+	}
+
+	metric("spacey_opponent_games_total", "counter", "Games played against each opponent.",
+		func (s *OpponentStats) float64 { return float64(s.games) })
+	metric("spacey_opponent_win_rate", "gauge", "Fraction of games against each opponent that we won.",
+		func (s *OpponentStats) float64 { return float64(s.wins) / float64(s.games) })
+	metric("spacey_opponent_turns_survived_avg", "gauge", "Average turns we survived in games against each opponent.",
+		func (s *OpponentStats) float64 { return float64(s.turns) / float64(s.games) })
+	metric("spacey_opponent_kills_total", "counter", "Times we eliminated each opponent.",
+		func (s *OpponentStats) float64 { return float64(s.kills) })
+
+	WriteSchemaMetrics(w)
+	WritePayloadMetrics(w)
+	WriteGCMetrics(w)
+	WriteWorkerMetrics(w)
+	WritePhaseMetrics(w)
+	WriteCacheMetrics(w)
+	WriteGuardMetrics(w)
+	WriteExperimentMetrics(w)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Parameter Sweeps
+//
+// Try many combinations of weights against a fixed set of opponents
+// in the simulator and rank them by how often they win and how long
+// they survive.  Each -param gives a weight, by its name in the
+// config file, and the range to try:
+//
+//   spacey-snake sweep -param centerWeight=0:1:0.25 -param satedHealth=30:70:10 \
+//                      -against default,random -games 50
+//
+// By default every combination on the grid is tried.  With -sample
+// random or -sample lhs, -n configurations are drawn at random or by
+// Latin hypercube sampling instead, and the step is ignored.  Games
+// are spread across all cores.
+// ----------------------------------------------------------------
+
+type SweepParam struct {
+	Name		string
+	Lo, Hi, Step	float64
+}
+
+func ParseSweepParam (s string) (SweepParam, error) {
+	var p SweepParam
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 { return p, fmt.Errorf("expected name=lo:hi:step, got %s", s) }
+	p.Name = parts[0]
+
+	bounds := strings.Split(parts[1], ":")
+	if len(bounds) < 2 || len(bounds) > 3 { return p, fmt.Errorf("expected name=lo:hi:step, got %s", s) }
+	values := make([]float64, 3)
+	for i,bound := range bounds {
+		v, err := strconv.ParseFloat(bound, 64)
+		if err != nil { return p, fmt.Errorf("%s: %v", s, err) }
+		values[i] = v
+	}
+	p.Lo, p.Hi, p.Step = values[0], values[1], values[2]
+	if len(bounds) == 2 || p.Step <= 0 { p.Step = p.Hi - p.Lo }
+	return p, nil
+}
+
+// Set a weight by its name in the config file
+func SetWeight (w *Weights, name string, value float64) error {
+	data, _ := json.Marshal(w)
+	fields := make(map[string]interface{})
+	json.Unmarshal(data, &fields)
+	if _, ok := fields[name]; !ok { return fmt.Errorf("unknown weight %s", name) }
+
+	fields[name] = value
+	data, _ = json.Marshal(fields)
+	return json.Unmarshal(data, w)
+}
+
+// Every combination of values on the grid
+func GridPoints (params []SweepParam) [][]float64 {
+	points := [][]float64 { {} }
+	for _,p := range params {
+		var next [][]float64
+		for _,point := range points {
+			for v := p.Lo; v <= p.Hi + p.Step/1e6; v += p.Step {
+				next = append(next, append(append([]float64(nil), point...), v))
+				if p.Step == 0 { break }
+			}
+		}
+		points = next
+	}
+	return points
+}
+
+func RandomPoints (params []SweepParam, n int, rng *rand.Rand) [][]float64 {
+	points := make([][]float64, n)
+	for i := range points {
+		for _,p := range params {
+			points[i] = append(points[i], p.Lo + rng.Float64() * (p.Hi - p.Lo))
+		}
+	}
+	return points
+}
+
+// Split each range into n strata and use each stratum exactly once
+func LatinHypercubePoints (params []SweepParam, n int, rng *rand.Rand) [][]float64 {
+	points := make([][]float64, n)
+	for _,p := range params {
+		strata := rng.Perm(n)
+		for i := range points {
+			v := p.Lo + (float64(strata[i]) + rng.Float64()) / float64(n) * (p.Hi - p.Lo)
+			points[i] = append(points[i], v)
+		}
+	}
+	return points
+}
+
+type SweepResult struct {
+	Point		[]float64
+	Wins		int
+	Games		int
+	Survived	[]int
+}
+
+func (r *SweepResult) WinRate () float64 {
+	if r.Games == 0 { return 0 }
+	return float64(r.Wins) / float64(r.Games)
+}
+
+func (r *SweepResult) MedianSurvival () int {
+	if len(r.Survived) == 0 { return 0 }
+	sorted := append([]int(nil), r.Survived...)
+	sort.Ints(sorted)
+	return sorted[len(sorted)/2]
+}
+
+func SweepCommand (args []string) int {
+	var specs []string
+	flags := flag.NewFlagSet("sweep", flag.ExitOnError)
+	flags.Var((*stringList)(&specs), "param", "weight and range to sweep, name=lo:hi:step (repeatable)")
+	against := flags.String("against", "default", "comma separated opponents: default, random or config files")
+	games := flags.Int("games", 20, "games to play for each configuration")
+	sample := flags.String("sample", "grid", "grid, random or lhs")
+	n := flags.Int("n", 20, "configurations to sample with random or lhs")
+	ruleset := flags.String("ruleset", "standard", "ruleset to play and take weights for")
+	size := flags.Int("size", 11, "width and height of the board")
+	maxTurns := flags.Int("turns", 500, "maximum turns per game")
+	workers := flags.Int("workers", runtime.NumCPU(), "games to play in parallel")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "random seed")
+	flags.Parse(args)
+
+	var params []SweepParam
+	for _,spec := range specs {
+		p, err := ParseSweepParam(spec)
+		if err == nil { err = SetWeight(new(Weights), p.Name, p.Lo) }
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 2
+		}
+		params = append(params, p)
+	}
+	if len(params) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake sweep -param name=lo:hi:step ... [flags]\n")
+		return 2
+	}
+
+	var opponents []Participant
+	for _,spec := range strings.Split(*against, ",") {
+		opponent, err := LoadParticipant(spec, *ruleset)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		opponents = append(opponents, opponent)
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	var points [][]float64
+	switch *sample {
+	case "grid":	points = GridPoints(params)
+	case "random":	points = RandomPoints(params, *n, rng)
+	case "lhs":		points = LatinHypercubePoints(params, *n, rng)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown sampling: %s\n", *sample)
+		return 2
+	}
+
+	results := make([]SweepResult, len(points))
+	candidates := make([]Participant, len(points))
+	base := WeightsFor(*ruleset)
+	for i,point := range points {
+		weights := base
+		for px,p := range params { SetWeight(&weights, p.Name, point[px]) }
+		candidates[i] = Participant { Name: "candidate", Weights: &weights }
+		results[i].Point = point
+	}
+
+	engineLogging = false
+
+	type job struct { point, game int }
+	jobs := make(chan job)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rng := rand.New(rand.NewSource(*seed + int64(j.point * *games + j.game)))
+				players := make(map[string]Participant)
+				me := fmt.Sprintf("%d-%d-0", j.point, j.game)
+				ids := []string { me }
+				players[me] = candidates[j.point]
+				for i,opponent := range opponents {
+					id := fmt.Sprintf("%d-%d-%d", j.point, j.game, i+1)
+					players[id] = opponent
+					ids = append(ids, id)
+				}
+
+				g, b := NewRandomGame(fmt.Sprintf("sweep-%d-%d", j.point, j.game), *ruleset, *size, *size, ids, rng)
+				result := PlayGame(g, b, players, rng, *maxTurns)
+
+				mu.Lock()
+				r := &results[j.point]
+				r.Games++
+				if result.Places[me] == 0 { r.Wins++ }
+				r.Survived = append(r.Survived, result.Survived[me])
+				mu.Unlock()
+			}
+		}()
+	}
+	for point := range points {
+		for game := 0; game < *games; game++ { jobs <- job { point, game } }
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].WinRate() != results[j].WinRate() { return results[i].WinRate() > results[j].WinRate() }
+		return results[i].MedianSurvival() > results[j].MedianSurvival()
+	})
+	for rank,r := range results {
+		var values []string
+		for px,p := range params { values = append(values, fmt.Sprintf("%s=%g", p.Name, r.Point[px])) }
+		fmt.Printf("%3d. win=%.2f median-survival=%d %s\n", rank+1, r.WinRate(), r.MedianSurvival(),
+				   strings.Join(values, " "))
+	}
+	return 0
+}
+
+// A flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String () string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set (s string) error {
+	*l = append(*l, s)
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+// ----------------------------------------------------------------
+// Teammates
+//
+// When two of the personalities this server hosts end up in the
+// same game, they are better off leaving each other alone.  The
+// config lists the names our snakes play under:
+//
+//   "teammates": [ "Spacey", "Spacey Hunter" ]
+//
+// and while the "truce" weight is set, as it is by default, we don't
+// attack or pressure a teammate, try to starve it or pin it against
+// a wall, and leave it any food it is at least as close to as we are
+// unless we are starving.  A preset with "truce": false, chosen by a
+// strategy for the events where that's allowed, turns the truce off.
+// ----------------------------------------------------------------
+
+// Is a snake in the game one of ours?
+func (c Config) Teammate (name string) bool {
+	for _,teammate := range c.Teammates {
+		if teammate == name { return true }
+	}
+	return false
+}
+
+// Leave food to any teammate at least as close to it as we are
+func (s *GameState) LeaveFoodToTeammates () {
+	if s.snakes[0].health <= s.weights.StarvingHealth { return }
+	for fx := range s.food {
+		food := &s.food[fx]
+		for _,snake := range s.snakes[1:] {
+			if !snake.teammate || ManDist(snake.head,food.pos) > food.dist { continue }
+			food.claimed = true
+			s.debug.Printf("Food at: (%d,%d) is left to teammate at [H](%d,%d)\n",
+						   food.pos.X,food.pos.Y,snake.head.X,snake.head.Y)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ----------------------------------------------------------------
+// Tenants
+//
+// One server can host snakes for several teams, each with its own
+// config tree, opponent models and stats, and directory of recorded
+// games, none of which the others see.  The config lists them by
+// name:
+//
+//   "tenants": {
+//     "alice": { "config": "tenants/alice.json", "records": "/data/alice" }
+//   }
+//
+// and a tenant's snakes are served under its name, its personalities
+// included, with its recorded games alongside:
+//
+//   https://example.com/alice/move
+//   https://example.com/alice/hunter/move
+//   https://example.com/alice/games/<game id>
+//
+// A tenant's config is read as ours is, for its profiles, presets,
+// strategies, quirks, teammates and so on, but can't have tenants of
+// its own.  What the whole server shares stays with our config: the
+// protection, the external evaluator and the phrase packs.  Games
+// played without a tenant in the path are ours, recorded under
+// RECORD_DIR as before.
+// ----------------------------------------------------------------
+
+type TenantConfig struct {
+	Config	string	`json:"config"`
+	Records	string	`json:"records"`
+}
+
+type Tenant struct {
+	Name		string
+	Config		*Config
+	Records		string
+	Stats		*OpponentStatsTable
+	Opponents	OpponentStore
+}
+
+// The tenant that plays games served outside any other's path
+var ourTenant = &Tenant { Config: &config, Stats: &opponentStats, Opponents: opponents }
+
+var tenants = map[string]*Tenant{}
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Paths of our own a tenant can't be named after
+var reservedPaths = []string {
+	"start", "move", "end", "games", "analyze", "metrics", "healthz",
+	"readyz", "version", "selftest", "ping", "human",
+}
+
+func CheckTenantName (name string, presets []string) error {
+	if !tenantName.MatchString(name) { return fmt.Errorf("tenant %q must be lower case letters, digits, - and _", name) }
+	for _,reserved := range append(append([]string(nil), reservedPaths...), presets...) {
+		if name == reserved { return fmt.Errorf("tenant %s would hide /%s", name, reserved) }
+	}
+	return nil
+}
+
+// Read the config of each tenant a config lists
+func LoadTenants (c Config) (map[string]*Tenant, error) {
+	loaded := make(map[string]*Tenant)
+	for name,tc := range c.Tenants {
+		tenantConfig, err := ReadConfig(tc.Config)
+		if err != nil { return nil, fmt.Errorf("tenant %s: %v", name, err) }
+		if len(tenantConfig.Tenants) > 0 { return nil, fmt.Errorf("tenant %s: %s can't have tenants of its own", name, tc.Config) }
+		loaded[name] = &Tenant {
+			Name:		name,
+			Config:		&tenantConfig,
+			Records:	tc.Records,
+			Stats:		&OpponentStatsTable{},
+			Opponents:	NewMemoryOpponents(),
+		}
+	}
+	return loaded, nil
+}
+
+// Every tenant, ours first and then the others by name
+func Tenants () []*Tenant {
+	names := make([]string, 0, len(tenants))
+	for name := range tenants { names = append(names, name) }
+	sort.Strings(names)
+
+	all := []*Tenant { ourTenant }
+	for _,name := range names { all = append(all, tenants[name]) }
+	return all
+}
+
+func TenantNamed (name string) (*Tenant, bool) {
+	t, ok := tenants[name]
+	return t, ok
+}
+
+// The tenant playing the game of one of our snakes
+func TenantOf (id string) *Tenant {
+	return GetContext(id).Tenant()
+}
+
+func (c *ContextType) Tenant () *Tenant {
+	if c.tenant == nil { return ourTenant }
+	return c.tenant
+}
+
+// The tenant named by the first part of a request's path, if any
+func RequestTenant (r *http.Request) *Tenant {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)
+	if t, ok := TenantNamed(parts[0]); ok { return t }
+	return ourTenant
+}
+
+// Where a tenant's games are recorded, if anywhere
+func (t *Tenant) RecordDir () string {
+	if t == ourTenant { return RecordDir() }
+	return t.Records
+}
+
+func (t *Tenant) RecordPath (gameID string) string {
+	return filepath.Join(t.RecordDir(), filepath.Base(gameID) + ".json")
+}
+
+// Where blunders in a tenant's games become fixtures, next to its
+// recorded games
+func (t *Tenant) FixturesDir () string {
+	if t == ourTenant { return FixturesDir() }
+	if len(t.Records) == 0 { return "" }
+	return filepath.Join(t.Records, "fixtures")
+}
+
+// Serve each tenant's snakes, personalities and games under its name
+func HandleTenants () {
+	for _,t := range Tenants()[1:] {
+		prefix := "/" + t.Name
+		http.HandleFunc(prefix + "/start", EngineOnly(HandleStart))
+		http.HandleFunc(prefix + "/move", EngineOnly(HandleMove))
+		http.HandleFunc(prefix + "/end", EngineOnly(HandleEnd))
+		http.HandleFunc(prefix + "/games/", ViewerOnly(HandleGames))
+		for _,name := range t.Config.PresetNames() {
+			http.HandleFunc(prefix + "/" + name + "/start", EngineOnly(HandleStart))
+			http.HandleFunc(prefix + "/" + name + "/move", EngineOnly(HandleMove))
+			http.HandleFunc(prefix + "/" + name + "/end", EngineOnly(HandleEnd))
+		}
+	}
+}
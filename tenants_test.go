@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckTenantName (t *testing.T) {
+	presets := []string{ "hunter", "local" }
+	tests := []struct {
+		name	string
+		valid	bool
+	}{
+		{ "acme", true },
+		{ "team-7_b", true },
+		{ "9lives", true },
+		{ "", false },
+		{ "Acme", false },
+		{ "-acme", false },
+		{ "acme/games", false },
+		{ "move", false },
+		{ "metrics", false },
+		{ "selftest", false },
+		{ "hunter", false },
+	}
+	for _,test := range tests {
+		if err := CheckTenantName(test.name, presets); (err == nil) != test.valid {
+			t.Errorf("%q: got %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
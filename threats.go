@@ -0,0 +1,91 @@
+package main
+
+// ----------------------------------------------------------------
+// Threats
+//
+// A longer snake whose head is beside a cell we could move to might
+// meet us there head to head, but it might well go somewhere else.
+// We estimate the chance it moves to the cell from its open moves,
+// and from how often we have seen it eat food beside its head: it
+// takes food with the share its opponent model gives, or
+// defaultFoodShare if we don't know it, and otherwise picks among its
+// other open moves evenly.  Where we are in a stand-off with it, the
+// chance comes from the stand-off instead.
+//
+// A move whose chance of a head to head with a longer snake is no
+// more than the threatTolerance weight isn't ruled out, but costs
+// threatWeight times the chance in the final weighing.  Above it, or
+// with a tolerance of 0, the move is avoided as before unless every
+// move is threatened.
+//
+// Looking a move further ahead, a longer snake two cells from where
+// we could go next can meet us there on the move after this one.  The
+// share of our next moves that are open to a longer snake in this way
+// costs twoStepWeight, and a move that leaves every one of them open
+// isn't taken for food, an attack or an edge trap without weighing it
+// up.
+// ----------------------------------------------------------------
+
+// The chance that a snake moves to c, a cell beside its head
+func (s *GameState) HeadOnChance (snake int, c Coord) float64 {
+	head := s.snakes[snake].head
+	if ManDist(head, c) != 1 { return 0 }
+
+	var buf [4]Coord
+	nfood, nother := 0, 0
+	for _,neighbour := range s.Neighbours(head, &buf) {
+		if neighbour != c && !s.IsFree(neighbour) { continue }
+		if s.IsFood(neighbour) { nfood++ } else { nother++ }
+	}
+
+	share := s.snakes[snake].foodShare
+	switch {
+	case nfood == 0:	return 1 / float64(nother)
+	case nother == 0:	return 1 / float64(nfood)
+	case s.IsFood(c):	return share / float64(nfood)
+	}
+	return (1 - share) / float64(nother)
+}
+
+// The chance that a move meets any of the longer snakes beside it
+// head to head
+func (s *GameState) Threat (c Coord) float64 {
+	safe := 1.0
+	var buf [4]Coord
+	for _,neighbour := range s.Neighbours(c, &buf) {
+		if !s.IsHead(neighbour) || neighbour == s.snakes[0].head { continue }
+		snake := s.SnakeNo(neighbour)
+		if s.snakes[snake].length < s.snakes[0].length { continue }
+		chance, ok := s.StandOffChance(snake, c)
+		if !ok { chance = s.HeadOnChance(snake, c) }
+		safe *= 1 - chance
+	}
+	return 1 - safe
+}
+
+// The share of the moves on from c that a longer snake could meet us
+// on, by getting there in two moves without going through c
+func (s *GameState) TwoStepThreat (c Coord) float64 {
+	myHead := s.snakes[0].head
+	var buf, between [4]Coord
+	onward, contested := 0, 0
+	for _,next := range s.Neighbours(c, &buf) {
+		if next == myHead || !s.IsFree(next) { continue }
+		onward++
+		open := false
+		for _,snake := range s.snakes[1:] {
+			if snake.length < s.snakes[0].length || ManDist(snake.head, next) != 2 { continue }
+			for _,m := range s.Neighbours(next, &between) {
+				if m != c && ManDist(snake.head, m) == 1 && s.IsFree(m) { open = true }
+			}
+		}
+		if open { contested++ }
+	}
+	if onward == 0 { return 0 }
+	return float64(contested) / float64(onward)
+}
+
+// What the risks of a move cost in the final weighing
+func (s *GameState) ThreatCost (move MoveType) float64 {
+	return s.weights.ThreatWeight * move.threat + s.weights.TwoStepWeight * move.contested
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Degradation Tiers
+//
+// How much thinking we do on a turn depends on the size of the arena
+// and on how long earlier turns of the same game took:
+//
+//   full        the heuristics, checked by many deep random rollouts
+//   sampled     the heuristics, checked by a few shallow rollouts
+//   heuristic   the heuristics alone
+//   influence   the safe move that leaves us owning the most cells,
+//               for when even the heuristics are too slow
+//
+// Small boards with few snakes start at full and larger ones at
+// sampled, except in tournaments, where every game starts at full.
+// We then drop to the first tier whose measured time, on average
+// over earlier turns, fits in our budget of half the timeout,
+// leaving the rest for the network, less any time spent waiting for
+// a worker.
+//
+// Rollouts play every snake, us included, randomly but never into a
+// wall or body.  If the move the heuristics chose does clearly worse
+// in them than another, we take the other instead, but only among
+// the moves the heuristics haven't already ruled out: a rollout
+// can't see a longer snake's head or a closing trap coming.
+// ----------------------------------------------------------------
+
+var tiers = []string { "full", "sampled", "heuristic", "influence" }
+
+var rolloutSettings = map[string]struct { n, depth int } {
+	"full":		{ 32, 8 },
+	"sampled":	{ 8, 4 },
+}
+
+// How much worse the chosen move must do in rollouts than another
+// before we switch to it
+const rolloutMargin = 0.25
+
+// What a rollout is worth beyond surviving it, for every opponent
+// eliminated by the end and for being longer than the snakes left
+const rolloutEliminationValue = 0.5
+const rolloutRankValue = 0.25
+
+// Weight of the latest turn in the average time of each tier
+const tierSmoothing = 0.3
+
+// Time we allow ourselves to think, leaving the rest of the timeout
+// for the network
+func MoveBudget (g Game) time.Duration {
+	timeout := g.Timeout
+	if timeout <= 0 { timeout = defaultTimeout }
+	return time.Duration(timeout) * time.Millisecond / 2
+}
+
+func ChooseTier (id string, budget time.Duration, g Game, b Board, weights Weights) string {
+	first := 0
+	if (b.Width * b.Height > 11*11 || len(b.Snakes) > 4) && g.Source != "tournament" { first = 1 }
+	if !weights.Enabled("rollouts") { first = 2 }
+
+	gameContext.RLock()
+	defer gameContext.RUnlock()
+	context, ok := gameContext.m[id]
+	for _,tier := range tiers[first:] {
+		if budget <= 0 { break }
+		if !ok || context.tierTimes[tier] <= budget { return tier }
+	}
+	return tiers[len(tiers)-1]
+}
+
+// Record the time a turn took, returning the tier of the turn before
+func RecordTierTime (id string, tier string, elapsed time.Duration) string {
+	gameContext.Lock()
+	defer gameContext.Unlock()
+	context, ok := gameContext.m[id]
+	if !ok { return tier }
+	last := context.tier
+	context.tier = tier
+	if context.tierTimes == nil { context.tierTimes = make(map[string]time.Duration) }
+
+	if average, ok := context.tierTimes[tier]; ok {
+		elapsed = time.Duration(tierSmoothing * float64(elapsed) + (1 - tierSmoothing) * float64(average))
+	}
+	context.tierTimes[tier] = elapsed
+	return last
+}
+
+// Decide on a move at the tier the game can afford
+func Think (g Game, t int, b Board, y Snake) MoveDecision {
+	return ThinkWithin(g, t, b, y, MoveBudget(g))
+}
+
+// The weights our snake plays with in a game
+func DecisionWeights (g Game, id string) Weights {
+	if context := GetContext(id); context.weights != nil { return *context.weights }
+	return WeightsFor(g.Ruleset.Name)
+}
+
+// Decide on a move at the tier we can afford in the time left
+func ThinkWithin (g Game, t int, b Board, y Snake, budget time.Duration) MoveDecision {
+	start := time.Now()
+	tier := ChooseTier(y.ID, budget, g, b, DecisionWeights(g, y.ID))
+	cancel := NewCancel(start.Add(budget))
+	decision := ThinkAt(tier, g, t, b, y, cancel)
+	decision.CutShort = cancel.Stopped()
+
+	decision.Elapsed = time.Since(start)
+	if last := RecordTierTime(y.ID, tier, decision.Elapsed); last != tier && len(last) > 0 {
+		decision.state.info.Printf("Thinking tier changed from %s to %s\n", last, tier)
+	}
+	return decision
+}
+
+// Decide at the given tier, which is how a recorded decision is made again
+func ThinkAt (tier string, g Game, t int, b Board, y Snake, cancel *Cancel) MoveDecision {
+	var decision MoveDecision
+	if tier == "influence" {
+		decision = InfluenceMove(g, t, b, y, cancel)
+	} else {
+		decision = DecideWithin(g, t, b, y, cancel)
+	}
+
+	decision.Tier = tier
+	var candidates []MoveType
+	for _,move := range decision.Moves {
+		if !move.RuledOut() || move.dir == decision.Move { candidates = append(candidates, move) }
+	}
+
+	var value map[string]float64
+	if settings, ok := rolloutSettings[tier]; ok && len(candidates) > 1 && !decision.state.converting {
+		rollouts := time.Now()
+		rng := rand.New(rand.NewSource(RolloutSeed(g, t)))
+
+		value = make(map[string]float64)
+		best := decision.Move
+		complete := true
+		for _,move := range candidates {
+			value[move.dir], complete = RolloutValue(b, t, y.ID, move.dir, settings.n, settings.depth, rng, cancel)
+			if !complete { break }
+			if value[move.dir] > value[best] { best = move.dir }
+		}
+		if complete && best != decision.Move {
+			d := Disagreement { Turn: t, Heuristic: decision.Move, Reason: decision.Reason,
+								HeuristicValue: value[decision.Move], Search: best, SearchValue: value[best],
+								Overruled: value[best] - value[decision.Move] > rolloutMargin }
+			decision.state.info.Printf("Disagreement %v\n", d)
+			RecordDisagreement(y.ID, g, b, d)
+			if d.Overruled {
+				decision.Move = best
+				decision.Reason = ReasonRollout
+			}
+		}
+		if !complete { value = nil }
+		decision.Phases.Search += time.Since(rollouts)
+	}
+
+	decision.Confidence = decision.EstimateConfidence(value)
+	if g.Source == "tournament" && decision.Confidence < 1 - TenantOf(y.ID).Config.RiskTolerance {
+		if move, ok := decision.SaferMove(); ok {
+			decision.state.info.Printf("Confidence %.2f is low, playing safe with %s\n", decision.Confidence, move)
+			decision.Move = move
+			decision.Reason = ReasonCaution
+		}
+	}
+	return decision
+}
+
+// Has a move been ruled out by the heuristics, as too dangerous or
+// too cramped to take unless nothing better is left?
+func (move MoveType) RuledOut () bool {
+	return move.discarded || move.smallSpace || move.nlonger > 0 || move.squeezed
+}
+
+// ----------------------------------------------------------------
+// Rollouts
+//
+// The game is won by outlasting everyone else, not by surviving, so
+// a rollout we survive is worth 1 plus half a point for every
+// opponent eliminated by its end, as a fraction of the opponents
+// there were, and a quarter for our length rank among the snakes
+// left.  A rollout in which we die is worth nothing.
+// ----------------------------------------------------------------
+
+// Our average value over random games, depth turns long, after
+// making the given move, and whether all of them were played before
+// cancel expired
+func RolloutValue (b Board, t int, you string, first string, n, depth int, rng *rand.Rand,
+				   cancel *Cancel) (float64, bool) {
+	st := NewSearchState(b, t, depth)
+	me := -1
+	for i,snake := range st.Snakes {
+		if snake.ID == you { me = i }
+	}
+	if me < 0 { return 0, true }
+
+	moves := make([]string, len(st.Snakes))
+	total := 0.0
+	for rollout := 0; rollout < n; rollout++ {
+		if cancel.Expired() { return 0, false }
+		for step := 0; step < depth && st.Snakes[me].Alive; step++ {
+			for i := range st.Snakes {
+				if st.Snakes[i].Alive { moves[i] = st.RandomMove(i, rng) }
+			}
+			if step == 0 { moves[me] = first }
+			st.Apply(moves)
+		}
+		total += st.Value(me)
+		for st.Depth() > 0 { st.Undo() }
+	}
+	return total / float64(n), true
+}
+
+// What the position is worth to a snake, as described above
+func (st *SearchState) Value (me int) float64 {
+	if !st.Snakes[me].Alive { return 0 }
+	opponents := len(st.Snakes) - 1
+	if opponents == 0 { return 1 }
+
+	eliminated, shorter, alive := 0, 0, 0
+	for i,snake := range st.Snakes {
+		if i == me { continue }
+		if !snake.Alive {
+			eliminated++
+			continue
+		}
+		alive++
+		if len(snake.Body) < len(st.Snakes[me].Body) { shorter++ }
+	}
+
+	rank := 1.0
+	if alive > 0 { rank = float64(shorter) / float64(alive) }
+	return 1 + rolloutEliminationValue * float64(eliminated) / float64(opponents) + rolloutRankValue * rank
+}
+
+// A random move that doesn't run straight into a wall or body
+func (st *SearchState) RandomMove (i int, rng *rand.Rand) string {
+	head := st.Snakes[i].Body[0]
+	var safe [4]string
+	n := 0
+	for _,o := range neighbourOffsets {
+		c := Coord{ head.X + o.dx, head.Y + o.dy }
+		if st.Index(c) < 0 || st.Occupied(c) { continue }
+		safe[n] = o.dir
+		n++
+	}
+	if n == 0 { return "up" }
+	return safe[rng.Intn(n)]
+}
+
+// Is a cell taken by the body of a live snake, not counting tails
+// that will move out of the way?
+func (st *SearchState) Occupied (c Coord) bool {
+	for _,snake := range st.Snakes {
+		if !snake.Alive { continue }
+		for _,segment := range snake.Body[:len(snake.Body)-1] {
+			if segment == c { return true }
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------
+// Influence
+//
+// Of the moves that don't hit anything or meet a longer snake head
+// to head, the one that leaves us first to reach the most cells.
+// ----------------------------------------------------------------
+
+func InfluenceMove (g Game, t int, b Board, y Snake, cancel *Cancel) MoveDecision {
+	start := time.Now()
+	var s GameState
+	s.cancel = cancel
+	s.lap = start
+	s.debug = NewLogger(y.ID, "DEBUG")
+	s.info = NewLogger(y.ID, "INFO")
+	s.Initialize(g, t, b, y)
+	s.Lap(&s.phases.Initialize)
+	myHead := s.snakes[0].head
+	danger := s.DangerMap()
+
+	var moves []MoveType
+	best := -1
+	bestOwned := -1
+	var buf [4]Coord
+	for _,c := range s.Neighbours(myHead, &buf) {
+		if !s.IsFree(c) { continue }
+		moves = append(moves, MoveType { dir: Direction(myHead, c), c: c, nlonger: danger[c.Y][c.X] })
+
+		s.snakes[0].head = c
+		owned := 0
+		for _,row := range s.VoronoiMap() {
+			for _,owner := range row {
+				if owner == 0 { owned++ }
+			}
+		}
+		s.snakes[0].head = myHead
+		moves[len(moves)-1].value = float64(owned)
+
+		if danger[c.Y][c.X] > 0 { owned -= s.w * s.h }
+		if owned > bestOwned {
+			best = len(moves)-1
+			bestOwned = owned
+		}
+	}
+
+	s.Lap(&s.phases.Search)
+	decision := MoveDecision { Move: "left", Reason: ReasonSuicide, Moves: moves, Phases: s.phases, state: &s }
+	if best >= 0 {
+		decision.Move = moves[best].dir
+		decision.Reason = ReasonInfluence
+	}
+	decision.Elapsed = time.Since(start)
+	return decision
+}
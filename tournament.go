@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Tournaments
+//
+// Play many simulated games between versions of our strategy, each
+// given by a config file, and baseline bots, keeping Elo ratings for
+// every participant across runs:
+//
+//   spacey-snake tournament -games 200 v11.json v12.json random
+//
+// "default" is the engine with its built in weights and "random" is
+// a bot that moves randomly but never straight into a wall or body.
+// "without:rule,rule" is the default engine with those rules switched
+// off, for measuring what each rule is worth:
+//
+//   spacey-snake tournament default without:edge-trap without:squeeze
+//
+// The name of a preset plays that style, e.g. "hunter", and a URL
+// plays the Battlesnake server there.
+// ----------------------------------------------------------------
+
+type Participant struct {
+	Name	string
+	Weights	*Weights		// weights for the engine, unless this is a bot
+	Bot		func (b Board, you Snake, rng *rand.Rand) string
+	Remote	*RemoteSnake	// a server to ask for moves, unless this is ours
+}
+
+// The participant's move for a snake in a simulated game
+func (p Participant) Move (sim *Simulation, snake Snake, rng *rand.Rand) string {
+	switch {
+	case p.Bot != nil:		return p.Bot(sim.Board, snake, rng)
+	case p.Remote != nil:	return p.Remote.Move(sim.Game, sim.Turn, sim.Board, snake)
+	}
+	move := FindMove(sim.Game, sim.Turn, sim.Board, snake)
+	UpdateContext(snake.ID, sim.Board.Snakes, sim.Board.Food)
+	return move
+}
+
+func LoadParticipant (spec string, ruleset string) (Participant, error) {
+	switch spec {
+	case "random":
+		return Participant { Name: spec, Bot: RandomMove }, nil
+	case "default":
+		weights := DefaultWeights()
+		return Participant { Name: spec, Weights: &weights }, nil
+	}
+
+	if IsRemote(spec) {
+		remote, err := NewRemoteSnake(spec)
+		if err != nil { return Participant{}, err }
+		name := strings.TrimSuffix(remote.URL.Host + remote.URL.Path, "/")
+		return Participant { Name: name, Remote: remote }, nil
+	}
+
+	if strings.HasPrefix(spec, "without:") {
+		weights := DefaultWeights()
+		weights.Disable = strings.Split(strings.TrimPrefix(spec, "without:"), ",")
+		if err := CheckRules(weights.Disable); err != nil { return Participant{}, err }
+		name := "without-" + strings.Join(weights.Disable, "-")
+		return Participant { Name: name, Weights: &weights }, nil
+	}
+
+	if _, ok := config.presetJSON(spec); ok {
+		weights, err := PresetWeights(spec, ruleset)
+		return Participant { Name: spec, Weights: &weights }, err
+	}
+
+	c, err := ReadConfig(spec)
+	if err != nil { return Participant{}, err }
+	weights := c.WeightsFor(ruleset)
+	name := strings.TrimSuffix(filepath.Base(spec), filepath.Ext(spec))
+	return Participant { Name: name, Weights: &weights }, nil
+}
+
+// Move randomly, avoiding walls and bodies
+func RandomMove (b Board, you Snake, rng *rand.Rand) string {
+	blocked := make(map[Coord]bool)
+	for _,snake := range b.Snakes {
+		for _,segment := range snake.Body[:len(snake.Body)-1] { blocked[segment] = true }
+	}
+
+	var safe []string
+	for _,dir := range []string { "up", "down", "left", "right" } {
+		c := Step(you.Body[0], dir)
+		if c.X < 0 || c.X >= b.Width || c.Y < 0 || c.Y >= b.Height || blocked[c] { continue }
+		safe = append(safe, dir)
+	}
+	if len(safe) == 0 { return "up" }
+	return safe[rng.Intn(len(safe))]
+}
+
+// ----------------------------------------------------------------
+// Random games
+// ----------------------------------------------------------------
+
+// Set up a standard game, with snakes stacked on random start points
+// and a food next to each of them plus one in the center
+func NewRandomGame (id string, ruleset string, w, h int, ids []string, rng *rand.Rand) (Game, Board) {
+	g := Game { ID: id, Ruleset: Ruleset { Name: ruleset } }
+	b := Board { Width: w, Height: h }
+
+	starts := []Coord {
+		{ 1, 1 }, { w-2, h-2 }, { 1, h-2 }, { w-2, 1 },
+		{ w/2, 1 }, { w/2, h-2 }, { 1, h/2 }, { w-2, h/2 },
+	}
+	rng.Shuffle(len(starts), func(i, j int) { starts[i], starts[j] = starts[j], starts[i] })
+
+	for i,id := range ids {
+		start := starts[i % len(starts)]
+		b.Snakes = append(b.Snakes, Snake {
+			ID:		id,
+			Name:	id,
+			Health:	100,
+			Body:	[]Coord { start, start, start },
+		})
+		food := start
+		if start.Y < h/2 { food.Y++ } else { food.Y-- }
+		if start.X < w/2 { food.X++ } else { food.X-- }
+		b.Food = append(b.Food, food)
+	}
+	b.Food = append(b.Food, Coord{ w/2, h/2 })
+	return g, b
+}
+
+// Spawn food as the standard rules do: always keep a minimum on the
+// board, and otherwise add one with some chance each turn
+func (sim *Simulation) SpawnFood (rng *rand.Rand, minimum int, chance float64) {
+	b := &sim.Board
+	if len(b.Food) >= minimum && rng.Float64() >= chance { return }
+
+	occupied := make(map[Coord]bool)
+	for _,food := range b.Food { occupied[food] = true }
+	for _,snake := range b.Snakes {
+		for _,segment := range snake.Body { occupied[segment] = true }
+	}
+
+	var free []Coord
+	for x := 0; x < b.Width; x++ {
+		for y := 0; y < b.Height; y++ {
+			if !occupied[Coord{ x, y }] { free = append(free, Coord{ x, y }) }
+		}
+	}
+	if len(free) > 0 { b.Food = append(b.Food, free[rng.Intn(len(free))]) }
+}
+
+type GameResult struct {
+	Places		map[string]int		// place each snake finished in, 0 for the winner
+	Survived	map[string]int		// turns each snake survived
+}
+
+// The IDs of the players of a game, in order, so that their contexts
+// are set up the same way every time
+func ParticipantIDs (players map[string]Participant) []string {
+	var ids []string
+	for id := range players { ids = append(ids, id) }
+	sort.Strings(ids)
+	return ids
+}
+
+// Play a game to the end.  Snakes eliminated on the same turn share
+// a place.
+func PlayGame (g Game, b Board, players map[string]Participant, rng *rand.Rand, maxTurns int) GameResult {
+	sim := NewSimulation(g, 0, b)
+	snakes := make(map[string]Snake)
+	for _,snake := range sim.Board.Snakes { snakes[snake.ID] = snake }
+	for _,id := range ParticipantIDs(players) {
+		player := players[id]
+		if player.Remote != nil {
+			if err := player.Remote.Start(sim.Game, sim.Turn, sim.Board, snakes[id]); err != nil { fmt.Fprintf(os.Stderr, "%v\n", err) }
+		}
+		if player.Weights == nil { continue }
+		NewContext(id, sim.Board)
+		weights := *player.Weights
+		GetContext(id).weights = &weights
+	}
+
+	for !sim.Over() && sim.Turn < maxTurns {
+		moves := make(map[string]string)
+		for _,snake := range sim.Board.Snakes {
+			moves[snake.ID] = players[snake.ID].Move(sim, snake, rng)
+			snakes[snake.ID] = snake
+		}
+		sim.Step(moves)
+		sim.SpawnFood(rng, 1, 0.15)
+	}
+
+	for _,id := range ParticipantIDs(players) {
+		player := players[id]
+		if player.Remote != nil {
+			you := snakes[id]
+			if snake, ok := sim.Snake(id); ok { you = snake }
+			player.Remote.End(sim.Game, sim.Turn, sim.Board, you)
+		}
+		gameContext.Lock()
+		delete(gameContext.m, id)
+		gameContext.Unlock()
+	}
+
+	// Survivors share first place, then snakes in reverse order of elimination
+	places := make(map[string]int)
+	survived := make(map[string]int)
+	for _,snake := range sim.Board.Snakes {
+		places[snake.ID] = 0
+		survived[snake.ID] = sim.Turn
+	}
+	for _,e := range sim.Eliminated { survived[e.ID] = e.Turn }
+	place := len(sim.Board.Snakes)
+	for i := len(sim.Eliminated)-1; i >= 0; {
+		turn := sim.Eliminated[i].Turn
+		n := 0
+		for ; i >= 0 && sim.Eliminated[i].Turn == turn; i-- {
+			places[sim.Eliminated[i].ID] = place
+			n++
+		}
+		place += n
+	}
+	return GameResult { places, survived }
+}
+
+// ----------------------------------------------------------------
+// Elo ratings
+//
+// A game between several snakes counts as a match between every
+// pair of them, decided by which finished in the better place.
+// ----------------------------------------------------------------
+
+const initialRating = 1500
+const eloK = 32
+
+type Rating struct {
+	Rating	float64
+	Games	int
+}
+
+func ReadRatings (path string) (map[string]*Rating, error) {
+	ratings := make(map[string]*Rating)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) { return ratings, nil }
+	if err != nil { return nil, err }
+	if err := json.Unmarshal(data, &ratings); err != nil { return nil, err }
+	return ratings, nil
+}
+
+func WriteRatings (path string, ratings map[string]*Rating) error {
+	data, err := json.MarshalIndent(ratings, "", "  ")
+	if err != nil { return err }
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func UpdateRatings (ratings map[string]*Rating, places map[string]int) {
+	if len(places) < 2 { return }
+
+	delta := make(map[string]float64)
+	k := eloK / float64(len(places)-1)
+	for a,pa := range places {
+		for b,pb := range places {
+			if a == b { continue }
+			score := 0.5
+			if pa < pb { score = 1 } else if pa > pb { score = 0 }
+			expected := 1 / (1 + math.Pow(10, (ratings[b].Rating - ratings[a].Rating) / 400))
+			delta[a] += k * (score - expected)
+		}
+	}
+	for name,d := range delta {
+		ratings[name].Rating += d
+		ratings[name].Games++
+	}
+}
+
+func TournamentCommand (args []string) int {
+	flags := flag.NewFlagSet("tournament", flag.ExitOnError)
+	games := flags.Int("games", 100, "number of games to play")
+	ratingsPath := flags.String("ratings", "ratings.json", "file to keep ratings in")
+	ruleset := flags.String("ruleset", "standard", "ruleset to play and take weights for")
+	size := flags.Int("size", 11, "width and height of the board")
+	maxTurns := flags.Int("turns", 500, "maximum turns per game")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "random seed")
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		fmt.Fprintf(os.Stderr, "Usage: spacey-snake tournament [flags] participant participant...\n")
+		return 2
+	}
+
+	var participants []Participant
+	names := make(map[string]bool)
+	for _,spec := range flags.Args() {
+		participant, err := LoadParticipant(spec, *ruleset)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		if names[participant.Name] {
+			fmt.Fprintf(os.Stderr, "Participant %s entered twice\n", participant.Name)
+			return 2
+		}
+		names[participant.Name] = true
+		participants = append(participants, participant)
+	}
+
+	ratings, err := ReadRatings(*ratingsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _,participant := range participants {
+		if _, ok := ratings[participant.Name]; !ok {
+			ratings[participant.Name] = &Rating { Rating: initialRating }
+		}
+	}
+
+	engineLogging = false
+	rng := rand.New(rand.NewSource(*seed))
+	wins := make(map[string]int)
+
+	for game := 0; game < *games; game++ {
+		players := make(map[string]Participant)
+		var ids []string
+		for i,participant := range participants {
+			id := fmt.Sprintf("%d-%d", game, i)
+			players[id] = participant
+			ids = append(ids, id)
+		}
+
+		g, b := NewRandomGame(fmt.Sprintf("tournament-%d", game), *ruleset, *size, *size, ids, rng)
+		result := PlayGame(g, b, players, rng, *maxTurns)
+
+		byName := make(map[string]int)
+		for id,place := range result.Places {
+			byName[players[id].Name] = place
+			if place == 0 { wins[players[id].Name]++ }
+		}
+		UpdateRatings(ratings, byName)
+	}
+
+	if err := WriteRatings(*ratingsPath, ratings); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	sort.Slice(participants, func(i, j int) bool {
+		return ratings[participants[i].Name].Rating > ratings[participants[j].Name].Rating
+	})
+	for _,participant := range participants {
+		rating := ratings[participant.Name]
+		fmt.Printf("%-20s rating=%.0f games=%d wins=%d/%d", participant.Name, rating.Rating,
+				   rating.Games, wins[participant.Name], *games)
+		if participant.Remote != nil { fmt.Printf(" failed-moves=%d", participant.Remote.Failures()) }
+		fmt.Printf("\n")
+	}
+	return 0
+}
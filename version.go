@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// ----------------------------------------------------------------
+// Build Info
+//
+// Which code and weights are playing.  The commit and build time are
+// set at link time:
+//
+//   go build -ldflags "-X main.commit=$(git rev-parse HEAD) \
+//                      -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// The same info is served from /version and stored in every game we
+// record, so that a replay can be matched to what produced it.
+// ----------------------------------------------------------------
+
+var commit = "unknown"
+var buildTime = "unknown"
+
+type BuildInfo struct {
+	Commit		string	`json:"commit"`
+	BuildTime	string	`json:"buildTime"`
+	GoVersion	string	`json:"goVersion"`
+	Config		string	`json:"config"`		// hash of the config in use
+}
+
+// A short hash of the config we are playing with, weights and all
+func ConfigHash () string {
+	data, _ := json.Marshal(config)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+func Build () BuildInfo {
+	return BuildInfo {
+		Commit:		commit,
+		BuildTime:	buildTime,
+		GoVersion:	runtime.Version(),
+		Config:		ConfigHash(),
+	}
+}
+
+func HandleVersion (w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Build())
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Write-Ahead Log
+//
+// What we learn over a game, such as which opponents are slow to
+// respond, how their space has been going and how long each tier of
+// thinking takes, lives in the game's context along with what /start
+// gave it, its weights, experiments and quirks, so a restart mid-game
+// used to leave us playing turn 150 as if we knew nothing.  With
+// WAL_DIR set, the server logs that state for each game it is
+// playing, one file per game, and picks up where it left off after a
+// restart:
+//
+//   WAL_DIR=/var/lib/spacey-snake/wal spacey-snake
+//
+// Each line of a game's log is the whole of its learned state after
+// a turn, which is small and bounded, so recovery only needs the last
+// line that was written whole; one cut short by a crash is ignored.
+// Lines are appended and synced in the background, each game's log
+// under its own lock, so neither a move nor another game waits on a
+// sync.  Every so often the log is rewritten to its last line,
+// through a temporary file renamed over it, and at /end it is
+// removed.  Logs not written to for walStale are of games
+// that ended while we were down, and are removed at startup.
+//
+// The game's record and metrics aren't logged, so the record of a
+// recovered game starts from the turn after the restart.
+// ----------------------------------------------------------------
+
+const (
+	walCompactEvery	= 64				// entries before a log is rewritten to its last
+	walStale		= time.Hour
+)
+
+type LearnedState struct {
+	Turn		int
+	Color		string
+	Source		string				`json:",omitempty"`
+	Tenant		string				`json:",omitempty"`
+	Weights		*Weights			`json:",omitempty"`
+	Experiments	map[string]bool		`json:",omitempty"`
+	Quirks		Quirks
+	Heads		[]SnakeHead
+	Food		[]Coord
+	Spaces		map[string][]int	`json:",omitempty"`
+	SpaceTurn	int
+	Latencies	map[string][]int	`json:",omitempty"`
+	LatencyTurn	int
+	LastMove	string				`json:",omitempty"`
+	Shouted		map[string]int		`json:",omitempty"`
+	Tier		string				`json:",omitempty"`
+	TierTimes	map[string]time.Duration	`json:",omitempty"`
+}
+
+var wal struct {
+	sync.Mutex
+	dir		string
+	files	map[string]*walFile
+}
+
+// The log of one game, locked apart from every other's so that no
+// game waits on another's writes
+type walFile struct {
+	sync.Mutex
+	path	string
+	f		*os.File
+	entries	int
+	turn	int			// the latest turn written
+	ended	bool		// the game is over, so nothing more is written
+}
+
+func walPath (id string) string {
+	return filepath.Join(wal.dir, url.PathEscape(id) + ".wal")
+}
+
+// The state learned so far in the game of one of our snakes
+func (c *ContextType) Learned (turn int) LearnedState {
+	return LearnedState {
+		Turn:			turn,
+		Color:			c.color,
+		Source:			c.source,
+		Tenant:			c.Tenant().Name,
+		Weights:		c.weights,
+		Experiments:	c.experiments,
+		Heads:			c.heads,
+		Food:			c.food,
+		Spaces:			c.spaces,
+		SpaceTurn:		c.spaceTurn,
+		Latencies:		c.latencies,
+		LatencyTurn:	c.latencyTurn,
+		LastMove:		c.lastMove,
+		Shouted:		c.shouted,
+		Tier:			c.tier,
+		TierTimes:		c.tierTimes,
+	}
+}
+
+// A context for a game from what had been learned in it
+func (state LearnedState) Context () *ContextType {
+	c := &ContextType {
+		color:			state.Color,
+		source:			state.Source,
+		weights:		state.Weights,
+		experiments:	state.Experiments,
+		heads:			state.Heads,
+		food:			state.Food,
+		spaces:			state.Spaces,
+		spaceTurn:		state.SpaceTurn,
+		latencies:		state.Latencies,
+		latencyTurn:	state.LatencyTurn,
+		lastMove:		state.LastMove,
+		shouted:		state.Shouted,
+		tier:			state.Tier,
+		tierTimes:		state.TierTimes,
+	}
+	if c.spaces == nil { c.spaces = make(map[string][]int) }
+	if len(state.Tenant) > 0 {
+		if t, ok := TenantNamed(state.Tenant); ok {
+			c.tenant = t
+		} else {
+			fmt.Printf("WARN: Recovered game for unknown tenant %s, playing it as ours\n", state.Tenant)
+		}
+	}
+	if c.weights != nil {
+		var names []string
+		for name,in := range c.experiments {
+			if in { names = append(names, name) }
+		}
+		sort.Strings(names)
+		c.weights.Experiments = names
+	}
+	return c
+}
+
+// Log what has been learned in a game as of a turn, if we keep logs.
+// The state is taken now, but written and synced in the background,
+// one game's entries in turn order and every game apart.
+func LogLearnedState (id string, turn int) {
+	wal.Lock()
+	dir := wal.dir
+	wal.Unlock()
+	if len(dir) == 0 { return }
+
+	gameContext.RLock()
+	context, ok := gameContext.m[id]
+	var data []byte
+	var err error
+	if ok {
+		state := context.Learned(turn)
+		state.Quirks, _ = GameQuirks(id)
+		data, err = json.Marshal(state)
+	}
+	gameContext.RUnlock()
+	if !ok { return }
+	if err != nil {
+		fmt.Printf("ERROR: Unable to log game state: %v\n", err)
+		return
+	}
+
+	wal.Lock()
+	file, ok := wal.files[id]
+	if !ok {
+		file = &walFile { path: walPath(id) }
+		wal.files[id] = file
+	}
+	wal.Unlock()
+
+	go func() {
+		if err := file.Append(dir, turn, append(data, '\n')); err != nil {
+			fmt.Printf("ERROR: Unable to log game state: %v\n", err)
+		}
+	}()
+}
+
+func (file *walFile) Append (dir string, turn int, line []byte) error {
+	file.Lock()
+	defer file.Unlock()
+	if file.ended || turn < file.turn { return nil }
+	file.turn = turn
+
+	if file.f == nil || file.entries >= walCompactEvery {
+		// Start the log afresh from this entry
+		if file.f != nil { file.f.Close() }
+		file.f = nil
+		if err := rewriteWAL(dir, file.path, line); err != nil { return err }
+		f, err := os.OpenFile(file.path, os.O_WRONLY | os.O_APPEND, 0644)
+		if err != nil { return err }
+		file.f, file.entries = f, 1
+		return nil
+	}
+
+	if _, err := file.f.Write(line); err != nil { return err }
+	file.entries++
+	return file.f.Sync()
+}
+
+// Replace a log with one line, so that it is never seen half written
+func rewriteWAL (dir string, path string, line []byte) error {
+	f, err := ioutil.TempFile(dir, ".wal")
+	if err != nil { return err }
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	f.Close()
+	return os.Rename(f.Name(), path)
+}
+
+// Stop logging a game that is over, along with any of its entries
+// still to be written
+func ForgetLearnedState (id string) {
+	wal.Lock()
+	if len(wal.dir) == 0 {
+		wal.Unlock()
+		return
+	}
+	file, ok := wal.files[id]
+	delete(wal.files, id)
+	path := walPath(id)
+	wal.Unlock()
+
+	if ok {
+		file.Lock()
+		file.ended = true
+		if file.f != nil { file.f.Close() }
+		file.Unlock()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("ERROR: Unable to remove game log: %v\n", err)
+	}
+}
+
+// The last whole entry of a log
+func ReadWAL (path string) (LearnedState, error) {
+	var state LearnedState
+	f, err := os.Open(path)
+	if err != nil { return state, err }
+	defer f.Close()
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1 << 24)
+	for scanner.Scan() {
+		var entry LearnedState
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil { continue }
+		state, found = entry, true
+	}
+	if err := scanner.Err(); err != nil { return state, err }
+	if !found { return state, fmt.Errorf("%s: no whole entry", path) }
+	return state, nil
+}
+
+// Start logging games to WAL_DIR, if set, recovering those that were
+// being played when we stopped
+func RecoverGames () {
+	dir := os.Getenv("WAL_DIR")
+	if len(dir) == 0 { return }
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Unable to log game state: %v\n", err)
+		return
+	}
+
+	wal.Lock()
+	defer wal.Unlock()
+	wal.dir = dir
+	wal.files = make(map[string]*walFile)
+
+	// Rewrites cut short by a crash
+	temps, _ := filepath.Glob(filepath.Join(dir, ".wal*"))
+	for _,path := range temps { os.Remove(path) }
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	for _,path := range paths {
+		info, err := os.Stat(path)
+		if err != nil { continue }
+		if time.Since(info.ModTime()) > walStale {
+			os.Remove(path)
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".wal"))
+		if err != nil { continue }
+		state, err := ReadWAL(path)
+		if err != nil {
+			fmt.Printf("ERROR: Unable to recover game: %v\n", err)
+			continue
+		}
+
+		gameContext.Lock()
+		if _, ok := gameContext.m[id]; !ok { gameContext.m[id] = state.Context() }
+		gameContext.Unlock()
+		SetQuirks(id, state.Quirks)
+		fmt.Printf("INFO(%s): Recovered game of %s at turn %d\n", state.Color, id, state.Turn)
+	}
+}
+
+// Is the log directory, if we have one, there to write to?
+func WALProblems () []string {
+	wal.Lock()
+	dir := wal.dir
+	wal.Unlock()
+	if len(dir) == 0 { return nil }
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return []string { "game state log directory missing: " + dir }
+	}
+	return nil
+}
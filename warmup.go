@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Warm-up
+//
+// The first move of a game used to be the slowest: the JSON codecs
+// build their encoders on first use, the buffer pool starts out
+// empty and nothing the engine touches is in cache yet.  We warm up
+// once for an 11x11 board when the process starts, and again at
+// /start the first time a game announces some other board size, by
+// round-tripping a synthetic request through the codecs, leaving a
+// buffer big enough for it in the pool, and playing a few turns of a
+// synthetic game.  Warm-up games don't log.
+// ----------------------------------------------------------------
+
+var warmedUp = struct {
+	sync.Mutex
+	sizes map[[2]int]bool
+} { sizes: make(map[[2]int]bool) }
+
+// Turns of the synthetic game to play
+const warmUpTurns = 3
+
+func WarmUp (w, h, nsnakes int) {
+	if w < 3 || h < 3 { return }
+	warmedUp.Lock()
+	if warmedUp.sizes[[2]int{ w, h }] {
+		warmedUp.Unlock()
+		return
+	}
+	warmedUp.sizes[[2]int{ w, h }] = true
+	warmedUp.Unlock()
+
+	if nsnakes < 2 { nsnakes = 2 }
+	if nsnakes > 8 { nsnakes = 8 }
+	start := time.Now()
+
+	id := fmt.Sprintf("warm-up-%dx%d", w, h)
+	var ids []string
+	for i := 0; i < nsnakes; i++ { ids = append(ids, fmt.Sprintf("%s-%d", id, i)) }
+	g, b := NewRandomGame(id, "standard", w, h, ids, rand.New(rand.NewSource(1)))
+
+	gameContext.Lock()
+	for _,snakeID := range ids {
+		gameContext.m[snakeID] = &ContextType { color: "warm-up", quiet: true, spaces: make(map[string][]int) }
+	}
+	gameContext.Unlock()
+	defer func() {
+		gameContext.Lock()
+		for _,snakeID := range ids { delete(gameContext.m, snakeID) }
+		gameContext.Unlock()
+	}()
+
+	// The codecs, and a pooled buffer big enough for a request
+	data, _ := json.Marshal(MoveRequest { Game: g, Board: b, You: b.Snakes[0] })
+	var request MoveRequest
+	json.NewDecoder(bytes.NewReader(data)).Decode(&request)
+	json.Marshal(StartResponse{})
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.Grow(2 * len(data))
+	ReleaseBuffer(buf)
+
+	sim := NewSimulation(g, 0, b)
+	for turn := 0; turn < warmUpTurns && !sim.Over(); turn++ {
+		moves := make(map[string]string)
+		for _,snake := range sim.Board.Snakes {
+			moves[snake.ID] = FindMove(sim.Game, sim.Turn, sim.Board, snake)
+			UpdateContext(snake.ID, sim.Board.Snakes, sim.Board.Food)
+		}
+		sim.Step(moves)
+	}
+
+	fmt.Printf("INFO: Warmed up for %dx%d in %dms\n", w, h, time.Since(start).Milliseconds())
+}
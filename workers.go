@@ -0,0 +1,139 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ----------------------------------------------------------------
+// Move Workers
+//
+// When we are registered in many arenas, dozens of /move requests can
+// land at once.  Thinking about all of them together just shares the
+// CPU out until every game times out, so moves take turns with a
+// fixed number of workers, GOMAXPROCS unless MOVE_WORKERS says
+// otherwise.  Waiting moves are served in order of their deadlines,
+// and a move that had to wait thinks within whatever time it has
+// left, dropping down the tiers as it needs to.  A move whose
+// deadline passes while it waits gives up its place in the queue
+// and answers with a safe move instead.
+// ----------------------------------------------------------------
+
+type moveWaiter struct {
+	deadline	time.Time
+	ready		chan struct{}
+	index		int				// in the heap, or -1 once handed a worker
+}
+
+// Waiters in a heap, earliest deadline first
+type moveWaiters []*moveWaiter
+
+func (q moveWaiters) Len () int { return len(q) }
+func (q moveWaiters) Less (i, j int) bool { return q[i].deadline.Before(q[j].deadline) }
+func (q moveWaiters) Swap (i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *moveWaiters) Push (x interface{}) {
+	w := x.(*moveWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+func (q *moveWaiters) Pop () interface{} {
+	old := *q
+	w := old[len(old)-1]
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+var moveWorkers struct {
+	sync.Mutex
+	size		int				// no limit if zero
+	free		int
+	waiting		moveWaiters
+	waits		int64			// moves that had to wait for a worker
+	waited		time.Duration	// total time moves waited
+	expired		int64			// moves whose deadline passed while they waited
+}
+
+func StartMoveWorkers () {
+	n := runtime.GOMAXPROCS(0)
+	if s := os.Getenv("MOVE_WORKERS"); len(s) > 0 {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 { n = v }
+	}
+	moveWorkers.Lock()
+	moveWorkers.size = n
+	moveWorkers.free = n
+	moveWorkers.Unlock()
+}
+
+// Wait for a worker, giving it back with ReleaseMoveWorker, unless
+// the deadline passes first, when there is none to give back
+func AcquireMoveWorker (deadline time.Time) bool {
+	moveWorkers.Lock()
+	if moveWorkers.size == 0 || moveWorkers.free > 0 {
+		moveWorkers.free--
+		moveWorkers.Unlock()
+		return true
+	}
+	w := &moveWaiter { deadline: deadline, ready: make(chan struct{}) }
+	heap.Push(&moveWorkers.waiting, w)
+	moveWorkers.Unlock()
+
+	start := time.Now()
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	select {
+	case <-w.ready:
+	case <-timeout.C:
+		moveWorkers.Lock()
+		// We may have been handed a worker just as the deadline passed
+		if w.index >= 0 {
+			heap.Remove(&moveWorkers.waiting, w.index)
+			moveWorkers.expired++
+			moveWorkers.Unlock()
+			return false
+		}
+		moveWorkers.Unlock()
+	}
+
+	moveWorkers.Lock()
+	moveWorkers.waits++
+	moveWorkers.waited += time.Since(start)
+	moveWorkers.Unlock()
+	return true
+}
+
+func ReleaseMoveWorker () {
+	moveWorkers.Lock()
+	defer moveWorkers.Unlock()
+	if len(moveWorkers.waiting) > 0 {
+		// Hand the worker straight on to the most urgent move
+		close(heap.Pop(&moveWorkers.waiting).(*moveWaiter).ready)
+		return
+	}
+	moveWorkers.free++
+}
+
+func WriteWorkerMetrics (w http.ResponseWriter) {
+	moveWorkers.Lock()
+	defer moveWorkers.Unlock()
+
+	fmt.Fprintf(w, "# HELP spacey_move_workers Moves we think about at once.\n")
+	fmt.Fprintf(w, "# TYPE spacey_move_workers gauge\nspacey_move_workers %d\n", moveWorkers.size)
+	fmt.Fprintf(w, "# HELP spacey_move_queue Moves waiting for a worker.\n")
+	fmt.Fprintf(w, "# TYPE spacey_move_queue gauge\nspacey_move_queue %d\n", len(moveWorkers.waiting))
+	fmt.Fprintf(w, "# HELP spacey_move_waits_total Moves that had to wait for a worker.\n")
+	fmt.Fprintf(w, "# TYPE spacey_move_waits_total counter\nspacey_move_waits_total %d\n", moveWorkers.waits)
+	fmt.Fprintf(w, "# HELP spacey_move_wait_seconds_total Time moves spent waiting for a worker.\n")
+	fmt.Fprintf(w, "# TYPE spacey_move_wait_seconds_total counter\nspacey_move_wait_seconds_total %g\n", moveWorkers.waited.Seconds())
+	fmt.Fprintf(w, "# HELP spacey_move_wait_expired_total Moves whose deadline passed while they waited for a worker.\n")
+	fmt.Fprintf(w, "# TYPE spacey_move_wait_expired_total counter\nspacey_move_wait_expired_total %d\n", moveWorkers.expired)
+}
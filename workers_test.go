@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// A move still waiting for a worker at its deadline leaves the queue,
+// and the worker goes to the next move in it
+func TestMoveWorkerDeadline (t *testing.T) {
+	moveWorkers.Lock()
+	moveWorkers.size, moveWorkers.free, moveWorkers.waiting = 1, 1, nil
+	expired := moveWorkers.expired
+	moveWorkers.Unlock()
+	defer func () {
+		moveWorkers.Lock()
+		moveWorkers.size, moveWorkers.free = 0, 0
+		moveWorkers.Unlock()
+	}()
+
+	if !AcquireMoveWorker(time.Now().Add(time.Second)) { t.Fatal("no worker with one free") }
+
+	queued := func () int {
+		moveWorkers.Lock()
+		defer moveWorkers.Unlock()
+		return len(moveWorkers.waiting)
+	}
+	patient := make(chan bool)
+	go func () { patient <- AcquireMoveWorker(time.Now().Add(time.Minute)) }()
+	for queued() == 0 { time.Sleep(time.Millisecond) }
+
+	if AcquireMoveWorker(time.Now().Add(10 * time.Millisecond)) { t.Error("a move got a worker after its deadline") }
+	if n := queued(); n != 1 { t.Errorf("%d moves queued, expected the one left waiting", n) }
+	moveWorkers.Lock()
+	if moveWorkers.expired != expired + 1 { t.Errorf("%d moves expired, expected 1", moveWorkers.expired - expired) }
+	moveWorkers.Unlock()
+
+	ReleaseMoveWorker()
+	select {
+	case ok := <-patient:
+		if !ok { t.Error("the waiting move got no worker") }
+	case <-time.After(time.Second):
+		t.Error("the worker wasn't handed on")
+	}
+	ReleaseMoveWorker()
+}